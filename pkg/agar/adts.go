@@ -0,0 +1,116 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ADTS header layout.
+const (
+	adtsHeaderSize         = 7
+	adtsHeaderSizeWithCRC  = 9
+	adtsSyncByte           = 0xff
+	adtsSyncMask           = 0xf6
+	adtsSyncValue          = 0xf0
+	adtsFrameLengthHiMask  = 0x03
+	adtsFrameLengthHiShift = 11
+	adtsFrameLengthMdShift = 3
+	adtsFrameLengthLoShift = 5
+	adtsProfileShift       = 6
+	adtsFreqIndexShift     = 2
+	adtsFreqIndexMask      = 0x0f
+	adtsChannelHiMask      = 0x01
+	adtsChannelLoShift     = 6
+	adtsProtectionAbsent   = 0x01
+	aacFramesPerPacket     = 1024
+	ascObjectTypeShift     = 11
+	ascFreqIndexShift      = 7
+	ascChannelConfigShift  = 3
+)
+
+// ErrInvalidADTS is returned when an ADTS stream cannot be split into frames.
+var ErrInvalidADTS = errors.New("invalid ADTS stream")
+
+// aacSampleRates maps the MPEG-4 sampling frequency index to a sample rate.
+//
+//nolint:gochecknoglobals // lookup table
+var aacSampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// adtsStream holds raw AAC packets split from an ADTS stream, with the stream parameters
+// of its first frame.
+type adtsStream struct {
+	Packets       [][]byte
+	ObjectType    int
+	FreqIndex     int
+	ChannelConfig int
+}
+
+// SampleRate returns the sample rate of the stream, or 0 for a reserved frequency index.
+func (s adtsStream) SampleRate() int {
+	if s.FreqIndex < len(aacSampleRates) {
+		return aacSampleRates[s.FreqIndex]
+	}
+
+	return 0
+}
+
+// AudioSpecificConfig returns the two-byte MPEG-4 AudioSpecificConfig for the stream.
+func (s adtsStream) AudioSpecificConfig() []byte {
+	//nolint:gosec // G115: fields are 5, 4 and 4 bits wide.
+	value := uint16(s.ObjectType<<ascObjectTypeShift | s.FreqIndex<<ascFreqIndexShift |
+		s.ChannelConfig<<ascChannelConfigShift)
+
+	return binary.BigEndian.AppendUint16(nil, value)
+}
+
+// splitADTS splits an ADTS stream into raw AAC packets (headers and CRCs removed).
+func splitADTS(data []byte) (adtsStream, error) {
+	var stream adtsStream
+
+	for offset := 0; offset < len(data); {
+		header := data[offset:]
+		if len(header) < adtsHeaderSize || header[0] != adtsSyncByte || header[1]&adtsSyncMask != adtsSyncValue {
+			return adtsStream{}, fmt.Errorf("%w: no sync word at offset %d", ErrInvalidADTS, offset)
+		}
+
+		frameLength := int(header[3]&adtsFrameLengthHiMask)<<adtsFrameLengthHiShift |
+			int(header[4])<<adtsFrameLengthMdShift | int(header[5])>>adtsFrameLengthLoShift
+
+		headerSize := adtsHeaderSize
+		if header[1]&adtsProtectionAbsent == 0 {
+			headerSize = adtsHeaderSizeWithCRC
+		}
+
+		if frameLength < headerSize || offset+frameLength > len(data) {
+			return adtsStream{}, fmt.Errorf("%w: bad frame length %d at offset %d", ErrInvalidADTS, frameLength, offset)
+		}
+
+		if len(stream.Packets) == 0 {
+			stream.ObjectType = int(header[2]>>adtsProfileShift) + 1
+			stream.FreqIndex = int(header[2]>>adtsFreqIndexShift) & adtsFreqIndexMask
+			stream.ChannelConfig = int(header[2]&adtsChannelHiMask)<<2 | int(header[3]>>adtsChannelLoShift)
+		}
+
+		stream.Packets = append(stream.Packets, header[headerSize:frameLength])
+		offset += frameLength
+	}
+
+	return stream, nil
+}
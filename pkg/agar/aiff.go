@@ -0,0 +1,160 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"path/filepath"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+)
+
+// AIFF layout constants.
+const (
+	extendedExponentBias = 16383
+	extendedMantissaTop  = 63
+)
+
+// ErrNotAIFF is returned when data does not start with a FORM header.
+var ErrNotAIFF = errors.New("not an AIFF container")
+
+// AIFFFile describes an AIFF (or AIFF-C) container.
+// Chunks share the RIFF layout, with big-endian sizes.
+type AIFFFile struct {
+	// FormType is "AIFF" or "AIFC".
+	FormType string
+	// Chunks are written in order, exactly as given.
+	Chunks []RIFFChunk
+	// OverrideSize writes DeclaredSize in the FORM header instead of the actual size.
+	OverrideSize bool
+	// DeclaredSize is the FORM size field written when OverrideSize is set.
+	DeclaredSize uint32
+}
+
+// Bytes serializes the container.
+func (f AIFFFile) Bytes() []byte {
+	return encodeContainer("FORM", f.FormType, f.Chunks, f.OverrideSize, f.DeclaredSize, binary.BigEndian)
+}
+
+// Chunk returns the first chunk with the given identifier.
+func (f AIFFFile) Chunk(id string) (RIFFChunk, bool) {
+	return findChunk(f.Chunks, id)
+}
+
+// ReadAIFF parses the top-level chunks of an AIFF or AIFF-C container, with the same leniency as ReadRIFF.
+func ReadAIFF(data []byte) (AIFFFile, error) {
+	formType, chunks, declared, override, ok := decodeContainer(data, "FORM", binary.BigEndian)
+	if !ok {
+		return AIFFFile{}, ErrNotAIFF
+	}
+
+	return AIFFFile{FormType: formType, Chunks: chunks, DeclaredSize: declared, OverrideSize: override}, nil
+}
+
+// WalkAIFFChunks locates the top-level chunks of an AIFF or AIFF-C container.
+func WalkAIFFChunks(data []byte) ([]ChunkLayout, error) {
+	if len(data) < formHeaderSize || string(data[:fourCCSize]) != "FORM" {
+		return nil, ErrNotAIFF
+	}
+
+	return walkChunkList(data[formHeaderSize:], formHeaderSize, binary.BigEndian), nil
+}
+
+// BuildAIFF assembles an AIFF container around little-endian pcm (as produced by GenerateWhiteNoise),
+// converting samples to big-endian. Only SampleRate, BitDepth and Channels of format are used.
+// Extra chunks are placed between COMM and SSND.
+func BuildAIFF(format WAVFormat, pcm []byte, extra []RIFFChunk) AIFFFile {
+	bytesPerSample := PCMBytesPerSample(format.BitDepth)
+
+	frames := 0
+	if blockAlign := format.BlockAlign(); blockAlign > 0 {
+		frames = len(pcm) / blockAlign
+	}
+
+	comm := aiffCommData(format, frames)
+
+	ssnd := make([]byte, 2*fourCCSize, 2*fourCCSize+len(pcm)) // offset and block size, both zero
+	ssnd = append(ssnd, swapEndianness(pcm, bytesPerSample)...)
+
+	chunks := []RIFFChunk{{ID: "COMM", Data: comm}}
+	chunks = append(chunks, extra...)
+	chunks = append(chunks, RIFFChunk{ID: "SSND", Data: ssnd})
+
+	return AIFFFile{FormType: "AIFF", Chunks: chunks}
+}
+
+// aiffCommData serializes the COMM chunk payload. sampleSize is the real bit depth; readers derive the
+// byte width of the left-justified samples from it.
+//
+//nolint:gosec // G115: format fields are small test-controlled values.
+func aiffCommData(format WAVFormat, frames int) []byte {
+	comm := binary.BigEndian.AppendUint16(nil, uint16(format.Channels))
+	comm = binary.BigEndian.AppendUint32(comm, uint32(frames))
+	comm = binary.BigEndian.AppendUint16(comm, uint16(format.BitDepth))
+
+	return append(comm, extendedFloat(uint64(format.SampleRate))...)
+}
+
+// AIFFFixture returns path to a 1 second 16-bit 44.1kHz stereo AIFF with the given extra chunks.
+func AIFFFixture(data test.Data, helpers test.Helpers, name string, extra []RIFFChunk) string {
+	helpers.T().Helper()
+
+	format := DefaultWAVFormat()
+	pcm := GenerateWhiteNoise(format.SampleRate, format.BitDepth, format.Channels, wavFixtureDurationSec)
+
+	path := filepath.Join(data.Temp().Dir(), name)
+	writeFixtureFile(helpers.T(), path, BuildAIFF(format, pcm, extra).Bytes())
+
+	return path
+}
+
+// GenuineAIFF16bit44k returns path to a well-formed AIFF with no metadata chunks.
+func GenuineAIFF16bit44k(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	return AIFFFixture(data, helpers, "genuine-16bit-44k.aiff", nil)
+}
+
+// extendedFloat encodes a positive integer as an 80-bit IEEE 754 extended precision float,
+// as used by the AIFF COMM sample rate field.
+func extendedFloat(value uint64) []byte {
+	out := make([]byte, 2+bitsPerByte)
+	if value == 0 {
+		return out
+	}
+
+	exponent := bits.Len64(value) - 1
+	binary.BigEndian.PutUint16(out, uint16(extendedExponentBias+exponent)) //nolint:gosec // G115: exponent < 64.
+	binary.BigEndian.PutUint64(out[2:], value<<(extendedMantissaTop-exponent))
+
+	return out
+}
+
+// swapEndianness reverses the byte order of each sample.
+func swapEndianness(pcm []byte, bytesPerSample int) []byte {
+	out := make([]byte, len(pcm))
+
+	for offset := 0; offset+bytesPerSample <= len(pcm); offset += bytesPerSample {
+		for i := range bytesPerSample {
+			out[offset+i] = pcm[offset+bytesPerSample-1-i]
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,305 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+	"github.com/containerd/nerdctl/mod/tigron/tig"
+)
+
+// FileAttribute is a file property that in-place editors are expected to preserve, or knowingly change.
+// Its value is the name AttributeChange reports it under.
+type FileAttribute string
+
+// File attributes.
+const (
+	// AttrModTime is the modification time.
+	AttrModTime FileAttribute = "mtime"
+	// AttrAccessTime is the access time. Whether reading a file updates it depends on mount options: with
+	// relatime, the default on Linux, the first read after a write does.
+	AttrAccessTime FileAttribute = "atime"
+	// AttrMode is the permission and type bits.
+	AttrMode FileAttribute = "mode"
+	// AttrOwner is the owning user and group.
+	AttrOwner FileAttribute = "owner"
+	// AttrXattrs is the set of extended attributes and their values.
+	AttrXattrs FileAttribute = "xattrs"
+	// AttrInode is the device and inode number. It changes when the file is replaced through a temporary file
+	// and rename, which breaks hard links.
+	AttrInode FileAttribute = "inode"
+	// AttrLinks is the hard link count.
+	AttrLinks FileAttribute = "links"
+)
+
+// FileAttributes returns every file attribute; AttributesPreserved checks all of them but the access time by default.
+func FileAttributes() []FileAttribute {
+	return []FileAttribute{
+		AttrModTime,
+		AttrAccessTime,
+		AttrMode,
+		AttrOwner,
+		AttrXattrs,
+		AttrInode,
+		AttrLinks,
+	}
+}
+
+// Supported reports whether snapshots observe the attribute on this platform. Only the modification time and
+// mode are observed outside Linux.
+func (a FileAttribute) Supported() bool {
+	return a == AttrModTime || a == AttrMode || attributesExtended
+}
+
+// AttributeSnapshot records the attributes of a file at one point in time.
+type AttributeSnapshot struct {
+	Path       string
+	ModTime    time.Time
+	AccessTime time.Time
+	Mode       os.FileMode
+	UID        uint32
+	GID        uint32
+	Xattrs     map[string][]byte
+	Device     uint64
+	Inode      uint64
+	Links      uint64
+}
+
+// SnapshotAttributes records the attributes of the file at path, following symbolic links.
+func SnapshotAttributes(path string) (AttributeSnapshot, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return AttributeSnapshot{}, err
+	}
+
+	snapshot := AttributeSnapshot{Path: path, ModTime: info.ModTime(), Mode: info.Mode()}
+
+	if err := snapshotExtended(path, info, &snapshot); err != nil {
+		return AttributeSnapshot{}, err
+	}
+
+	return snapshot, nil
+}
+
+// RequireAttributeSnapshot runs SnapshotAttributes, failing the test on error.
+func RequireAttributeSnapshot(helper tig.T, path string) AttributeSnapshot {
+	helper.Helper()
+
+	snapshot, err := SnapshotAttributes(path)
+	if err != nil {
+		helper.Log("snapshotting attributes: " + err.Error())
+		helper.FailNow()
+	}
+
+	return snapshot
+}
+
+// AttributeChange is one attribute that differs between two snapshots.
+type AttributeChange struct {
+	Attribute FileAttribute
+	// Name is the extended attribute name for AttrXattrs, empty otherwise.
+	Name   string
+	Before string
+	After  string
+}
+
+// String describes the change, e.g. "mode: -rw-r--r-- -> -rw-------".
+func (c AttributeChange) String() string {
+	name := string(c.Attribute)
+	if c.Name != "" {
+		name += " " + c.Name
+	}
+
+	return name + ": " + c.Before + " -> " + c.After
+}
+
+// AttributeDiff lists the attributes that differ between two snapshots, in FileAttributes order.
+type AttributeDiff []AttributeChange
+
+// DiffAttributes compares two snapshots of the same file.
+func DiffAttributes(before, after AttributeSnapshot) AttributeDiff {
+	var diff AttributeDiff
+
+	add := func(attr FileAttribute, beforeValue, afterValue string) {
+		if beforeValue != afterValue {
+			diff = append(diff, AttributeChange{Attribute: attr, Before: beforeValue, After: afterValue})
+		}
+	}
+
+	add(AttrModTime, formatAttributeTime(before.ModTime), formatAttributeTime(after.ModTime))
+	add(AttrAccessTime, formatAttributeTime(before.AccessTime), formatAttributeTime(after.AccessTime))
+	add(AttrMode, before.Mode.String(), after.Mode.String())
+	add(AttrOwner, formatAttributeOwner(before), formatAttributeOwner(after))
+	diff = append(diff, diffXattrs(before.Xattrs, after.Xattrs)...)
+	add(AttrInode, formatAttributeInode(before), formatAttributeInode(after))
+	add(AttrLinks, strconv.FormatUint(before.Links, 10), strconv.FormatUint(after.Links, 10))
+
+	return diff
+}
+
+// Changed reports whether the attribute differs.
+func (d AttributeDiff) Changed(attr FileAttribute) bool {
+	return slices.ContainsFunc(d, func(change AttributeChange) bool { return change.Attribute == attr })
+}
+
+// String describes the diff, one change per line.
+func (d AttributeDiff) String() string {
+	if len(d) == 0 {
+		return "no attribute changed"
+	}
+
+	lines := make([]string, 0, len(d))
+	for _, change := range d {
+		lines = append(lines, change.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// RequireAttributes snapshots the file at path again and fails the test unless every attribute in changed
+// differs from before and every attribute in preserved does not. Attributes in neither list are reported but
+// not checked; attributes the platform does not observe are skipped. The diff is logged either way.
+func RequireAttributes(
+	helper tig.T,
+	before AttributeSnapshot,
+	path string,
+	changed, preserved []FileAttribute,
+) AttributeDiff {
+	helper.Helper()
+
+	diff := DiffAttributes(before, RequireAttributeSnapshot(helper, path))
+	helper.Log(path + ":\n" + diff.String())
+
+	failed := false
+
+	for _, attr := range changed {
+		if attr.Supported() && !diff.Changed(attr) {
+			helper.Log("expected " + string(attr) + " to change")
+
+			failed = true
+		}
+	}
+
+	for _, attr := range preserved {
+		if attr.Supported() && diff.Changed(attr) {
+			helper.Log("expected " + string(attr) + " to be preserved")
+
+			failed = true
+		}
+	}
+
+	if failed {
+		helper.FailNow()
+	}
+
+	return diff
+}
+
+// AttributesPreserved returns a comparator for test.Expected.Output that runs RequireAttributes with the given
+// attributes preserved, or every attribute but the access time when none is given. before must be taken
+// before the command runs; tigron calls the Expected manager right before running the command, so taking it
+// there with RequireAttributeSnapshot captures the state left by Setup.
+func AttributesPreserved(before AttributeSnapshot, path string, attrs ...FileAttribute) test.Comparator {
+	if len(attrs) == 0 {
+		attrs = slices.DeleteFunc(FileAttributes(), func(attr FileAttribute) bool { return attr == AttrAccessTime })
+	}
+
+	return func(_ string, helper tig.T) {
+		helper.Helper()
+		RequireAttributes(helper, before, path, nil, attrs)
+	}
+}
+
+// AttributesChanged returns a comparator for test.Expected.Output that runs RequireAttributes with the given
+// attributes changed. Combine it with AttributesPreserved through expect.All.
+func AttributesChanged(before AttributeSnapshot, path string, attrs ...FileAttribute) test.Comparator {
+	return func(_ string, helper tig.T) {
+		helper.Helper()
+		RequireAttributes(helper, before, path, attrs, nil)
+	}
+}
+
+// diffXattrs returns one change per extended attribute added, removed or modified, sorted by name.
+func diffXattrs(before, after map[string][]byte) AttributeDiff {
+	names := make([]string, 0, len(before)+len(after))
+	for name := range before {
+		names = append(names, name)
+	}
+
+	for name := range after {
+		if _, ok := before[name]; !ok {
+			names = append(names, name)
+		}
+	}
+
+	slices.Sort(names)
+
+	var diff AttributeDiff
+
+	for _, name := range names {
+		beforeValue, hadBefore := before[name]
+		afterValue, hasAfter := after[name]
+
+		if hadBefore == hasAfter && bytes.Equal(beforeValue, afterValue) {
+			continue
+		}
+
+		diff = append(diff, AttributeChange{
+			Attribute: AttrXattrs,
+			Name:      name,
+			Before:    formatXattrValue(beforeValue, hadBefore),
+			After:     formatXattrValue(afterValue, hasAfter),
+		})
+	}
+
+	return diff
+}
+
+// formatAttributeTime formats a timestamp with nanoseconds, or "unknown" for the zero time.
+func formatAttributeTime(t time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+
+	return t.Format(time.RFC3339Nano)
+}
+
+// formatAttributeOwner formats the owner as "uid:gid".
+func formatAttributeOwner(s AttributeSnapshot) string {
+	return fmt.Sprintf("%d:%d", s.UID, s.GID)
+}
+
+// formatAttributeInode formats the file identity as "device:inode".
+func formatAttributeInode(s AttributeSnapshot) string {
+	return fmt.Sprintf("%d:%d", s.Device, s.Inode)
+}
+
+// formatXattrValue quotes an extended attribute value, or returns "(absent)".
+func formatXattrValue(value []byte, present bool) string {
+	if !present {
+		return "(absent)"
+	}
+
+	return strconv.Quote(string(value))
+}
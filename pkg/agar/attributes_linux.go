@@ -0,0 +1,107 @@
+//go:build linux
+
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// attributesExtended reports whether snapshots observe more than the modification time and mode.
+const attributesExtended = true
+
+// snapshotExtended fills the owner, access time, identity, link count and extended attributes.
+func snapshotExtended(path string, info os.FileInfo, snapshot *AttributeSnapshot) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return &os.PathError{Op: "stat", Path: path, Err: syscall.ENOTSUP}
+	}
+
+	snapshot.AccessTime = time.Unix(stat.Atim.Unix())
+	snapshot.UID = stat.Uid
+	snapshot.GID = stat.Gid
+	snapshot.Device = uint64(stat.Dev) //nolint:unconvert // Dev is uint32 on some architectures.
+	snapshot.Inode = stat.Ino
+	snapshot.Links = uint64(stat.Nlink) //nolint:unconvert // Nlink is uint32 on some architectures.
+
+	xattrs, err := readXattrs(path)
+	if err != nil {
+		return err
+	}
+
+	snapshot.Xattrs = xattrs
+
+	return nil
+}
+
+// readXattrs returns every extended attribute of path. Filesystems without extended attributes yield none.
+func readXattrs(path string) (map[string][]byte, error) {
+	size, err := syscall.Listxattr(path, nil)
+	if errors.Is(err, syscall.ENOTSUP) {
+		return map[string][]byte{}, nil
+	}
+
+	if err != nil {
+		return nil, &os.PathError{Op: "listxattr", Path: path, Err: err}
+	}
+
+	list := make([]byte, size)
+
+	size, err = syscall.Listxattr(path, list)
+	if err != nil {
+		return nil, &os.PathError{Op: "listxattr", Path: path, Err: err}
+	}
+
+	xattrs := map[string][]byte{}
+
+	for name := range bytes.SplitSeq(list[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+
+		value, err := readXattr(path, string(name))
+		if err != nil {
+			return nil, err
+		}
+
+		xattrs[string(name)] = value
+	}
+
+	return xattrs, nil
+}
+
+// readXattr returns the value of one extended attribute of path.
+func readXattr(path, name string) ([]byte, error) {
+	size, err := syscall.Getxattr(path, name, nil)
+	if err != nil {
+		return nil, &os.PathError{Op: "getxattr " + name, Path: path, Err: err}
+	}
+
+	value := make([]byte, size)
+
+	size, err = syscall.Getxattr(path, name, value)
+	if err != nil {
+		return nil, &os.PathError{Op: "getxattr " + name, Path: path, Err: err}
+	}
+
+	return value[:size], nil
+}
@@ -28,6 +28,7 @@ const (
 	ffmpegBinary        = "ffmpeg"
 	ffprobeBinary       = "ffprobe"
 	soxBinary           = "sox"
+	flacBinary          = "flac"
 	metaflacBinary      = "metaflac"
 	atomicParsleyBinary = "atomicparsley"
 	id3v2Binary         = "id3v2"
@@ -0,0 +1,276 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+)
+
+// Allocation bomb layout constants.
+const (
+	bombSyncsafeMax       = 0x7f
+	bombMP4SampleCount    = 1 << 31
+	bombMP4WrittenSizes   = 4
+	bombMP4SampleDelta    = 1024
+	bombMP4TimeScale      = 44100
+	bombMP4MdatSize       = 16
+	bombVorbisCommentLen  = math.MaxInt32
+	bombPictureFrontCover = 3
+	bombPCMFrames         = 16
+	bombOggSerial         = 0x61676172
+	bombStreamInfoBlock   = 4096
+	bombSampleRate        = 44100
+	bombChannels          = 2
+	bombBitDepth          = 16
+	bombTimeout           = 10 * time.Second
+	bombCPU               = 2 * time.Second
+	bombMemory            = 1 << 30
+)
+
+// ErrUnknownAllocationBomb is returned by BuildAllocationBomb for a value outside the AllocationBomb constants.
+var ErrUnknownAllocationBomb = errors.New("unknown allocation bomb")
+
+// AllocationBomb selects a tiny file whose header declares an enormous size. Its value names the subtest.
+type AllocationBomb string
+
+// Allocation bombs. Every file is a few hundred bytes at most; only the declared size is huge.
+const (
+	// BombID3v2TagSize is an MP3 whose ID3v2 tag declares the syncsafe maximum size (256 MiB - 1).
+	BombID3v2TagSize AllocationBomb = "id3v2-tag-size"
+	// BombFLACPictureLength is a FLAC whose PICTURE block declares 2^32-1 bytes of image data.
+	BombFLACPictureLength AllocationBomb = "flac-picture-length"
+	// BombMP4SampleCount is an M4A whose stsz box declares 2^31 sample sizes.
+	BombMP4SampleCount AllocationBomb = "mp4-stsz-count"
+	// BombOggCommentCount is an Ogg Vorbis whose comment header declares 2^32-1 comments.
+	BombOggCommentCount AllocationBomb = "ogg-comment-count"
+	// BombWAVCbSize is a WAVE_FORMAT_EXTENSIBLE WAV whose fmt cbSize is 65535.
+	BombWAVCbSize AllocationBomb = "wav-cbsize"
+	// BombVorbisCommentLength is a FLAC whose VORBIS_COMMENT has a comment length of 2^31-1, past the end of file.
+	BombVorbisCommentLength AllocationBomb = "vorbis-comment-length"
+)
+
+// AllocationBombs returns every allocation bomb, in the order AllocationBombCases runs them.
+func AllocationBombs() []AllocationBomb {
+	return []AllocationBomb{
+		BombID3v2TagSize,
+		BombFLACPictureLength,
+		BombMP4SampleCount,
+		BombOggCommentCount,
+		BombWAVCbSize,
+		BombVorbisCommentLength,
+	}
+}
+
+// Extension returns the file extension matching the bomb's container, with the leading dot.
+func (b AllocationBomb) Extension() string {
+	switch b {
+	case BombID3v2TagSize:
+		return ".mp3"
+	case BombFLACPictureLength, BombVorbisCommentLength:
+		return ".flac"
+	case BombMP4SampleCount:
+		return ".m4a"
+	case BombOggCommentCount:
+		return ".ogg"
+	case BombWAVCbSize:
+		return ".wav"
+	default:
+		return ".bin"
+	}
+}
+
+// BuildAllocationBomb returns the bytes of the given bomb.
+func BuildAllocationBomb(bomb AllocationBomb) ([]byte, error) {
+	switch bomb {
+	case BombID3v2TagSize:
+		tag := EncodeID3v2(ID3v24, []ID3Frame{ID3TextFrame(ID3v24, "TIT2", "Test Title")})
+		copy(tag[6:id3v2HeaderSize], []byte{bombSyncsafeMax, bombSyncsafeMax, bombSyncsafeMax, bombSyncsafeMax})
+
+		return tag, nil
+	case BombFLACPictureLength:
+		picture := FLACPictureBlock(FLACPicture{
+			Type:     bombPictureFrontCover,
+			MIMEType: CoverJPEG.MIMEType(),
+			Width:    1,
+			Height:   1,
+			Depth:    bitsPerByte * 3, //nolint:mnd // RGB.
+			Data:     []byte{0xff, 0xd8, 0xff, 0xd9},
+		})
+		binary.BigEndian.PutUint32(picture.Data[len(picture.Data)-8:], math.MaxUint32)
+
+		return FLACFile{Blocks: []FLACBlock{bombStreamInfo(), picture}}.Bytes(), nil
+	case BombMP4SampleCount:
+		return bombMP4(), nil
+	case BombOggCommentCount:
+		return bombOggVorbis(), nil
+	case BombWAVCbSize:
+		format := DefaultWAVFormat()
+		format.Extensible = true
+
+		return RIFFFile{FormType: "WAVE", Chunks: []RIFFChunk{
+			{ID: "fmt ", Data: format.fmtData(math.MaxUint16)},
+			{ID: "data", Data: make([]byte, bombPCMFrames*format.BlockAlign())},
+		}}.Bytes(), nil
+	case BombVorbisCommentLength:
+		comment := FLACVorbisCommentBlock(flacVendorString, []string{"TITLE=Test Title"})
+		binary.LittleEndian.PutUint32(comment.Data[len(comment.Data)-len("TITLE=Test Title")-4:], bombVorbisCommentLen)
+
+		return FLACFile{Blocks: []FLACBlock{bombStreamInfo(), comment}}.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownAllocationBomb, bomb)
+	}
+}
+
+// AllocationBombFixture returns path to the given bomb.
+func AllocationBombFixture(data test.Data, helpers test.Helpers, bomb AllocationBomb) string {
+	helpers.T().Helper()
+
+	content, err := BuildAllocationBomb(bomb)
+	if err != nil {
+		helpers.T().Log(err.Error())
+		helpers.T().FailNow()
+	}
+
+	path := filepath.Join(data.Temp().Dir(), "bomb-"+string(bomb)+bomb.Extension())
+	writeFixtureFile(helpers.T(), path, content)
+
+	return path
+}
+
+// DefaultBombLimits returns the limits AllocationBombCases is usually run with: 1 GiB of address space
+// (enough for a Go runtime and a few hundred MiB of heap, well below every declared size), 2 s of CPU
+// and a 10 s timeout.
+func DefaultBombLimits() ResourceLimits {
+	return ResourceLimits{Timeout: bombTimeout, Memory: bombMemory, CPU: bombCPU}
+}
+
+// AllocationBombCases returns one subtest per bomb running the binary under test with args followed by
+// the bomb path, under limits. Each run must exit with exitCode (typically expect.ExitCodeGenericFail)
+// without breaching any limit.
+func AllocationBombCases(limits ResourceLimits, exitCode int, args ...string) []*test.Case {
+	cases := make([]*test.Case, 0, len(AllocationBombs()))
+
+	for _, bomb := range AllocationBombs() {
+		cases = append(cases, &test.Case{
+			Description: string(bomb),
+			Config:      limits.Config(),
+			Command: func(data test.Data, helpers test.Helpers) test.TestableCommand {
+				return helpers.Command(slices.Concat(args, []string{AllocationBombFixture(data, helpers, bomb)})...)
+			},
+			Expected: ExpectWithinLimits(exitCode),
+		})
+	}
+
+	return cases
+}
+
+// bombStreamInfo returns a STREAMINFO block for a stream with no frames.
+func bombStreamInfo() FLACBlock {
+	info := FLACStreamInfo{
+		MinBlockSize: bombStreamInfoBlock,
+		MaxBlockSize: bombStreamInfoBlock,
+		SampleRate:   bombSampleRate,
+		Channels:     bombChannels,
+		BitDepth:     bombBitDepth,
+	}
+
+	return FLACBlock{Type: FLACBlockStreamInfo, Data: info.Bytes()}
+}
+
+// bombOggVorbis returns a Vorbis identification page followed by a comment header page whose comment
+// count is 2^32-1. The setup header and audio are omitted.
+func bombOggVorbis() []byte {
+	identification := vorbisIdentificationHeader(bombChannels, bombSampleRate)
+
+	comment := append([]byte("\x03vorbis"), vorbisCommentData(flacVendorString, []string{"TITLE=Test Title"})...)
+	binary.LittleEndian.PutUint32(comment[len("\x03vorbis")+4+len(flacVendorString):], math.MaxUint32)
+	comment = append(comment, 1)
+
+	return append(
+		encodeOggPage(bombOggSerial, 0, OggPageBOS, 0, identification),
+		encodeOggPage(bombOggSerial, 1, 0, 0, comment)...,
+	)
+}
+
+// bombMP4 returns an AAC M4A skeleton whose stsz declares 2^31 samples but holds only a few entries.
+// stts agrees with the declared count; the single chunk points at a small zero-filled mdat.
+func bombMP4() []byte {
+	ftyp := encodeMP4Box("ftyp", []byte("M4A "), make([]byte, 4), []byte("M4A mp42isom"))
+
+	moov := bombMP4Moov(0)
+	mdatOffset := uint32(len(ftyp) + len(moov) + mp4BoxHeaderSize) //nolint:gosec // G115: small fixture.
+	moov = bombMP4Moov(mdatOffset)
+
+	return append(append(ftyp, moov...), encodeMP4Box("mdat", make([]byte, bombMP4MdatSize))...)
+}
+
+// bombMP4Moov builds the moov box, with the single chunk at chunkOffset.
+//
+//nolint:mnd // ISO BMFF field widths and fixed-point constants.
+func bombMP4Moov(chunkOffset uint32) []byte {
+	be32 := func(values ...uint32) []byte {
+		var out []byte
+		for _, value := range values {
+			out = binary.BigEndian.AppendUint32(out, value)
+		}
+
+		return out
+	}
+
+	matrix := be32(0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000)
+
+	mvhd := encodeMP4FullBox("mvhd", 0, 0, be32(0, 0, bombMP4TimeScale, 0, 0x00010000),
+		[]byte{0x01, 0x00}, make([]byte, 10), matrix, make([]byte, 24), be32(2))
+	tkhd := encodeMP4FullBox("tkhd", 0, 7, be32(0, 0, 1, 0, 0), make([]byte, 8),
+		[]byte{0, 0, 0, 0, 0x01, 0x00, 0, 0}, matrix, be32(0, 0))
+	mdhd := encodeMP4FullBox("mdhd", 0, 0, be32(0, 0, bombMP4TimeScale, 0), []byte{0x55, 0xc4, 0, 0})
+	hdlr := encodeMP4FullBox("hdlr", 0, 0, be32(0), []byte("soun"), make([]byte, 12), []byte("SoundHandler\x00"))
+	smhd := encodeMP4FullBox("smhd", 0, 0, make([]byte, 4))
+	dinf := encodeMP4Box("dinf", encodeMP4FullBox("dref", 0, 0, be32(1), encodeMP4FullBox("url ", 0, 1)))
+
+	// ES_Descriptor > DecoderConfigDescriptor (AAC, audio stream) > AudioSpecificConfig (LC, 44.1 kHz, stereo),
+	// then SLConfigDescriptor.
+	esds := encodeMP4FullBox("esds", 0, 0, []byte{
+		0x03, 25, 0, 0, 0,
+		0x04, 17, 0x40, 0x15, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0x05, 2, 0x12, 0x10,
+		0x06, 1, 0x02,
+	})
+	mp4a := encodeMP4Box("mp4a", make([]byte, 6), []byte{0, 1}, make([]byte, 8),
+		[]byte{0, bombChannels, 0, bombBitDepth, 0, 0, 0, 0}, be32(bombMP4TimeScale<<16), esds)
+
+	stbl := encodeMP4Box("stbl",
+		encodeMP4FullBox("stsd", 0, 0, be32(1), mp4a),
+		encodeMP4FullBox("stts", 0, 0, be32(1, bombMP4SampleCount, bombMP4SampleDelta)),
+		encodeMP4FullBox("stsc", 0, 0, be32(1, 1, bombMP4SampleCount, 1)),
+		encodeMP4FullBox("stsz", 0, 0, be32(0, bombMP4SampleCount), make([]byte, bombMP4WrittenSizes*4)),
+		encodeMP4FullBox("stco", 0, 0, be32(1, chunkOffset)),
+	)
+
+	trak := encodeMP4Box("trak", tkhd,
+		encodeMP4Box("mdia", mdhd, hdlr, encodeMP4Box("minf", smhd, dinf, stbl)))
+
+	return encodeMP4Box("moov", mvhd, trak)
+}
@@ -0,0 +1,441 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+)
+
+// bext chunk layout (EBU Tech 3285 v2).
+const (
+	bextDescriptionSize   = 256
+	bextOriginatorSize    = 32
+	bextOriginatorRefSize = 32
+	bextDateSize          = 10
+	bextTimeSize          = 8
+	bextUMIDSize          = 64
+	bextReservedSize      = 180
+	bextFixedSize         = 602
+	bextVersion2          = 2
+	bextLoudnessScale     = 100
+	uint32Bits            = 32
+)
+
+// Time references, in samples since midnight.
+const (
+	// bwfDefaultTimeReference is one second at 44.1kHz, the rate declared by DefaultBext's coding history.
+	bwfDefaultTimeReference = 44100
+	// bwfFixtureTimeReference is 24 hours at 192kHz: larger than 2^32, so it needs the high word.
+	bwfFixtureTimeReference = 24 * 60 * 60 * 192000
+)
+
+// ErrBextTooShort is returned when a bext chunk is smaller than its fixed-size part.
+var ErrBextTooShort = errors.New("bext chunk shorter than 602 bytes")
+
+// BextChunk models the Broadcast Wave Format bext chunk, version 2 (EBU Tech 3285 v2).
+// Text fields are written as raw bytes, truncated byte-wise to their fixed width.
+type BextChunk struct {
+	Description         string
+	Originator          string
+	OriginatorReference string
+	// OriginationDate is formatted yyyy-mm-dd.
+	OriginationDate string
+	// OriginationTime is formatted hh:mm:ss.
+	OriginationTime string
+	// TimeReference is the first sample count since midnight.
+	TimeReference uint64
+	Version       uint16
+	// UMID is the SMPTE 330M unique material identifier (up to 64 bytes).
+	UMID []byte
+	// Loudness fields are in hundredths of LUFS, LU or dBTP, as stored on disk.
+	LoudnessValue        int16
+	LoudnessRange        int16
+	MaxTruePeakLevel     int16
+	MaxMomentaryLoudness int16
+	MaxShortTermLoudness int16
+	CodingHistory        string
+}
+
+// DefaultBext returns the standard bext test metadata.
+func DefaultBext() BextChunk {
+	umid := make([]byte, bextUMIDSize)
+	for i := range umid {
+		umid[i] = byte(i)
+	}
+
+	return BextChunk{
+		Description:          "Test Description",
+		Originator:           "agar",
+		OriginatorReference:  "AGAR0000000001",
+		OriginationDate:      "2000-01-01",
+		OriginationTime:      "12:34:56",
+		TimeReference:        bwfDefaultTimeReference,
+		Version:              bextVersion2,
+		UMID:                 umid,
+		LoudnessValue:        -2300,
+		LoudnessRange:        850,
+		MaxTruePeakLevel:     -100,
+		MaxMomentaryLoudness: -1500,
+		MaxShortTermLoudness: -1800,
+		CodingHistory:        "A=PCM,F=44100,W=16,M=stereo,T=agar\r\n",
+	}
+}
+
+// Bytes serializes the chunk payload.
+func (b BextChunk) Bytes() []byte {
+	out := make([]byte, 0, bextFixedSize+len(b.CodingHistory))
+
+	out = appendFixed(out, b.Description, bextDescriptionSize)
+	out = appendFixed(out, b.Originator, bextOriginatorSize)
+	out = appendFixed(out, b.OriginatorReference, bextOriginatorRefSize)
+	out = appendFixed(out, b.OriginationDate, bextDateSize)
+	out = appendFixed(out, b.OriginationTime, bextTimeSize)
+	out = binary.LittleEndian.AppendUint32(out, uint32(b.TimeReference))
+	out = binary.LittleEndian.AppendUint32(out, uint32(b.TimeReference>>uint32Bits))
+	out = binary.LittleEndian.AppendUint16(out, b.Version)
+	out = appendFixed(out, string(b.UMID), bextUMIDSize)
+
+	for _, value := range []int16{
+		b.LoudnessValue, b.LoudnessRange, b.MaxTruePeakLevel, b.MaxMomentaryLoudness, b.MaxShortTermLoudness,
+	} {
+		out = binary.LittleEndian.AppendUint16(out, uint16(value)) //nolint:gosec // G115: reinterpret cast.
+	}
+
+	out = append(out, make([]byte, bextReservedSize)...)
+
+	return append(out, b.CodingHistory...)
+}
+
+// Chunk returns the bext chunk ready to be placed in a RIFFFile.
+func (b BextChunk) Chunk() RIFFChunk {
+	return RIFFChunk{ID: "bext", Data: b.Bytes()}
+}
+
+// ParseBext decodes a bext chunk payload.
+func ParseBext(data []byte) (BextChunk, error) {
+	if len(data) < bextFixedSize {
+		return BextChunk{}, fmt.Errorf("%w: %d bytes", ErrBextTooShort, len(data))
+	}
+
+	reader := fixedReader{data: data}
+
+	bext := BextChunk{
+		Description:         reader.text(bextDescriptionSize),
+		Originator:          reader.text(bextOriginatorSize),
+		OriginatorReference: reader.text(bextOriginatorRefSize),
+		OriginationDate:     reader.text(bextDateSize),
+		OriginationTime:     reader.text(bextTimeSize),
+	}
+
+	low := uint64(reader.uint32())
+	bext.TimeReference = low | uint64(reader.uint32())<<uint32Bits
+	bext.Version = reader.uint16()
+	bext.UMID = bytes.Clone(reader.bytes(bextUMIDSize))
+	bext.LoudnessValue = int16(reader.uint16())        //nolint:gosec // G115: reinterpret cast.
+	bext.LoudnessRange = int16(reader.uint16())        //nolint:gosec // G115: reinterpret cast.
+	bext.MaxTruePeakLevel = int16(reader.uint16())     //nolint:gosec // G115: reinterpret cast.
+	bext.MaxMomentaryLoudness = int16(reader.uint16()) //nolint:gosec // G115: reinterpret cast.
+	bext.MaxShortTermLoudness = int16(reader.uint16()) //nolint:gosec // G115: reinterpret cast.
+	reader.bytes(bextReservedSize)
+	bext.CodingHistory = decodeLegacyText(bytes.TrimRight(data[bextFixedSize:], "\x00"))
+
+	return bext, nil
+}
+
+// IXML models the subset of the iXML specification used by field recorders.
+type IXML struct {
+	XMLName   xml.Name    `xml:"BWFXML"`
+	Version   string      `xml:"IXML_VERSION"`
+	Project   string      `xml:"PROJECT,omitempty"`
+	Scene     string      `xml:"SCENE,omitempty"`
+	Take      string      `xml:"TAKE,omitempty"`
+	Tape      string      `xml:"TAPE,omitempty"`
+	Circled   string      `xml:"CIRCLED,omitempty"`
+	Note      string      `xml:"NOTE,omitempty"`
+	TrackList []IXMLTrack `xml:"TRACK_LIST>TRACK,omitempty"`
+}
+
+// IXMLTrack describes one recorded track in an iXML TRACK_LIST.
+type IXMLTrack struct {
+	ChannelIndex    int    `xml:"CHANNEL_INDEX"`
+	InterleaveIndex int    `xml:"INTERLEAVE_INDEX"`
+	Name            string `xml:"NAME"`
+}
+
+// DefaultIXML returns the standard iXML test metadata for a stereo recording.
+func DefaultIXML() IXML {
+	return IXML{
+		Version: "2.10",
+		Project: "Test Project",
+		Scene:   "1A",
+		Take:    "3",
+		Tape:    "Test Tape",
+		Circled: "TRUE",
+		Note:    "Test Note",
+		TrackList: []IXMLTrack{
+			{ChannelIndex: 1, InterleaveIndex: 1, Name: "Left"},
+			{ChannelIndex: 2, InterleaveIndex: 2, Name: "Right"},
+		},
+	}
+}
+
+// Bytes serializes the iXML document, XML declaration included.
+func (x IXML) Bytes() []byte {
+	// IXML holds only strings and integers, which always marshal.
+	body, _ := xml.Marshal(x)
+
+	return append([]byte(xml.Header), body...)
+}
+
+// Chunk wraps the document in an iXML chunk.
+func (x IXML) Chunk() RIFFChunk {
+	return RIFFChunk{ID: "iXML", Data: x.Bytes()}
+}
+
+// ParseIXML decodes an iXML chunk payload. Trailing NUL padding is ignored.
+func ParseIXML(data []byte) (IXML, error) {
+	var doc IXML
+
+	if err := xml.Unmarshal(bytes.TrimRight(data, "\x00"), &doc); err != nil {
+		return IXML{}, fmt.Errorf("parsing iXML: %w", err)
+	}
+
+	return doc, nil
+}
+
+// ParseBWF reads the bext and iXML chunks of a WAV file into ParsedTags.
+// Empty fields are omitted. Loudness fields are only reported for bext version 2 and above.
+func ParseBWF(filePath string) (*ParsedTags, error) {
+	raw, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", filePath, err)
+	}
+
+	file, err := ReadRIFF(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+
+	tags := NewParsedTags()
+
+	if chunk, ok := file.Chunk("bext"); ok {
+		bext, err := ParseBext(chunk.Data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filePath, err)
+		}
+
+		bext.addTo(tags)
+	}
+
+	if chunk, ok := file.Chunk("iXML"); ok {
+		doc, err := ParseIXML(chunk.Data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filePath, err)
+		}
+
+		doc.addTo(tags)
+	}
+
+	return tags, nil
+}
+
+// addTo stores bext fields in tags under their semantic names.
+func (b BextChunk) addTo(tags *ParsedTags) {
+	addNonEmpty(tags, "description", b.Description)
+	addNonEmpty(tags, "originator", b.Originator)
+	addNonEmpty(tags, "originatorreference", b.OriginatorReference)
+	addNonEmpty(tags, "originationdate", b.OriginationDate)
+	addNonEmpty(tags, "originationtime", b.OriginationTime)
+	addNonEmpty(tags, "codinghistory", b.CodingHistory)
+
+	tags.Text["timereference"] = append(tags.Text["timereference"], strconv.FormatUint(b.TimeReference, 10))
+	tags.Text["bwfversion"] = append(tags.Text["bwfversion"], strconv.Itoa(int(b.Version)))
+
+	if len(bytes.Trim(b.UMID, "\x00")) > 0 {
+		tags.Text["umid"] = append(tags.Text["umid"], hex.EncodeToString(b.UMID))
+	}
+
+	if b.Version < bextVersion2 {
+		return
+	}
+
+	for key, value := range map[string]int16{
+		"loudnessvalue":        b.LoudnessValue,
+		"loudnessrange":        b.LoudnessRange,
+		"maxtruepeaklevel":     b.MaxTruePeakLevel,
+		"maxmomentaryloudness": b.MaxMomentaryLoudness,
+		"maxshorttermloudness": b.MaxShortTermLoudness,
+	} {
+		tags.Text[key] = append(tags.Text[key], strconv.FormatFloat(float64(value)/bextLoudnessScale, 'f', 2, 64))
+	}
+}
+
+// addTo stores iXML fields in tags under their semantic names.
+func (x IXML) addTo(tags *ParsedTags) {
+	addNonEmpty(tags, "project", x.Project)
+	addNonEmpty(tags, "scene", x.Scene)
+	addNonEmpty(tags, "take", x.Take)
+	addNonEmpty(tags, "tape", x.Tape)
+	addNonEmpty(tags, "circled", x.Circled)
+	addNonEmpty(tags, "note", x.Note)
+
+	for _, track := range x.TrackList {
+		addNonEmpty(tags, "trackname", track.Name)
+	}
+}
+
+// BWFFixture returns path to a 1 second 16-bit 44.1kHz stereo Broadcast WAV
+// carrying the given bext chunk, and an iXML chunk when ixml is non-nil.
+func BWFFixture(data test.Data, helpers test.Helpers, name string, bext BextChunk, ixml *IXML) string {
+	helpers.T().Helper()
+
+	chunks := []RIFFChunk{bext.Chunk()}
+
+	if ixml != nil {
+		chunks = append(chunks, ixml.Chunk())
+	}
+
+	return wavWithChunks(data, helpers, name, chunks, nil)
+}
+
+// TaggedBWF returns path to a Broadcast WAV with the default bext and iXML metadata.
+func TaggedBWF(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	ixml := DefaultIXML()
+
+	return BWFFixture(data, helpers, "tagged-bwf.wav", DefaultBext(), &ixml)
+}
+
+// BWFLongTimeReference returns path to a Broadcast WAV whose time reference (24h at 192kHz)
+// does not fit in 32 bits, exercising the TimeReferenceHigh word.
+func BWFLongTimeReference(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	bext := DefaultBext()
+	bext.TimeReference = bwfFixtureTimeReference
+
+	return BWFFixture(data, helpers, "bwf-long-time-reference.wav", bext, nil)
+}
+
+// BWFNonASCIIDescription returns path to a Broadcast WAV whose bext description and originator
+// are UTF-8 (the spec mandates ASCII), and whose description overflows 256 bytes so the
+// byte-wise truncation splits a multi-byte character.
+func BWFNonASCIIDescription(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	const filler = "Enregistrement à l'église Saint-Étienne — 日本語の説明 — "
+
+	description := filler
+	for len(description) <= bextDescriptionSize {
+		description += filler
+	}
+
+	bext := DefaultBext()
+	bext.Description = description
+	bext.Originator = "Über Recorder ™"
+
+	ixml := DefaultIXML()
+	ixml.Note = "Prise n°3, très bien"
+
+	return BWFFixture(data, helpers, "bwf-non-ascii-description.wav", bext, &ixml)
+}
+
+// appendFixed appends value to out, truncated or NUL-padded to exactly width bytes.
+func appendFixed(out []byte, value string, width int) []byte {
+	field := make([]byte, width)
+	copy(field, value)
+
+	return append(out, field...)
+}
+
+// decodeLegacyText decodes a NUL-terminated text field from a legacy (pre-Unicode) chunk. Valid UTF-8 is kept as is;
+// anything else is interpreted as ISO-8859-1, the most common non-ASCII encoding in the wild.
+func decodeLegacyText(field []byte) string {
+	if idx := bytes.IndexByte(field, 0); idx >= 0 {
+		field = field[:idx]
+	}
+
+	if utf8.Valid(field) {
+		return string(field)
+	}
+
+	// Byte-wise truncation of a fixed-width field may split the last multi-byte character.
+	if trimmed := trimPartialRune(field); utf8.Valid(trimmed) {
+		return string(trimmed)
+	}
+
+	return latin1ToString(field)
+}
+
+// trimPartialRune drops an incomplete UTF-8 sequence at the end of field.
+func trimPartialRune(field []byte) []byte {
+	for idx := len(field) - 1; idx >= 0 && idx >= len(field)-utf8.UTFMax; idx-- {
+		if utf8.RuneStart(field[idx]) {
+			if !utf8.FullRune(field[idx:]) {
+				return field[:idx]
+			}
+
+			break
+		}
+	}
+
+	return field
+}
+
+// addNonEmpty appends value under key unless it is empty.
+func addNonEmpty(tags *ParsedTags, key, value string) {
+	if value != "" {
+		tags.Text[key] = append(tags.Text[key], value)
+	}
+}
+
+// fixedReader reads consecutive little-endian fixed-width fields.
+// Callers must check the total length beforehand.
+type fixedReader struct {
+	data   []byte
+	offset int
+}
+
+func (r *fixedReader) bytes(n int) []byte {
+	out := r.data[r.offset : r.offset+n]
+	r.offset += n
+
+	return out
+}
+
+func (r *fixedReader) text(n int) string {
+	return decodeLegacyText(r.bytes(n))
+}
+
+func (r *fixedReader) uint16() uint16 {
+	return binary.LittleEndian.Uint16(r.bytes(2))
+}
+
+func (r *fixedReader) uint32() uint32 {
+	return binary.LittleEndian.Uint32(r.bytes(fourCCSize))
+}
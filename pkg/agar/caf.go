@@ -0,0 +1,695 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+)
+
+// CAF format identifiers (desc mFormatID).
+const (
+	CAFFormatLPCM = "lpcm"
+	CAFFormatALAC = "alac"
+	CAFFormatAAC  = "aac "
+)
+
+// CAF linear PCM format flags (desc mFormatFlags).
+const (
+	CAFLinearPCMFlagIsFloat        = 1 << 0
+	CAFLinearPCMFlagIsLittleEndian = 1 << 1
+)
+
+// CAF channel layout tags (chan mChannelLayoutTag).
+const (
+	CAFChannelLayoutMono   = 100<<16 | 1
+	CAFChannelLayoutStereo = 101<<16 | 2
+)
+
+// CAF layout constants.
+const (
+	cafFileHeaderSize    = 8
+	cafChunkHeaderSize   = 12
+	cafDescSize          = 32
+	cafPaktHeaderSize    = 24
+	cafFileVersion       = 1
+	cafUnterminatedSize  = -1
+	cafVarintBits        = 7
+	cafVarintMask        = 0x7f
+	cafVarintContinue    = 0x80
+	cafALACFramesPerPkt  = 4096
+	cafEditCountSize     = 4
+	mpeg4ObjectAACLC     = 2
+	esdsTagES            = 0x03
+	esdsTagDecoderConfig = 0x04
+	esdsTagDecoderInfo   = 0x05
+	esdsTagSLConfig      = 0x06
+	esdsObjectTypeAudio  = 0x40
+	esdsStreamTypeAudio  = 0x15
+	esdsSLPredefinedMP4  = 0x02
+	esdsSizeBytes        = 4
+)
+
+// Sentinel errors for CAF parsing.
+var (
+	ErrNotCAF           = errors.New("not a CAF file")
+	ErrCAFChunkTooShort = errors.New("CAF chunk shorter than its fixed-size part")
+	ErrCAFMissingChunk  = errors.New("CAF chunk missing")
+)
+
+// CAFDescription mirrors the CAFAudioFormat structure of the desc chunk.
+type CAFDescription struct {
+	SampleRate       float64
+	FormatID         string
+	FormatFlags      uint32
+	BytesPerPacket   uint32
+	FramesPerPacket  uint32
+	ChannelsPerFrame uint32
+	BitsPerChannel   uint32
+}
+
+// CAFLPCMDescription returns the description of interleaved linear PCM.
+//
+//nolint:gosec // G115: format fields are small test-controlled values.
+func CAFLPCMDescription(sampleRate, bitDepth, channels int, float, littleEndian bool) CAFDescription {
+	var flags uint32
+
+	if float {
+		flags |= CAFLinearPCMFlagIsFloat
+	}
+
+	if littleEndian {
+		flags |= CAFLinearPCMFlagIsLittleEndian
+	}
+
+	return CAFDescription{
+		SampleRate:       float64(sampleRate),
+		FormatID:         CAFFormatLPCM,
+		FormatFlags:      flags,
+		BytesPerPacket:   uint32(PCMBytesPerSample(bitDepth) * channels),
+		FramesPerPacket:  1,
+		ChannelsPerFrame: uint32(channels),
+		BitsPerChannel:   uint32(bitDepth),
+	}
+}
+
+// Bytes serializes the desc chunk payload.
+func (d CAFDescription) Bytes() []byte {
+	out := binary.BigEndian.AppendUint64(nil, math.Float64bits(d.SampleRate))
+	out = append(out, padFourCC(d.FormatID)...)
+
+	for _, value := range []uint32{
+		d.FormatFlags, d.BytesPerPacket, d.FramesPerPacket, d.ChannelsPerFrame, d.BitsPerChannel,
+	} {
+		out = binary.BigEndian.AppendUint32(out, value)
+	}
+
+	return out
+}
+
+// ParseCAFDescription decodes a desc chunk payload.
+func ParseCAFDescription(data []byte) (CAFDescription, error) {
+	if len(data) < cafDescSize {
+		return CAFDescription{}, fmt.Errorf("%w: desc is %d bytes", ErrCAFChunkTooShort, len(data))
+	}
+
+	return CAFDescription{
+		SampleRate:       math.Float64frombits(binary.BigEndian.Uint64(data)),
+		FormatID:         string(data[8:12]),
+		FormatFlags:      binary.BigEndian.Uint32(data[12:]),
+		BytesPerPacket:   binary.BigEndian.Uint32(data[16:]),
+		FramesPerPacket:  binary.BigEndian.Uint32(data[20:]),
+		ChannelsPerFrame: binary.BigEndian.Uint32(data[24:]),
+		BitsPerChannel:   binary.BigEndian.Uint32(data[28:]),
+	}, nil
+}
+
+// CAFPacketTable mirrors the pakt chunk.
+type CAFPacketTable struct {
+	// ValidFrames is the number of audio frames after trimming priming and remainder.
+	ValidFrames     int64
+	PrimingFrames   int32
+	RemainderFrames int32
+	// PacketSizes holds one byte size per packet (formats with variable packet size).
+	PacketSizes []int
+	// PacketFrames holds one frame count per packet, only for formats with variable frames per packet.
+	PacketFrames []int
+}
+
+// Bytes serializes the pakt chunk payload. The packet count is len(PacketSizes),
+// or len(PacketFrames) when there are no sizes.
+func (p CAFPacketTable) Bytes() []byte {
+	packets := max(len(p.PacketSizes), len(p.PacketFrames))
+
+	out := binary.BigEndian.AppendUint64(nil, uint64(packets))          //nolint:gosec // G115: positive count.
+	out = binary.BigEndian.AppendUint64(out, uint64(p.ValidFrames))     //nolint:gosec // G115: reinterpret.
+	out = binary.BigEndian.AppendUint32(out, uint32(p.PrimingFrames))   //nolint:gosec // G115: reinterpret.
+	out = binary.BigEndian.AppendUint32(out, uint32(p.RemainderFrames)) //nolint:gosec // G115: reinterpret.
+
+	for idx := range packets {
+		if idx < len(p.PacketSizes) {
+			out = appendCAFVarint(out, uint64(p.PacketSizes[idx])) //nolint:gosec // G115: positive size.
+		}
+
+		if idx < len(p.PacketFrames) {
+			out = appendCAFVarint(out, uint64(p.PacketFrames[idx])) //nolint:gosec // G115: positive count.
+		}
+	}
+
+	return out
+}
+
+// ParseCAFPacketTable decodes a pakt chunk payload. desc determines which per-packet
+// fields are present: sizes when BytesPerPacket is 0, frame counts when FramesPerPacket is 0.
+func ParseCAFPacketTable(data []byte, desc CAFDescription) (CAFPacketTable, error) {
+	if len(data) < cafPaktHeaderSize {
+		return CAFPacketTable{}, fmt.Errorf("%w: pakt is %d bytes", ErrCAFChunkTooShort, len(data))
+	}
+
+	packets := binary.BigEndian.Uint64(data)
+	table := CAFPacketTable{
+		ValidFrames:     int64(binary.BigEndian.Uint64(data[8:])),  //nolint:gosec // G115: reinterpret.
+		PrimingFrames:   int32(binary.BigEndian.Uint32(data[16:])), //nolint:gosec // G115: reinterpret.
+		RemainderFrames: int32(binary.BigEndian.Uint32(data[20:])), //nolint:gosec // G115: reinterpret.
+	}
+
+	rest := data[cafPaktHeaderSize:]
+
+	for range packets {
+		if desc.BytesPerPacket == 0 {
+			value, n := readCAFVarint(rest)
+			if n == 0 {
+				return CAFPacketTable{}, fmt.Errorf("%w: pakt entries truncated", ErrCAFChunkTooShort)
+			}
+
+			table.PacketSizes = append(table.PacketSizes, int(value)) //nolint:gosec // G115: sizes fit int.
+			rest = rest[n:]
+		}
+
+		if desc.FramesPerPacket == 0 {
+			value, n := readCAFVarint(rest)
+			if n == 0 {
+				return CAFPacketTable{}, fmt.Errorf("%w: pakt entries truncated", ErrCAFChunkTooShort)
+			}
+
+			table.PacketFrames = append(table.PacketFrames, int(value)) //nolint:gosec // G115: counts fit int.
+			rest = rest[n:]
+		}
+	}
+
+	return table, nil
+}
+
+// CAFChunk is a single chunk of a CAF file.
+type CAFChunk struct {
+	// Type is the four-character chunk type (e.g. "desc", "data").
+	Type string
+	// Data is the chunk payload.
+	Data []byte
+	// Unterminated writes a size of -1, meaning the chunk extends to the end of the file.
+	// Only valid for the last (data) chunk.
+	Unterminated bool
+}
+
+// CAFFile describes a CAF file as an ordered list of chunks.
+type CAFFile struct {
+	Chunks []CAFChunk
+}
+
+// Bytes serializes the file.
+func (f CAFFile) Bytes() []byte {
+	out := []byte("caff")
+	out = binary.BigEndian.AppendUint16(out, cafFileVersion)
+	out = binary.BigEndian.AppendUint16(out, 0)
+
+	for _, chunk := range f.Chunks {
+		size := int64(len(chunk.Data))
+		if chunk.Unterminated {
+			size = cafUnterminatedSize
+		}
+
+		out = append(out, padFourCC(chunk.Type)...)
+		out = binary.BigEndian.AppendUint64(out, uint64(size)) //nolint:gosec // G115: reinterpret -1.
+		out = append(out, chunk.Data...)
+	}
+
+	return out
+}
+
+// Chunk returns the first chunk of the given type.
+func (f CAFFile) Chunk(chunkType string) (CAFChunk, bool) {
+	for _, chunk := range f.Chunks {
+		if chunk.Type == chunkType {
+			return chunk, true
+		}
+	}
+
+	return CAFChunk{}, false
+}
+
+// ReadCAF parses the chunks of a CAF file. A chunk of size -1 extends to the end of data;
+// a chunk whose size runs past the end of data is returned with the available bytes.
+func ReadCAF(data []byte) (CAFFile, error) {
+	if len(data) < cafFileHeaderSize || string(data[:fourCCSize]) != "caff" {
+		return CAFFile{}, ErrNotCAF
+	}
+
+	var file CAFFile
+
+	for offset := cafFileHeaderSize; offset+cafChunkHeaderSize <= len(data); {
+		chunk := CAFChunk{Type: string(data[offset : offset+fourCCSize])}
+		size := int64(binary.BigEndian.Uint64(data[offset+fourCCSize:])) //nolint:gosec // G115: reinterpret.
+		start := offset + cafChunkHeaderSize
+		end := len(data)
+
+		if size == cafUnterminatedSize {
+			chunk.Unterminated = true
+		} else if size >= 0 && size <= int64(len(data)-start) {
+			end = start + int(size)
+		}
+
+		chunk.Data = data[start:end]
+		file.Chunks = append(file.Chunks, chunk)
+		offset = end
+	}
+
+	return file, nil
+}
+
+// CAFOptions configures BuildCAF.
+type CAFOptions struct {
+	Description CAFDescription
+	// MagicCookie is written verbatim as a kuki chunk when non-nil.
+	MagicCookie []byte
+	// PacketTable is written as a pakt chunk when non-nil.
+	PacketTable *CAFPacketTable
+	// Info entries are written as an info chunk, sorted by key, when non-empty.
+	Info map[string]string
+	// ChannelLayoutTag is written as a chan chunk when non-zero.
+	ChannelLayoutTag uint32
+	// Unterminated writes a data chunk size of -1, as left by an unfinished recording.
+	Unterminated bool
+}
+
+// BuildCAF assembles a CAF file. audio is the raw data chunk content: interleaved samples for
+// LPCM, concatenated packets for compressed formats. Chunks are ordered desc, chan, kuki, info,
+// pakt, data.
+func BuildCAF(opts CAFOptions, audio []byte) CAFFile {
+	chunks := []CAFChunk{{Type: "desc", Data: opts.Description.Bytes()}}
+
+	if opts.ChannelLayoutTag != 0 {
+		layout := binary.BigEndian.AppendUint32(nil, opts.ChannelLayoutTag)
+		layout = binary.BigEndian.AppendUint32(layout, 0) // channel bitmap
+		layout = binary.BigEndian.AppendUint32(layout, 0) // channel descriptions
+
+		chunks = append(chunks, CAFChunk{Type: "chan", Data: layout})
+	}
+
+	if opts.MagicCookie != nil {
+		chunks = append(chunks, CAFChunk{Type: "kuki", Data: opts.MagicCookie})
+	}
+
+	if len(opts.Info) > 0 {
+		keys := make([]string, 0, len(opts.Info))
+		for key := range opts.Info {
+			keys = append(keys, key)
+		}
+
+		slices.Sort(keys)
+
+		info := binary.BigEndian.AppendUint32(nil, uint32(len(keys))) //nolint:gosec // G115: small count.
+		for _, key := range keys {
+			info = append(info, key...)
+			info = append(info, 0)
+			info = append(info, opts.Info[key]...)
+			info = append(info, 0)
+		}
+
+		chunks = append(chunks, CAFChunk{Type: "info", Data: info})
+	}
+
+	if opts.PacketTable != nil {
+		chunks = append(chunks, CAFChunk{Type: "pakt", Data: opts.PacketTable.Bytes()})
+	}
+
+	data := make([]byte, cafEditCountSize, cafEditCountSize+len(audio))
+	data = append(data, audio...)
+
+	chunks = append(chunks, CAFChunk{Type: "data", Data: data, Unterminated: opts.Unterminated})
+
+	return CAFFile{Chunks: chunks}
+}
+
+// DefaultCAFInfo returns the standard info chunk test metadata.
+func DefaultCAFInfo() map[string]string {
+	return map[string]string{
+		"title":  "Test Title",
+		"artist": "Test Artist",
+		"album":  "Test Album",
+		"year":   strconv.Itoa(testYear),
+	}
+}
+
+// CAFLPCMFixture returns path to a 1 second 44.1kHz stereo LPCM CAF with info and chan chunks.
+// Integer samples are deterministic white noise; float samples are the same noise scaled to [-1, 1).
+// Supported depths are 16, 24 and 32 bits for integers, 32 and 64 bits for floats.
+func CAFLPCMFixture(data test.Data, helpers test.Helpers, bitDepth int, float, littleEndian bool) string {
+	helpers.T().Helper()
+
+	return cafLPCM(data, helpers, bitDepth, float, littleEndian, false)
+}
+
+// CAFUnterminatedLPCM returns path to a 16-bit big-endian LPCM CAF whose data chunk size is -1,
+// as left by a recording that was never finalized.
+func CAFUnterminatedLPCM(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	return cafLPCM(data, helpers, BitDepth16, false, false, true)
+}
+
+// FormatCAFALAC returns path to an ALAC CAF (ffmpeg-encoded packets and magic cookie,
+// re-muxed natively) with pakt, info and chan chunks.
+func FormatCAFALAC(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	opts, audio := cafALACSource(data, helpers)
+
+	return writeCAF(data, helpers, "format-alac.caf", opts, audio)
+}
+
+// CAFALACPrimingRemainder returns path to an ALAC CAF whose packet table declares 2112 priming
+// frames and 1000 remainder frames, so that a conforming decoder trims both ends.
+func CAFALACPrimingRemainder(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	const (
+		primingFrames   = 2112
+		remainderFrames = 1000
+	)
+
+	opts, audio := cafALACSource(data, helpers)
+	opts.PacketTable.PrimingFrames = primingFrames
+	opts.PacketTable.RemainderFrames = remainderFrames
+	opts.PacketTable.ValidFrames -= primingFrames + remainderFrames
+
+	return writeCAF(data, helpers, "alac-priming-remainder.caf", opts, audio)
+}
+
+// CAFUnterminatedALAC returns path to an ALAC CAF whose data chunk size is -1.
+func CAFUnterminatedALAC(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	opts, audio := cafALACSource(data, helpers)
+	opts.Unterminated = true
+
+	return writeCAF(data, helpers, "alac-unterminated.caf", opts, audio)
+}
+
+// FormatCAFAAC returns path to an AAC-LC CAF (ffmpeg-encoded packets, natively built esds
+// magic cookie) with pakt, info and chan chunks. The packet table declares ffmpeg's 1024 priming
+// frames and the remainder needed to trim back to exactly 3 seconds.
+func FormatCAFAAC(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	const (
+		sampleRate = 44100
+		bitRate    = 128000
+	)
+
+	adtsPath := generate(helpers, filepath.Join(data.Temp().Dir(), "caf-source.aac"), []string{
+		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + shortDuration,
+		"-af", "pan=stereo|c0=c0|c1=c0,volume=-6dB",
+		"-ar", strconv.Itoa(sampleRate), "-c:a", "aac", "-b:a", strconv.Itoa(bitRate), "-f", "adts",
+	})
+
+	stream, err := splitADTS(readFixtureFile(helpers.T(), adtsPath))
+	if err != nil {
+		helpers.T().Log(err.Error())
+		helpers.T().FailNow()
+	}
+
+	table := &CAFPacketTable{PrimingFrames: aacFramesPerPacket}
+
+	var audio []byte
+
+	for _, packet := range stream.Packets {
+		table.PacketSizes = append(table.PacketSizes, len(packet))
+		audio = append(audio, packet...)
+	}
+
+	duration, _ := strconv.Atoi(shortDuration)
+	table.ValidFrames = int64(duration * stream.SampleRate())
+	table.RemainderFrames = int32( //nolint:gosec // G115: bounded by one packet.
+		max(0, int64(len(stream.Packets)*aacFramesPerPacket)-int64(aacFramesPerPacket)-table.ValidFrames),
+	)
+
+	opts := CAFOptions{
+		Description: CAFDescription{
+			SampleRate:       float64(stream.SampleRate()),
+			FormatID:         CAFFormatAAC,
+			FormatFlags:      mpeg4ObjectAACLC,
+			FramesPerPacket:  aacFramesPerPacket,
+			ChannelsPerFrame: uint32(stream.ChannelConfig), //nolint:gosec // G115: 3-bit field.
+		},
+		MagicCookie:      aacMagicCookie(stream.AudioSpecificConfig(), bitRate),
+		PacketTable:      table,
+		Info:             DefaultCAFInfo(),
+		ChannelLayoutTag: CAFChannelLayoutStereo,
+	}
+
+	return writeCAF(data, helpers, "format-aac.caf", opts, audio)
+}
+
+// cafLPCM writes a 1 second 44.1kHz stereo LPCM CAF.
+func cafLPCM(data test.Data, helpers test.Helpers, bitDepth int, float, littleEndian, unterminated bool) string {
+	helpers.T().Helper()
+
+	const sampleRate = 44100
+
+	var audio []byte
+
+	if float {
+		noise := GenerateWhiteNoise(sampleRate, BitDepth16, 2, 1)
+		audio = pcm16ToFloat(noise, bitDepth, littleEndian)
+	} else {
+		audio = GenerateWhiteNoise(sampleRate, bitDepth, 2, 1)
+		if !littleEndian {
+			audio = swapEndianness(audio, PCMBytesPerSample(bitDepth))
+		}
+	}
+
+	opts := CAFOptions{
+		Description:      CAFLPCMDescription(sampleRate, bitDepth, 2, float, littleEndian),
+		Info:             DefaultCAFInfo(),
+		ChannelLayoutTag: CAFChannelLayoutStereo,
+		Unterminated:     unterminated,
+	}
+
+	kind := "int"
+	if float {
+		kind = "float"
+	}
+
+	endian := "be"
+	if littleEndian {
+		endian = "le"
+	}
+
+	name := fmt.Sprintf("lpcm-%s%d-%s", kind, bitDepth, endian)
+	if unterminated {
+		name += "-unterminated"
+	}
+
+	return writeCAF(data, helpers, name+".caf", opts, audio)
+}
+
+// cafALACSource encodes a 3 second stereo sine to ALAC with ffmpeg and extracts the description,
+// magic cookie, packet table and packet data from its CAF output.
+func cafALACSource(data test.Data, helpers test.Helpers) (CAFOptions, []byte) {
+	helpers.T().Helper()
+
+	sourcePath := generate(helpers, filepath.Join(data.Temp().Dir(), "caf-source-alac.caf"), []string{
+		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + shortDuration,
+		"-af", "pan=stereo|c0=c0|c1=c0,volume=-6dB",
+		"-ar", "44100", "-sample_fmt", "s16p", "-c:a", "alac", "-f", "caf",
+	})
+
+	opts, audio, err := cafCompressedSource(readFixtureFile(helpers.T(), sourcePath))
+	if err != nil {
+		helpers.T().Log(err.Error())
+		helpers.T().FailNow()
+	}
+
+	opts.Info = DefaultCAFInfo()
+	opts.ChannelLayoutTag = CAFChannelLayoutStereo
+
+	return opts, audio
+}
+
+// cafCompressedSource extracts desc, kuki, pakt and packet data from a CAF file.
+// The source must carry a packet table, as the variable-size ALAC and AAC packets from ffmpeg do;
+// a missing pakt is reported as ErrCAFMissingChunk.
+func cafCompressedSource(raw []byte) (CAFOptions, []byte, error) {
+	file, err := ReadCAF(raw)
+	if err != nil {
+		return CAFOptions{}, nil, err
+	}
+
+	descChunk, ok := file.Chunk("desc")
+	if !ok {
+		return CAFOptions{}, nil, fmt.Errorf("%w: desc", ErrCAFMissingChunk)
+	}
+
+	desc, err := ParseCAFDescription(descChunk.Data)
+	if err != nil {
+		return CAFOptions{}, nil, err
+	}
+
+	dataChunk, ok := file.Chunk("data")
+	if !ok || len(dataChunk.Data) < cafEditCountSize {
+		return CAFOptions{}, nil, fmt.Errorf("%w: data", ErrCAFMissingChunk)
+	}
+
+	opts := CAFOptions{Description: desc}
+
+	if kuki, ok := file.Chunk("kuki"); ok {
+		opts.MagicCookie = kuki.Data
+	}
+
+	paktChunk, ok := file.Chunk("pakt")
+	if !ok {
+		return CAFOptions{}, nil, fmt.Errorf("%w: pakt", ErrCAFMissingChunk)
+	}
+
+	table, err := ParseCAFPacketTable(paktChunk.Data, desc)
+	if err != nil {
+		return CAFOptions{}, nil, err
+	}
+
+	if table.ValidFrames == 0 && desc.FramesPerPacket > 0 {
+		packets := max(len(table.PacketSizes), len(table.PacketFrames))
+		table.ValidFrames = int64(packets) * int64(desc.FramesPerPacket)
+	}
+
+	opts.PacketTable = &table
+
+	return opts, dataChunk.Data[cafEditCountSize:], nil
+}
+
+// writeCAF builds a CAF file and writes it to the test temp directory.
+func writeCAF(data test.Data, helpers test.Helpers, name string, opts CAFOptions, audio []byte) string {
+	helpers.T().Helper()
+
+	path := filepath.Join(data.Temp().Dir(), name)
+	writeFixtureFile(helpers.T(), path, BuildCAF(opts, audio).Bytes())
+
+	return path
+}
+
+// aacMagicCookie builds the kuki payload for AAC: an esds atom body (version and flags,
+// then an ES_Descriptor wrapping the AudioSpecificConfig).
+func aacMagicCookie(audioSpecificConfig []byte, bitRate uint32) []byte {
+	decoderConfig := []byte{esdsObjectTypeAudio, esdsStreamTypeAudio, 0, 0, 0}
+	decoderConfig = binary.BigEndian.AppendUint32(decoderConfig, bitRate) // max bitrate
+	decoderConfig = binary.BigEndian.AppendUint32(decoderConfig, bitRate) // average bitrate
+	decoderConfig = append(decoderConfig, esdsDescriptor(esdsTagDecoderInfo, audioSpecificConfig)...)
+
+	elementary := []byte{0, 0, 0} // ES_ID and flags
+	elementary = append(elementary, esdsDescriptor(esdsTagDecoderConfig, decoderConfig)...)
+	elementary = append(elementary, esdsDescriptor(esdsTagSLConfig, []byte{esdsSLPredefinedMP4})...)
+
+	return append([]byte{0, 0, 0, 0}, esdsDescriptor(esdsTagES, elementary)...)
+}
+
+// esdsDescriptor wraps payload in an MPEG-4 descriptor, using the four-byte size encoding.
+func esdsDescriptor(tag byte, payload []byte) []byte {
+	out := []byte{tag}
+
+	for shift := (esdsSizeBytes - 1) * cafVarintBits; shift > 0; shift -= cafVarintBits {
+		out = append(out, byte(len(payload)>>shift)&cafVarintMask|cafVarintContinue)
+	}
+
+	out = append(out, byte(len(payload))&cafVarintMask)
+
+	return append(out, payload...)
+}
+
+// appendCAFVarint appends value as a CAF variable-length integer (7 bits per byte, MSB first).
+func appendCAFVarint(out []byte, value uint64) []byte {
+	var groups []byte
+
+	for {
+		groups = append(groups, byte(value&cafVarintMask))
+		value >>= cafVarintBits
+
+		if value == 0 {
+			break
+		}
+	}
+
+	for idx := len(groups) - 1; idx > 0; idx-- {
+		out = append(out, groups[idx]|cafVarintContinue)
+	}
+
+	return append(out, groups[0])
+}
+
+// readCAFVarint decodes a CAF variable-length integer, returning the value and bytes consumed
+// (0 when data ends before the last byte).
+func readCAFVarint(data []byte) (uint64, int) {
+	var value uint64
+
+	for idx, b := range data {
+		value = value<<cafVarintBits | uint64(b&cafVarintMask)
+		if b&cafVarintContinue == 0 {
+			return value, idx + 1
+		}
+	}
+
+	return 0, 0
+}
+
+// pcm16ToFloat converts 16-bit little-endian PCM to 32 or 64-bit IEEE floats in [-1, 1).
+func pcm16ToFloat(pcm []byte, bitDepth int, littleEndian bool) []byte {
+	const scale = 1 << 15
+
+	var order binary.AppendByteOrder = binary.BigEndian
+	if littleEndian {
+		order = binary.LittleEndian
+	}
+
+	out := make([]byte, 0, len(pcm)/2*PCMBytesPerSample(bitDepth))
+
+	for offset := 0; offset+1 < len(pcm); offset += 2 {
+		sample := float64(int16(binary.LittleEndian.Uint16(pcm[offset:]))) / scale //nolint:gosec // G115: reinterpret.
+
+		if bitDepth == BitDepth32 {
+			out = order.AppendUint32(out, math.Float32bits(float32(sample)))
+		} else {
+			out = order.AppendUint64(out, math.Float64bits(sample))
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,394 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+	"github.com/containerd/nerdctl/mod/tigron/tig"
+)
+
+// Checker names reported in ConformanceCheck.Checker.
+const (
+	CheckerFLACTest      = "flac -t"
+	CheckerMetaflacList  = "metaflac --list"
+	CheckerFFmpeg        = "ffmpeg -xerror"
+	CheckerAtomicParsley = "AtomicParsley -T"
+	CheckerNative        = "native"
+)
+
+// conformanceTimeout bounds the reference checkers run by RequireConformant.
+const conformanceTimeout = 2 * time.Minute
+
+// Container formats detected by ValidateConformance, in addition to the PayloadFormat constants.
+const (
+	ContainerRIFF    = "riff"
+	ContainerAIFF    = "aiff"
+	ContainerUnknown = "unknown"
+)
+
+// ConformanceVerdict is the outcome of a single checker, as ConformanceReport prints it: failures are in
+// capitals to stand out.
+type ConformanceVerdict string
+
+// Conformance verdicts.
+const (
+	// ConformancePass means the checker found nothing wrong.
+	ConformancePass ConformanceVerdict = "pass"
+	// ConformanceFail means the checker rejected the file or reported errors.
+	ConformanceFail ConformanceVerdict = "FAIL"
+	// ConformanceSkipped means the checker binary is not installed.
+	ConformanceSkipped ConformanceVerdict = "skipped"
+)
+
+// ConformanceCheck is the verdict of one checker, with its diagnostics.
+type ConformanceCheck struct {
+	Checker string
+	Verdict ConformanceVerdict
+	// Messages are the checker's diagnostics, one per line; native checks also report what they verified.
+	Messages []string
+}
+
+// ConformanceReport combines the verdicts of every checker applicable to a file.
+type ConformanceReport struct {
+	Path string
+	// Format is a PayloadFormat constant, ContainerRIFF, ContainerAIFF or ContainerUnknown.
+	Format string
+	Checks []ConformanceCheck
+}
+
+// Passed reports whether no checker failed. Skipped checkers do not count.
+func (r ConformanceReport) Passed() bool {
+	for _, check := range r.Checks {
+		if check.Verdict == ConformanceFail {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Check returns the verdict of the named checker.
+func (r ConformanceReport) Check(checker string) (ConformanceCheck, bool) {
+	for _, check := range r.Checks {
+		if check.Checker == checker {
+			return check, true
+		}
+	}
+
+	return ConformanceCheck{}, false
+}
+
+// String summarises the report, one checker per line followed by its indented messages.
+func (r ConformanceReport) String() string {
+	var out strings.Builder
+
+	fmt.Fprintf(&out, "%s (%s)", r.Path, r.Format)
+
+	for _, check := range r.Checks {
+		fmt.Fprintf(&out, "\n  %s: %s", check.Checker, check.Verdict)
+
+		for _, message := range check.Messages {
+			out.WriteString("\n    " + message)
+		}
+	}
+
+	return out.String()
+}
+
+// ValidateConformance runs every reference checker applicable to the file at path, plus agar's native
+// structural walkers:
+//   - FLAC: flac -t -w, metaflac --list, ffmpeg, and frame CRCs and STREAMINFO totals.
+//   - MP4: AtomicParsley -T, ffmpeg, and the box tree and sample tables.
+//   - Ogg: ffmpeg, and page checksums and codec headers.
+//   - MPEG audio: ffmpeg, and frame headers and their consistency.
+//   - RIFF and AIFF: ffmpeg, and chunk sizes.
+//
+// ffmpeg runs as "ffmpeg -v error -xerror -i path -f null -" and fails on any error output.
+// Checkers run until they exit or ctx is done; a checker cut short by ctx fails.
+// Checkers whose binary is missing are skipped. The error is only set when the file cannot be read.
+func ValidateConformance(ctx context.Context, path string) (ConformanceReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ConformanceReport{}, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	report := ConformanceReport{Path: path, Format: sniffContainer(data)}
+
+	switch report.Format {
+	case PayloadFormatFLAC:
+		report.Checks = append(report.Checks,
+			runConformanceChecker(ctx, CheckerFLACTest, flacBinary, "-t", "-s", "-w", path),
+			runConformanceChecker(ctx, CheckerMetaflacList, metaflacBinary, "--list", path))
+	case PayloadFormatMP4:
+		report.Checks = append(report.Checks,
+			runConformanceChecker(ctx, CheckerAtomicParsley, atomicParsleyBinary, path, "-T"))
+	}
+
+	report.Checks = append(report.Checks, runConformanceChecker(ctx, CheckerFFmpeg, ffmpegBinary,
+		"-v", "error", "-xerror", "-i", path, "-f", "null", "-"))
+
+	if report.Format != ContainerUnknown {
+		report.Checks = append(report.Checks, nativeConformance(report.Format, data))
+	}
+
+	return report, nil
+}
+
+// RequireConformant fails the test unless every available checker accepts the file at path within two
+// minutes. The report is logged either way.
+func RequireConformant(helper tig.T, path string) ConformanceReport {
+	helper.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), conformanceTimeout)
+	defer cancel()
+
+	report, err := ValidateConformance(ctx, path)
+	if err != nil {
+		helper.Log(err.Error())
+		helper.FailNow()
+	}
+
+	helper.Log(report.String())
+
+	if !report.Passed() {
+		helper.FailNow()
+	}
+
+	return report
+}
+
+// Conformant returns a comparator for test.Expected.Output that runs RequireConformant on the file
+// at path, typically the output of the command under test.
+func Conformant(path string) test.Comparator {
+	return func(_ string, helper tig.T) {
+		helper.Helper()
+		RequireConformant(helper, path)
+	}
+}
+
+// sniffContainer identifies the container of data from its leading bytes, after any ID3v2 tag.
+func sniffContainer(data []byte) string {
+	body := data[leadingID3v2Size(data):]
+
+	switch {
+	case bytes.HasPrefix(body, []byte(flacMarker)):
+		return PayloadFormatFLAC
+	case bytes.HasPrefix(body, []byte(oggCapturePattern)):
+		return PayloadFormatOgg
+	case len(body) >= mp4BoxHeaderSize && string(body[4:8]) == "ftyp":
+		return PayloadFormatMP4
+	case bytes.HasPrefix(data, []byte("RIFF")):
+		return ContainerRIFF
+	case bytes.HasPrefix(data, []byte("FORM")):
+		return ContainerAIFF
+	case len(body) > 1 && body[0] == mpegFrameSync && body[1]&mpegFrameSyncMask == mpegFrameSyncMask:
+		return PayloadFormatMPEG
+	default:
+		return ContainerUnknown
+	}
+}
+
+// runConformanceChecker runs a reference checker with runTool. It fails on a ToolError or on output
+// signalling an error, in which case the output lines become the check messages.
+func runConformanceChecker(ctx context.Context, checker, binary string, args ...string) ConformanceCheck {
+	check := ConformanceCheck{Checker: checker, Verdict: ConformancePass}
+
+	// Checkers report on either stream; both go to one buffer to keep their order.
+	var output bytes.Buffer
+
+	_, err := runTool(ctx, binary, toolOptions{Args: args, Stdout: &output, Stderr: &output})
+
+	switch {
+	case errors.Is(err, ErrBinaryNotFound):
+		check.Verdict = ConformanceSkipped
+		check.Messages = []string{err.Error()}
+	case err != nil:
+		check.Verdict = ConformanceFail
+		check.Messages = append(nonEmptyLines(output.String()), err.Error())
+	case checkerReportsError(checker, output.String()):
+		check.Verdict = ConformanceFail
+		check.Messages = nonEmptyLines(output.String())
+	}
+
+	return check
+}
+
+// checkerReportsError reports whether the output of a checker that exited successfully still signals
+// a problem: ffmpeg at -v error only prints errors, AtomicParsley reports some errors with status 0.
+func checkerReportsError(checker, output string) bool {
+	switch checker {
+	case CheckerFFmpeg:
+		return strings.TrimSpace(output) != ""
+	case CheckerAtomicParsley:
+		return strings.Contains(strings.ToLower(output), "error")
+	default:
+		return false
+	}
+}
+
+// nativeConformance checks a file of a known format with the structural walkers.
+func nativeConformance(format string, data []byte) ConformanceCheck {
+	var (
+		messages []string
+		problems []string
+	)
+
+	switch format {
+	case PayloadFormatFLAC:
+		messages, problems = nativeFLACConformance(data)
+	case PayloadFormatMPEG:
+		messages, problems = nativeMPEGConformance(data)
+	case PayloadFormatMP4, PayloadFormatOgg:
+		payload, err := ExtractAudioPayload(data)
+		if err != nil {
+			problems = append(problems, err.Error())
+		} else {
+			messages = append(messages, fmt.Sprintf("%d structures, %d bytes of audio", len(payload.Structures),
+				len(payload.Audio)))
+		}
+	case ContainerRIFF, ContainerAIFF:
+		messages, problems = nativeChunkConformance(format, data)
+	}
+
+	verdict := ConformancePass
+	if len(problems) > 0 {
+		verdict = ConformanceFail
+	}
+
+	return ConformanceCheck{Checker: CheckerNative, Verdict: verdict, Messages: append(problems, messages...)}
+}
+
+// nativeFLACConformance verifies every frame CRC-16 and the STREAMINFO sample count.
+func nativeFLACConformance(data []byte) ([]string, []string) {
+	var problems []string
+
+	frames, err := WalkFLACFrames(data)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	var samples uint64
+
+	for _, frame := range frames {
+		samples += uint64(frame.BlockSize) //nolint:gosec // G115: block sizes are at most 65536.
+
+		if !frame.CRCValid {
+			problems = append(problems, fmt.Sprintf("frame @%d: CRC-16 mismatch", frame.Offset))
+		}
+	}
+
+	file, err := ReadFLAC(data[leadingID3v2Size(data):])
+	if err == nil {
+		block, _ := file.Block(FLACBlockStreamInfo)
+		if info, infoErr := ParseFLACStreamInfo(block.Data); infoErr != nil {
+			problems = append(problems, infoErr.Error())
+		} else if info.TotalSamples != 0 && info.TotalSamples != samples {
+			problems = append(problems, fmt.Sprintf("STREAMINFO declares %d samples, frames hold %d",
+				info.TotalSamples, samples))
+		}
+	}
+
+	return []string{fmt.Sprintf("%d frames, %d samples", len(frames), samples)}, problems
+}
+
+// nativeMPEGConformance verifies that frames follow each other and share version, layer and sample rate.
+func nativeMPEGConformance(data []byte) ([]string, []string) {
+	var problems []string
+
+	frames, err := WalkMPEGFrames(data)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if len(frames) == 0 {
+		return nil, append(problems, "no frames")
+	}
+
+	first := frames[0]
+	for _, frame := range frames[1:] {
+		if frame.Version != first.Version || frame.Layer != first.Layer || frame.SampleRate != first.SampleRate {
+			problems = append(problems, fmt.Sprintf(
+				"frame @%d: MPEG-%s layer %d %dHz in an MPEG-%s layer %d %dHz stream",
+				frame.Offset, frame.Version, frame.Layer, frame.SampleRate,
+				first.Version, first.Layer, first.SampleRate))
+		}
+	}
+
+	return []string{fmt.Sprintf("%d frames", len(frames))}, problems
+}
+
+// nativeChunkConformance verifies the container size field and that no chunk runs past the end of the file.
+func nativeChunkConformance(format string, data []byte) ([]string, []string) {
+	var (
+		chunks   []ChunkLayout
+		declared uint32
+		err      error
+		problems []string
+	)
+
+	if format == ContainerRIFF {
+		chunks, err = WalkRIFFChunks(data)
+		if err == nil {
+			declared = binary.LittleEndian.Uint32(data[fourCCSize:])
+		}
+	} else {
+		chunks, err = WalkAIFFChunks(data)
+		if err == nil {
+			declared = binary.BigEndian.Uint32(data[fourCCSize:])
+		}
+	}
+
+	if err != nil {
+		return nil, []string{err.Error()}
+	}
+
+	if int64(declared) != int64(len(data)-riffChunkHeaderSize) {
+		problems = append(problems, fmt.Sprintf("container declares %d bytes, file holds %d",
+			declared, len(data)-riffChunkHeaderSize))
+	}
+
+	for _, chunk := range chunks {
+		if chunk.Truncated() {
+			problems = append(problems, fmt.Sprintf("chunk %q @%d declares %d bytes, %d available",
+				chunk.ID, chunk.Offset, chunk.DeclaredSize, len(chunk.Data)))
+		}
+	}
+
+	return []string{fmt.Sprintf("%d chunks", len(chunks))}, problems
+}
+
+// nonEmptyLines splits output into trimmed, non-empty lines.
+func nonEmptyLines(output string) []string {
+	var lines []string
+
+	for line := range strings.SplitSeq(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
@@ -0,0 +1,307 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"path/filepath"
+	"slices"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+)
+
+// CoverImage selects the image format of cover art fixtures, whose file names carry it.
+type CoverImage string
+
+// Cover image formats.
+const (
+	CoverJPEG CoverImage = "jpeg"
+	CoverPNG  CoverImage = "png"
+)
+
+// MIMEType returns the image MIME type.
+func (c CoverImage) MIMEType() string {
+	if c == CoverPNG {
+		return "image/png"
+	}
+
+	return "image/jpeg"
+}
+
+// Extension returns the file extension, with its dot.
+func (c CoverImage) Extension() string {
+	if c == CoverPNG {
+		return ".png"
+	}
+
+	return ".jpg"
+}
+
+// generate returns path to a cover image in this format: TestCoverJPEG or TestCoverPNG.
+func (c CoverImage) generate(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	if c == CoverPNG {
+		return TestCoverPNG(data, helpers)
+	}
+
+	return TestCoverJPEG(data, helpers)
+}
+
+// AttachedPicMP4 returns path to an AAC M4A whose cover is a second, single-sample video track holding the
+// JPEG or PNG image, rather than an ilst covr atom. ffmpeg cannot write this layout (its MP4 muxer turns
+// attached_pic streams into covr), so the track is added natively. Demuxers differ in whether they flag such
+// a track as attached_pic; check FFProbeStream.Disposition.
+func AttachedPicMP4(data test.Data, helpers test.Helpers, image CoverImage) string {
+	helpers.T().Helper()
+
+	dir := data.Temp().Dir()
+	cover := readFixtureFile(helpers.T(), image.generate(data, helpers))
+	audio := readFixtureFile(helpers.T(), generate(helpers, filepath.Join(dir, "cover-track-source.m4a"), []string{
+		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + shortDuration,
+		"-c:a", "aac", "-b:a", "128k",
+	}))
+
+	out, err := mp4AddCoverTrack(audio, cover, image)
+	if err == nil {
+		err = checkCoverTrack(out, cover)
+	}
+
+	if err != nil {
+		helpers.T().Log("adding cover track: " + err.Error())
+		helpers.T().FailNow()
+	}
+
+	path := filepath.Join(dir, "attached-pic-"+string(image)+".m4a")
+	writeFixtureFile(helpers.T(), path, out)
+
+	return path
+}
+
+// AttachedPicWithVideoMP4 returns path to an MP4 holding audio, a real H.264 video stream and a cover,
+// so that cover streams can be told from video. ffmpeg stores the cover as an ilst covr atom, which it
+// reports back as an attached_pic stream.
+func AttachedPicWithVideoMP4(data test.Data, helpers test.Helpers, image CoverImage) string {
+	helpers.T().Helper()
+
+	cover := image.generate(data, helpers)
+
+	return generate(helpers, filepath.Join(data.Temp().Dir(), "attached-pic-video-"+string(image)+".mp4"), []string{
+		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + shortDuration,
+		"-f", "lavfi", "-i", "testsrc=duration=" + shortDuration + ":size=320x240:rate=30",
+		"-i", cover,
+		"-map", "0:a", "-map", "1:v", "-map", "2:v",
+		"-c:a", "aac", "-b:a", "128k",
+		"-c:v:0", "libx264", "-preset", "ultrafast",
+		"-c:v:1", "copy", "-disposition:v:1", "attached_pic",
+	})
+}
+
+// MKVCoverAttachment returns path to a Matroska audio file carrying its cover as an attachment named
+// cover.jpg or cover.png, with the matching MIME type, following the Matroska cover art convention.
+func MKVCoverAttachment(data test.Data, helpers test.Helpers, image CoverImage) string {
+	helpers.T().Helper()
+
+	cover := image.generate(data, helpers)
+
+	return generate(helpers, filepath.Join(data.Temp().Dir(), "mkv-cover-attachment-"+string(image)+".mka"), []string{
+		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + shortDuration,
+		"-c:a", "flac",
+		"-attach", cover,
+		"-metadata:s:t", "mimetype=" + image.MIMEType(),
+		"-metadata:s:t", "filename=cover" + image.Extension(),
+	})
+}
+
+// MP3APICCover returns path to an MP3 with an ID3v2.3 APIC front cover, which ffmpeg exposes as an
+// attached_pic video stream.
+func MP3APICCover(data test.Data, helpers test.Helpers, image CoverImage) string {
+	helpers.T().Helper()
+
+	cover := image.generate(data, helpers)
+
+	return generate(helpers, filepath.Join(data.Temp().Dir(), "mp3-apic-"+string(image)+".mp3"), []string{
+		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + shortDuration,
+		"-i", cover,
+		"-map", "0:a", "-map", "1:v",
+		"-c:a", "libmp3lame", "-b:a", "128k",
+		"-c:v", "copy", "-disposition:v:0", "attached_pic",
+		"-id3v2_version", "3",
+		"-metadata:s:v", "title=Album cover",
+		"-metadata:s:v", "comment=Cover (front)",
+	})
+}
+
+// Cover track layout.
+const (
+	coverTrackSize       = 500 // GenerateTestJPEG and GenerateTestPNG images are 500x500
+	coverTrackResolution = 0x00480000
+	coverTrackDepth      = 0x0018
+)
+
+// sampleEntry returns the QuickTime sample entry type of a video track holding the image.
+func (c CoverImage) sampleEntry() string {
+	if c == CoverPNG {
+		return "png "
+	}
+
+	return "jpeg"
+}
+
+// mp4AddCoverTrack appends to an M4A whose moov is the last top-level box an mdat holding cover, and a video
+// track spanning the whole movie whose single sample is that image. Movies longer than 2^32-1 timescale
+// units, which only a version 1 mvhd can declare, are rejected.
+//
+//nolint:gosec,mnd // G115: fixture sizes are small; ISO BMFF field widths and fixed-point constants.
+func mp4AddCoverTrack(file, cover []byte, image CoverImage) ([]byte, error) {
+	boxes, err := WalkMP4Boxes(file)
+	if err != nil {
+		return nil, err
+	}
+
+	moov := boxes[len(boxes)-1]
+	if moov.Type != "moov" {
+		return nil, fmt.Errorf("%w: moov is not the last top-level box", ErrInvalidMP4)
+	}
+
+	mvhd, ok := mp4Find(moov.Children, "mvhd")
+	if !ok || len(mvhd.Payload()) < 100 {
+		return nil, fmt.Errorf("%w: missing mvhd", ErrInvalidMP4)
+	}
+
+	// next_track_ID ends mvhd in both versions; timescale and duration follow the creation and modification times.
+	header := mvhd.Payload()
+	trackID := binary.BigEndian.Uint32(header[len(header)-4:])
+	timescale := binary.BigEndian.Uint32(header[12:])
+	duration := binary.BigEndian.Uint32(header[16:])
+
+	if header[0] == 1 {
+		timescale = binary.BigEndian.Uint32(header[20:])
+
+		// The cover track is written with version 0 boxes and a single stts entry, both 32-bit.
+		wide := binary.BigEndian.Uint64(header[24:])
+		if wide > math.MaxUint32 {
+			return nil, fmt.Errorf("%w: movie duration %d does not fit a 32-bit cover track", ErrInvalidMP4, wide)
+		}
+
+		duration = uint32(wide)
+	}
+
+	be32 := func(values ...uint32) []byte {
+		var out []byte
+		for _, value := range values {
+			out = binary.BigEndian.AppendUint32(out, value)
+		}
+
+		return out
+	}
+
+	prefix := file[:moov.Offset]
+	mdat := encodeMP4Box("mdat", cover)
+	sampleOffset := uint32(len(prefix) + mp4BoxHeaderSize)
+	matrix := be32(0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000)
+
+	tkhd := encodeMP4FullBox("tkhd", 0, 3, be32(0, 0, trackID, 0, duration), make([]byte, 8),
+		make([]byte, 8), matrix, be32(coverTrackSize<<16, coverTrackSize<<16))
+	mdhd := encodeMP4FullBox("mdhd", 0, 0, be32(0, 0, timescale, duration), []byte{0x55, 0xc4, 0, 0})
+	hdlr := encodeMP4FullBox("hdlr", 0, 0, be32(0), []byte("vide"), make([]byte, 12), []byte("Cover\x00"))
+	vmhd := encodeMP4FullBox("vmhd", 0, 1, make([]byte, 8))
+	dinf := encodeMP4Box("dinf", encodeMP4FullBox("dref", 0, 0, be32(1), encodeMP4FullBox("url ", 0, 1)))
+
+	entry := encodeMP4Box(image.sampleEntry(), make([]byte, 6), []byte{0, 1}, make([]byte, 16),
+		[]byte{coverTrackSize >> 8, coverTrackSize & 0xff, coverTrackSize >> 8, coverTrackSize & 0xff},
+		be32(coverTrackResolution, coverTrackResolution, 0), []byte{0, 1}, make([]byte, 32),
+		[]byte{0, coverTrackDepth, 0xff, 0xff})
+
+	stbl := encodeMP4Box("stbl",
+		encodeMP4FullBox("stsd", 0, 0, be32(1), entry),
+		encodeMP4FullBox("stts", 0, 0, be32(1, 1, duration)),
+		encodeMP4FullBox("stsc", 0, 0, be32(1, 1, 1, 1)),
+		encodeMP4FullBox("stsz", 0, 0, be32(0, 1, uint32(len(cover)))),
+		encodeMP4FullBox("stco", 0, 0, be32(1, sampleOffset)),
+	)
+
+	trak := encodeMP4Box("trak", tkhd, encodeMP4Box("mdia", mdhd, hdlr, encodeMP4Box("minf", vmhd, dinf, stbl)))
+
+	children := make([][]byte, 0, len(moov.Children)+1)
+	for _, child := range moov.Children {
+		if child.Type == "mvhd" {
+			patched := bytes.Clone(child.Data)
+			binary.BigEndian.PutUint32(patched[len(patched)-4:], trackID+1)
+			children = append(children, patched)
+
+			continue
+		}
+
+		children = append(children, child.Data)
+	}
+
+	children = append(children, trak)
+
+	return slices.Concat(prefix, mdat, encodeMP4Box("moov", children...)), nil
+}
+
+// checkCoverTrack verifies with WalkMP4Boxes that file has a sound track and a video track whose sample
+// data is cover, and no ilst covr atom.
+func checkCoverTrack(file, cover []byte) error {
+	boxes, err := WalkMP4Boxes(file)
+	if err != nil {
+		return err
+	}
+
+	moov, ok := FindMP4Box(boxes, "moov")
+	if !ok {
+		return fmt.Errorf("%w: missing moov", ErrInvalidMP4)
+	}
+
+	if _, ok := FindMP4Box(moov.Children, "udta/meta/ilst/covr"); ok {
+		return fmt.Errorf("%w: unexpected ilst covr atom", ErrInvalidMP4)
+	}
+
+	handlers := map[string]int{}
+
+	for _, trak := range moov.Children {
+		if trak.Type != "trak" {
+			continue
+		}
+
+		hdlr, ok := FindMP4Box(trak.Children, "mdia/hdlr")
+		if !ok || len(hdlr.Payload()) < mp4FullBoxHeaderSize+8 {
+			return fmt.Errorf("%w: trak without hdlr", ErrInvalidMP4)
+		}
+
+		handlers[string(hdlr.Payload()[mp4FullBoxHeaderSize+4:mp4FullBoxHeaderSize+8])]++
+	}
+
+	if handlers["soun"] != 1 || handlers["vide"] != 1 {
+		return fmt.Errorf("%w: expected one sound and one video track, got %v", ErrInvalidMP4, handlers)
+	}
+
+	samples, err := mp4TrackSamples(file, moov)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(samples[len(samples)-1], cover) {
+		return fmt.Errorf("%w: cover track sample differs from the image", ErrInvalidMP4)
+	}
+
+	return nil
+}
@@ -0,0 +1,330 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+)
+
+// Crash harness defaults.
+const (
+	crashDefaultRuns     = 20
+	crashDefaultTimeout  = 30 * time.Second
+	crashBytesPerFixture = 2
+	crashMinBytes        = 4096
+	crashPollInterval    = 100 * time.Microsecond
+)
+
+// Sentinel errors for crash-consistency runs.
+var (
+	// ErrCrashUnsupported is returned on platforms without process groups and /proc/<pid>/io.
+	ErrCrashUnsupported = errors.New("crash-consistency runs need Linux")
+	// ErrCrashTimeout is returned when a run neither exits nor reaches its kill point in time.
+	ErrCrashTimeout = errors.New("run did not reach its kill point in time")
+	// ErrContainerChanged is returned when a rewritten file is no longer in the original container format.
+	ErrContainerChanged = errors.New("container format changed")
+	// ErrInvalidStructure is returned when a rewritten file fails the native structural walkers.
+	ErrInvalidStructure = errors.New("invalid file structure")
+)
+
+// KillPoint is when a run of the binary under test is sent SIGKILL. Exactly one field should be set;
+// the zero value kills the process as soon as it has started.
+type KillPoint struct {
+	// Delay is the wall-clock time after start.
+	Delay time.Duration
+	// Bytes is the number of bytes the process has written, as reported by wchar in /proc/<pid>/io.
+	// wchar counts every write(2), including to stdout and stderr.
+	Bytes uint64
+}
+
+// String describes the kill point, e.g. "after 12ms" or "after 4096 bytes written".
+func (p KillPoint) String() string {
+	if p.Bytes > 0 {
+		return fmt.Sprintf("after %d bytes written", p.Bytes)
+	}
+
+	return "after " + p.Delay.String()
+}
+
+// CrashOutcome classifies the target file after a run, by the name CrashResult prints.
+type CrashOutcome string
+
+// Crash outcomes. CrashOriginal and CrashRewritten are consistent; the others are not.
+const (
+	// CrashOriginal means the target is byte-identical to the fixture.
+	CrashOriginal CrashOutcome = "original"
+	// CrashRewritten means the target changed and is a structurally valid file of the same container format.
+	CrashRewritten CrashOutcome = "rewritten"
+	// CrashCorrupt means the target changed and fails validation.
+	CrashCorrupt CrashOutcome = "corrupt"
+	// CrashMissing means the target no longer exists.
+	CrashMissing CrashOutcome = "missing"
+)
+
+// CrashOptions configures RunCrashConsistency.
+type CrashOptions struct {
+	// Binary is the in-place editor under test, resolved with LookFor.
+	Binary string
+	// Args returns the arguments of a run editing target.
+	Args func(target string) []string
+	// Fixture is the file copied to a fresh directory before every run.
+	Fixture string
+	// Points are explicit kill points, each run once.
+	Points []KillPoint
+	// Runs is the number of additional runs at kill points drawn from Seed. Defaults to 20 when Points is empty.
+	Runs int
+	// Seed makes the drawn kill points reproducible.
+	Seed uint64
+	// MaxDelay bounds drawn delays. When both MaxDelay and MaxBytes are set, each run draws one or the other.
+	MaxDelay time.Duration
+	// MaxBytes bounds drawn byte counts. Defaults to twice the fixture size, and at least 4 KiB, when MaxDelay
+	// is zero.
+	MaxBytes uint64
+	// Validate, when set, runs on rewritten targets after the structural checks, e.g. to verify tags.
+	Validate func(path string) error
+	// Timeout bounds each run. Defaults to 30 s.
+	Timeout time.Duration
+}
+
+// CrashResult is the outcome of one run.
+type CrashResult struct {
+	Point KillPoint
+	// Killed is false when the process exited before reaching the kill point.
+	Killed  bool
+	Outcome CrashOutcome
+	// Problem explains a CrashCorrupt outcome.
+	Problem string
+	// Leftovers are the other files found next to the target afterwards, such as temporary copies.
+	Leftovers []string
+}
+
+// String describes the result on one line.
+func (r CrashResult) String() string {
+	out := "kill " + r.Point.String() + ": "
+	if !r.Killed {
+		out = r.Point.String() + " (exited first): "
+	}
+
+	out += string(r.Outcome)
+
+	if r.Problem != "" {
+		out += " (" + r.Problem + ")"
+	}
+
+	if len(r.Leftovers) > 0 {
+		out += ", left " + strings.Join(r.Leftovers, " ")
+	}
+
+	return out
+}
+
+// CrashReport gathers the results of every run.
+type CrashReport struct {
+	Fixture string
+	Seed    uint64
+	Results []CrashResult
+}
+
+// Consistent reports whether every run left either the original or a valid rewritten file.
+func (r CrashReport) Consistent() bool {
+	for _, result := range r.Results {
+		if result.Outcome != CrashOriginal && result.Outcome != CrashRewritten {
+			return false
+		}
+	}
+
+	return true
+}
+
+// String summarises the report, one run per line.
+func (r CrashReport) String() string {
+	var out strings.Builder
+
+	killed := 0
+
+	for _, result := range r.Results {
+		if result.Killed {
+			killed++
+		}
+	}
+
+	fmt.Fprintf(&out, "%s: %d runs, %d killed (seed %d)", r.Fixture, len(r.Results), killed, r.Seed)
+
+	for _, result := range r.Results {
+		out.WriteString("\n  " + result.String())
+	}
+
+	return out.String()
+}
+
+// RunCrashConsistency runs opts.Binary on fresh copies of opts.Fixture and sends SIGKILL to its process
+// group at every kill point, then classifies the target left behind. A changed target must be in the
+// fixture's container format and pass the native structural walkers (and metaflac, when installed, for FLAC).
+// The test is skipped on platforms other than Linux.
+func RunCrashConsistency(data test.Data, helpers test.Helpers, opts CrashOptions) CrashReport {
+	helpers.T().Helper()
+
+	binary := lookForOrFail(helpers.T(), opts.Binary)
+	original := readFixtureFile(helpers.T(), opts.Fixture)
+	report := CrashReport{Fixture: filepath.Base(opts.Fixture), Seed: opts.Seed}
+
+	if opts.Timeout == 0 {
+		opts.Timeout = crashDefaultTimeout
+	}
+
+	for run, point := range crashKillPoints(opts, len(original)) {
+		dir := data.Temp().Dir("crash", strconv.Itoa(run))
+		target := filepath.Join(dir, filepath.Base(opts.Fixture))
+		writeFixtureFile(helpers.T(), target, original)
+
+		killed, err := runUntilKill(binary, opts.Args(target), dir, point, opts.Timeout)
+		if errors.Is(err, ErrCrashUnsupported) {
+			helpers.T().Skip(err.Error())
+		}
+
+		if err != nil {
+			helpers.T().Log(fmt.Sprintf("run %d, %s: %v", run, point, err))
+			helpers.T().FailNow()
+		}
+
+		result := classifyCrash(target, original, opts.Validate)
+		result.Point = point
+		result.Killed = killed
+		result.Leftovers = crashLeftovers(dir, filepath.Base(target))
+		report.Results = append(report.Results, result)
+	}
+
+	return report
+}
+
+// RequireCrashConsistent fails the test unless every run of RunCrashConsistency left either the original
+// or a valid rewritten file. The report is logged either way.
+func RequireCrashConsistent(data test.Data, helpers test.Helpers, opts CrashOptions) CrashReport {
+	helpers.T().Helper()
+
+	report := RunCrashConsistency(data, helpers, opts)
+	helpers.T().Log(report.String())
+
+	if !report.Consistent() {
+		helpers.T().FailNow()
+	}
+
+	return report
+}
+
+// crashKillPoints returns the explicit kill points followed by the drawn ones.
+func crashKillPoints(opts CrashOptions, fixtureSize int) []KillPoint {
+	points := slices.Clone(opts.Points)
+
+	runs := opts.Runs
+	if runs == 0 && len(opts.Points) == 0 {
+		runs = crashDefaultRuns
+	}
+
+	maxBytes := opts.MaxBytes
+	if maxBytes == 0 && opts.MaxDelay == 0 {
+		//nolint:gosec // G115: sizes are non-negative.
+		maxBytes = max(uint64(fixtureSize)*crashBytesPerFixture, crashMinBytes)
+	}
+
+	rng := rand.New(rand.NewPCG(opts.Seed, 0)) //nolint:gosec // G404: reproducible kill points, not security.
+
+	for range runs {
+		useDelay := maxBytes == 0 || (opts.MaxDelay > 0 && rng.IntN(2) == 0)
+
+		if useDelay {
+			points = append(points, KillPoint{Delay: time.Duration(rng.Int64N(int64(opts.MaxDelay)))})
+		} else {
+			points = append(points, KillPoint{Bytes: rng.Uint64N(maxBytes) + 1})
+		}
+	}
+
+	return points
+}
+
+// classifyCrash compares the target with the original and validates it when it changed.
+func classifyCrash(target string, original []byte, validate func(path string) error) CrashResult {
+	current, err := os.ReadFile(target)
+	if err != nil {
+		return CrashResult{Outcome: CrashMissing, Problem: err.Error()}
+	}
+
+	if bytes.Equal(current, original) {
+		return CrashResult{Outcome: CrashOriginal}
+	}
+
+	if err := validateRewritten(target, current, sniffContainer(original)); err != nil {
+		return CrashResult{Outcome: CrashCorrupt, Problem: err.Error()}
+	}
+
+	if validate != nil {
+		if err := validate(target); err != nil {
+			return CrashResult{Outcome: CrashCorrupt, Problem: err.Error()}
+		}
+	}
+
+	return CrashResult{Outcome: CrashRewritten}
+}
+
+// validateRewritten checks that data is a structurally valid file in the given container format.
+func validateRewritten(path string, data []byte, format string) error {
+	if got := sniffContainer(data); got != format {
+		return fmt.Errorf("%w: %s -> %s (%d bytes)", ErrContainerChanged, format, got, len(data))
+	}
+
+	if check := nativeConformance(format, data); check.Verdict == ConformanceFail {
+		return fmt.Errorf("%w: %s", ErrInvalidStructure, strings.Join(check.Messages, "; "))
+	}
+
+	if format == PayloadFormatFLAC {
+		if _, err := ParseMetaflac(context.Background(), path); err != nil && !errors.Is(err, ErrBinaryNotFound) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// crashLeftovers lists the directory entries other than the target.
+func crashLeftovers(dir, target string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var leftovers []string
+
+	for _, entry := range entries {
+		if entry.Name() != target {
+			leftovers = append(leftovers, entry.Name())
+		}
+	}
+
+	return leftovers
+}
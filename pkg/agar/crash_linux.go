@@ -0,0 +1,136 @@
+//go:build linux
+
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// runUntilKill starts binary in its own process group and sends SIGKILL to the group at point.
+// It returns false when the process exited first.
+func runUntilKill(binary string, args []string, dir string, point KillPoint, timeout time.Duration) (bool, error) {
+	//nolint:gosec // arguments are test-controlled
+	cmd := exec.CommandContext(context.Background(), binary, args...)
+	cmd.Dir = dir
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := cmd.Start(); err != nil {
+		return false, fmt.Errorf("starting %s: %w", binary, err)
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		_ = cmd.Wait()
+
+		close(done)
+	}()
+
+	start := time.Now()
+	ticker := time.NewTicker(crashPollInterval)
+
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return false, nil
+		case <-ticker.C:
+		}
+
+		elapsed := time.Since(start)
+
+		reached := elapsed >= point.Delay
+		if point.Bytes > 0 {
+			// Other errors are transient, e.g. while the process is exiting: retry on the next tick.
+			written, err := processWrittenBytes(cmd.Process.Pid)
+			if errors.Is(err, ErrCrashUnsupported) {
+				return processGone(cmd.Process.Pid, done, err)
+			}
+
+			reached = err == nil && written >= point.Bytes
+		}
+
+		if reached || elapsed >= timeout {
+			_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+
+			<-done
+
+			if !reached {
+				return true, ErrCrashTimeout
+			}
+
+			return true, nil
+		}
+	}
+}
+
+// processGone handles /proc/<pid>/io being unavailable. A process reaped in the meantime has lost its /proc
+// entry and counts as exited; otherwise the process group is killed and err returned.
+func processGone(pid int, done <-chan struct{}, err error) (bool, error) {
+	if _, statErr := os.Stat("/proc/" + strconv.Itoa(pid)); errors.Is(statErr, fs.ErrNotExist) {
+		<-done
+
+		return false, nil
+	}
+
+	_ = syscall.Kill(-pid, syscall.SIGKILL)
+
+	<-done
+
+	return false, err
+}
+
+// processWrittenBytes reads wchar from /proc/<pid>/io. A missing or unreadable file means the kernel does not
+// provide process I/O accounting.
+func processWrittenBytes(pid int) (uint64, error) {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/io")
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+		return 0, fmt.Errorf("%w: %w", ErrCrashUnsupported, err)
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("reading process io: %w", err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "wchar: "); ok {
+			written, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("parsing wchar: %w", err)
+			}
+
+			return written, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: no wchar in /proc/%d/io", ErrCrashUnsupported, pid)
+}
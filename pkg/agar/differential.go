@@ -0,0 +1,450 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Reference decoder names.
+const (
+	DecoderFFmpeg = "ffmpeg"
+	DecoderFLAC   = "flac -d"
+	DecoderSox    = "sox"
+)
+
+// losslessCodecs lists the ffprobe names of lossless audio codecs. PCM and DSD codecs are matched by prefix.
+//
+//nolint:gochecknoglobals // lookup table
+var losslessCodecs = map[string]bool{
+	"flac":    true,
+	"alac":    true,
+	"wavpack": true,
+	"ape":     true,
+	"tta":     true,
+	"mlp":     true,
+	"truehd":  true,
+}
+
+// ErrDecoderNotApplicable is returned by a decoder that cannot handle the source format.
+// The decoder is reported as skipped rather than failed.
+var ErrDecoderNotApplicable = errors.New("decoder does not support this format")
+
+// Decoder decodes an audio file to PCM for differential comparison.
+type Decoder interface {
+	// Name identifies the decoder in reports.
+	Name() string
+	// Decode returns the decoded audio of src as interleaved little-endian signed PCM at bitDepth,
+	// keeping the source channel count. ErrBinaryNotFound and ErrDecoderNotApplicable skip the decoder.
+	Decode(t *testing.T, src string, bitDepth int) ([]byte, error)
+}
+
+// FFmpegDecoder decodes with ffmpeg, with the arguments of FFmpegDecode.
+type FFmpegDecoder struct{}
+
+// Name returns "ffmpeg".
+func (FFmpegDecoder) Name() string {
+	return DecoderFFmpeg
+}
+
+// Decode runs ffmpeg to raw output at bitDepth.
+func (FFmpegDecoder) Decode(t *testing.T, src string, bitDepth int) ([]byte, error) {
+	t.Helper()
+
+	result, err := RunFFmpeg(context.Background(), FFmpegOptions{
+		Args: ffmpegDecodeArgs(FFmpegDecodeOptions{Src: src, BitDepth: bitDepth}),
+	})
+
+	return result.Stdout, err
+}
+
+// FLACDecoder decodes FLAC files with the reference `flac -d`.
+type FLACDecoder struct{}
+
+// Name returns "flac -d".
+func (FLACDecoder) Name() string {
+	return DecoderFLAC
+}
+
+// Decode runs flac -d, with the arguments of FlacDecode, to raw output at the stream's bit depth,
+// then converts to bitDepth.
+// Sources other than native FLAC are not applicable.
+func (FLACDecoder) Decode(t *testing.T, src string, bitDepth int) ([]byte, error) {
+	t.Helper()
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := ReadFLAC(data[leadingID3v2Size(data):])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecoderNotApplicable, err)
+	}
+
+	block, _ := file.Block(FLACBlockStreamInfo)
+
+	info, err := ParseFLACStreamInfo(block.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := RunFlac(context.Background(), FlacOptions{Args: flacDecodeArgs(FlacDecodeOptions{Src: src})})
+	if err != nil {
+		return nil, err
+	}
+
+	return ConvertPCMBitDepth(result.Stdout, info.BitDepth, bitDepth), nil
+}
+
+// SoxDecoder decodes with sox, dithering disabled so that bit depth conversion is deterministic.
+type SoxDecoder struct{}
+
+// Name returns "sox".
+func (SoxDecoder) Name() string {
+	return DecoderSox
+}
+
+// Decode runs sox, with the arguments of SoxDecode, to raw output at bitDepth.
+func (SoxDecoder) Decode(t *testing.T, src string, bitDepth int) ([]byte, error) {
+	t.Helper()
+
+	result, err := RunSox(context.Background(), SoxOptions{
+		Args: soxDecodeArgs(SoxDecodeOptions{Src: src, BitDepth: bitDepth}),
+	})
+
+	return result.Stdout, err
+}
+
+// InProcessDecoder adapts a Go decoding function, such as CoreAudioDecode, to the Decoder interface.
+type InProcessDecoder struct {
+	// Label is the decoder name.
+	Label string
+	// Func decodes the encoded file content to interleaved little-endian signed PCM.
+	Func func(encoded []byte) ([]byte, error)
+	// BitDepth of the PCM returned by Func. Zero means Func already returns the requested bit depth.
+	BitDepth int
+}
+
+// Name returns the decoder label.
+func (d InProcessDecoder) Name() string {
+	return d.Label
+}
+
+// Decode reads src, runs Func, then converts to bitDepth. ErrCoreAudioUnavailable skips the decoder.
+func (d InProcessDecoder) Decode(t *testing.T, src string, bitDepth int) ([]byte, error) {
+	t.Helper()
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return nil, err
+	}
+
+	pcm, err := d.Func(data)
+	if errors.Is(err, ErrCoreAudioUnavailable) {
+		return nil, fmt.Errorf("%w: %w", ErrDecoderNotApplicable, err)
+	}
+
+	if err != nil || d.BitDepth == 0 {
+		return pcm, err
+	}
+
+	return ConvertPCMBitDepth(pcm, d.BitDepth, bitDepth), nil
+}
+
+// CommandDecoder runs a binary, typically the tool under test, that writes PCM to stdout.
+type CommandDecoder struct {
+	// Label is the decoder name.
+	Label string
+	// Binary is resolved with LookFor.
+	Binary string
+	// Args returns the arguments that decode src to stdout at bitDepth.
+	Args func(src string, bitDepth int) []string
+}
+
+// Name returns the decoder label.
+func (d CommandDecoder) Name() string {
+	return d.Label
+}
+
+// Decode runs the binary and returns its standard output.
+func (d CommandDecoder) Decode(t *testing.T, src string, bitDepth int) ([]byte, error) {
+	t.Helper()
+
+	return runTool(context.Background(), d.Binary, toolOptions{Args: d.Args(src, bitDepth)})
+}
+
+// ConvertPCMBitDepth converts interleaved little-endian signed PCM between bit depths. Samples are
+// right-justified in their containers (20-bit samples in 3 bytes). Widening shifts left and is exact;
+// narrowing shifts right, truncating without dither.
+func ConvertPCMBitDepth(pcm []byte, from, to int) []byte {
+	if from == to {
+		return pcm
+	}
+
+	fromBytes := PCMBytesPerSample(from)
+	toBytes := PCMBytesPerSample(to)
+	out := make([]byte, 0, len(pcm)/fromBytes*toBytes)
+
+	for offset := 0; offset+fromBytes <= len(pcm); offset += fromBytes {
+		sample := pcmValue(pcm[offset:], fromBytes)
+
+		if shift := to - from; shift > 0 {
+			sample <<= shift
+		} else {
+			sample >>= -shift
+		}
+
+		for idx := range toBytes {
+			out = append(out, byte(sample>>(bitsPerByte*idx)))
+		}
+	}
+
+	return out
+}
+
+// DifferentialOptions configures a differential decode.
+type DifferentialOptions struct {
+	// Src is the encoded fixture.
+	Src string
+	// BitDepth is the common PCM bit depth every decoder output is normalised to.
+	BitDepth int
+	// Channels in the source, used to locate differences.
+	Channels int
+	// Lossy forces ComparePCMLossy tolerances. Without it, the codec of Src decides: lossy codecs are compared
+	// with tolerances, lossless ones exactly.
+	Lossy bool
+}
+
+// DecoderOutput is the result of one decoder.
+type DecoderOutput struct {
+	Decoder string
+	PCM     []byte
+	// Err is set when the decoder failed or was skipped.
+	Err error
+	// Skipped is set when the decoder binary is missing or the decoder does not support the format.
+	Skipped bool
+}
+
+// DecoderPair is the comparison of two decoder outputs.
+type DecoderPair struct {
+	A, B       string
+	Comparison PCMComparison
+}
+
+// DifferentialReport holds every decoder output and the pairwise comparisons between the successful ones.
+type DifferentialReport struct {
+	Src string
+	// Lossy is set when outputs were compared with ComparePCMLossy tolerances.
+	Lossy   bool
+	Outputs []DecoderOutput
+	Pairs   []DecoderPair
+}
+
+// Agree reports whether decoders a and b produced matching output.
+func (r DifferentialReport) Agree(a, b string) bool {
+	for _, pair := range r.Pairs {
+		if (pair.A == a && pair.B == b) || (pair.A == b && pair.B == a) {
+			return pair.Comparison.Match
+		}
+	}
+
+	return false
+}
+
+// Groups partitions the successful decoders into sets that agree with each other, in decoder order.
+// A decoder joins the first group whose members all agree with it.
+func (r DifferentialReport) Groups() [][]string {
+	var groups [][]string
+
+	for _, output := range r.Outputs {
+		if output.Err != nil {
+			continue
+		}
+
+		placed := false
+
+		for idx, group := range groups {
+			if r.agreesWithAll(output.Decoder, group) {
+				groups[idx] = append(group, output.Decoder)
+				placed = true
+
+				break
+			}
+		}
+
+		if !placed {
+			groups = append(groups, []string{output.Decoder})
+		}
+	}
+
+	return groups
+}
+
+// Consistent reports whether at least two decoders succeeded and all of them agree.
+func (r DifferentialReport) Consistent() bool {
+	groups := r.Groups()
+
+	return len(groups) == 1 && len(groups[0]) > 1
+}
+
+// String summarises decoder status, agreement groups and disagreeing pairs.
+func (r DifferentialReport) String() string {
+	var out strings.Builder
+
+	fmt.Fprintf(&out, "differential decode of %s\n", r.Src)
+
+	if r.Lossy {
+		out.WriteString("  compared with lossy tolerances\n")
+	}
+
+	for _, output := range r.Outputs {
+		switch {
+		case output.Skipped:
+			fmt.Fprintf(&out, "  %s: skipped (%v)\n", output.Decoder, output.Err)
+		case output.Err != nil:
+			fmt.Fprintf(&out, "  %s: failed: %v\n", output.Decoder, output.Err)
+		default:
+			fmt.Fprintf(&out, "  %s: %d bytes\n", output.Decoder, len(output.PCM))
+		}
+	}
+
+	for idx, group := range r.Groups() {
+		fmt.Fprintf(&out, "  group %d: %s\n", idx+1, strings.Join(group, ", "))
+	}
+
+	for _, pair := range r.Pairs {
+		if !pair.Comparison.Match {
+			fmt.Fprintf(&out, "  %s vs %s: %s\n", pair.A, pair.B, pair.Comparison.Detail)
+		}
+	}
+
+	return strings.TrimSuffix(out.String(), "\n")
+}
+
+// agreesWithAll reports whether decoder agrees with every member of group.
+func (r DifferentialReport) agreesWithAll(decoder string, group []string) bool {
+	for _, member := range group {
+		if !r.Agree(decoder, member) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// DecodeDifferential decodes opts.Src with every decoder, then compares each pair of successful outputs.
+func DecodeDifferential(t *testing.T, opts DifferentialOptions, decoders ...Decoder) DifferentialReport {
+	t.Helper()
+
+	report := DifferentialReport{Src: opts.Src, Lossy: opts.Lossy || lossySource(opts.Src)}
+
+	for _, decoder := range decoders {
+		pcm, err := decoder.Decode(t, opts.Src, opts.BitDepth)
+		report.Outputs = append(report.Outputs, DecoderOutput{
+			Decoder: decoder.Name(),
+			PCM:     pcm,
+			Err:     err,
+			Skipped: errors.Is(err, ErrBinaryNotFound) || errors.Is(err, ErrDecoderNotApplicable),
+		})
+	}
+
+	for idx, first := range report.Outputs {
+		if first.Err != nil {
+			continue
+		}
+
+		for _, second := range report.Outputs[idx+1:] {
+			if second.Err != nil {
+				continue
+			}
+
+			report.Pairs = append(report.Pairs, DecoderPair{
+				A:          first.Decoder,
+				B:          second.Decoder,
+				Comparison: comparePCM(report.Lossy, opts, first.PCM, second.PCM),
+			})
+		}
+	}
+
+	return report
+}
+
+// RequireDecodersAgree runs DecodeDifferential and fails the test unless all successful decoders agree.
+// Decoder failures other than skips fail the test too.
+func RequireDecodersAgree(t *testing.T, opts DifferentialOptions, decoders ...Decoder) DifferentialReport {
+	t.Helper()
+
+	report := DecodeDifferential(t, opts, decoders...)
+
+	failed := false
+
+	for _, output := range report.Outputs {
+		if output.Err != nil && !output.Skipped {
+			failed = true
+		}
+	}
+
+	if failed || len(report.Groups()) > 1 {
+		t.Error(report.String())
+	} else {
+		t.Log(report.String())
+	}
+
+	return report
+}
+
+// lossySource reports whether src holds a lossy codec, as named by ffprobe. Without ffprobe, the container
+// decides: MPEG audio is lossy, anything else is taken as lossless.
+func lossySource(src string) bool {
+	if probe, err := FFProbe(src); err == nil {
+		if stream, err := probe.AudioStream(); err == nil {
+			codec := stream.CodecName
+
+			return !losslessCodecs[codec] && !strings.HasPrefix(codec, "pcm_") && !strings.HasPrefix(codec, "dsd_")
+		}
+	}
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return false
+	}
+
+	return sniffContainer(data) == PayloadFormatMPEG
+}
+
+// comparePCM compares two normalised outputs. Lossless comparison also requires equal lengths.
+func comparePCM(lossy bool, opts DifferentialOptions, pcmA, pcmB []byte) PCMComparison {
+	if lossy {
+		return ComparePCMLossy(pcmA, pcmB, opts.BitDepth, opts.Channels)
+	}
+
+	result := ComparePCMLossless(pcmA, pcmB, opts.BitDepth, opts.Channels)
+	if result.Match && len(pcmA) != len(pcmB) {
+		return PCMComparison{
+			LengthMismatch: true,
+			Detail:         fmt.Sprintf("length mismatch: a=%d, b=%d", len(pcmA), len(pcmB)),
+		}
+	}
+
+	return result
+}
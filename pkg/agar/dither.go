@@ -0,0 +1,282 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+	"github.com/containerd/nerdctl/mod/tigron/tig"
+)
+
+// Dither fixture and analysis parameters.
+const (
+	// ditherToneHz is the AES17 test tone frequency, not a submultiple of common sample rates.
+	ditherToneHz = 997
+	// ditherToneDBFS is low enough that undithered requantization error is strongly correlated with the tone.
+	ditherToneDBFS        = -60.0
+	ditherSampleRate      = 44100
+	ditherChannels        = 2
+	ditherDurationSec     = 3
+	ditherAnalysisSize    = 4096
+	ditherLowBandHz       = 2000
+	ditherMidBandHz       = 5000
+	ditherHighBandLowHz   = 15000
+	ditherSpurHz          = 1500
+	ditherSpurDB          = 20.0
+	ditherShapedTiltDB    = 10.0
+	ditherRectangularDB   = 3.0
+	ditherTPDFDB          = 4.8
+	ditherUndetectedDB    = 1.5
+	ditherDecisionSpanDB  = 1.5
+	ditherNoiseSpanDB     = 3.0
+	ditherHighBandMaxHz   = 20000.0
+	dbPerAmplitudeDecibel = 20
+)
+
+// Dither selects how DitherPCM reduces bit depth, and is what AnalyzeDither reports.
+type Dither string
+
+// Bit depth reduction methods.
+const (
+	// DitherNone rounds to the nearest step: the requantization error follows the signal.
+	DitherNone Dither = "none"
+	// DitherRectangular adds uniform noise of one step peak-to-peak before rounding.
+	DitherRectangular Dither = "rectangular"
+	// DitherTPDF adds triangular noise of two steps peak-to-peak before rounding, which makes the error
+	// independent of the signal in mean and variance.
+	DitherTPDF Dither = "tpdf"
+	// DitherNoiseShaped is TPDF dither with second-order error feedback, moving the noise towards Nyquist.
+	DitherNoiseShaped Dither = "noise-shaped"
+)
+
+// DitherPCM reduces interleaved little-endian signed PCM from one bit depth to a lower one with the given
+// dither. Noise comes from the deterministic PRNG of GenerateWhiteNoise, so output is reproducible; error
+// feedback runs per channel.
+func DitherPCM(pcm []byte, from, to, channels int, dither Dither) []byte {
+	fromBytes, toBytes := PCMBytesPerSample(from), PCMBytesPerSample(to)
+	step := math.Ldexp(1, from-to)
+	limit := math.Ldexp(1, to-1)
+	seed := xorshiftSeed
+
+	// uniform returns a value in [-0.5, 0.5).
+	uniform := func() float64 {
+		seed ^= seed << xorshiftShiftA
+		seed ^= seed >> xorshiftShiftB
+		seed ^= seed << xorshiftShiftC
+
+		return float64(seed>>11)/(1<<53) - 0.5 //nolint:mnd // 53-bit mantissa from a 64-bit state.
+	}
+
+	// feedback holds the last two requantization errors of each channel, for noise shaping.
+	feedback := make([][2]float64, channels)
+	out := make([]byte, 0, len(pcm)/fromBytes*toBytes)
+
+	for idx := 0; idx+fromBytes <= len(pcm); idx += fromBytes {
+		channel := idx / fromBytes % channels
+		value := float64(pcmValue(pcm[idx:], fromBytes)) / step
+
+		var noise float64
+
+		switch dither {
+		case DitherRectangular:
+			noise = uniform()
+		case DitherTPDF:
+			noise = uniform() + uniform()
+		case DitherNoiseShaped:
+			noise = uniform() + uniform()
+			value -= 2*feedback[channel][0] - feedback[channel][1]
+		case DitherNone:
+		}
+
+		quantized := min(max(math.Round(value+noise), -limit), limit-1)
+
+		feedback[channel] = [2]float64{quantized - value, feedback[channel][0]}
+
+		for shift := range toBytes {
+			out = append(out, byte(int64(quantized)>>(bitsPerByte*shift)))
+		}
+	}
+
+	return out
+}
+
+// DitherSource24bit returns path to a 3 second 24-bit 44.1kHz stereo WAV holding a 997 Hz tone at -60 dBFS,
+// the input of the Dithered fixtures. A bit depth converter under test can be run on it and its output
+// checked with AnalyzeDither.
+func DitherSource24bit(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	return writeDitherWAV(helpers.T(), filepath.Join(data.Temp().Dir(), "dither-source-24bit.wav"),
+		BitDepth24, ditherSource())
+}
+
+// Dithered returns path to the DitherSource24bit tone reduced to 16 bits with the given dither.
+func Dithered(data test.Data, helpers test.Helpers, dither Dither) string {
+	helpers.T().Helper()
+
+	pcm := DitherPCM(ditherSource(), BitDepth24, BitDepth16, ditherChannels, dither)
+
+	return writeDitherWAV(helpers.T(), filepath.Join(data.Temp().Dir(), "dithered-"+string(dither)+".wav"),
+		BitDepth16, pcm)
+}
+
+// ditherSource synthesizes the 24-bit test tone.
+func ditherSource() []byte {
+	frames := ditherSampleRate * ditherDurationSec
+	amplitude := math.Pow(10, ditherToneDBFS/dbPerAmplitudeDecibel) * math.Ldexp(1, BitDepth24-1)
+	out := make([]byte, 0, frames*ditherChannels*PCMBytesPerSample(BitDepth24))
+
+	for frame := range frames {
+		sample := int32(math.Round(amplitude * math.Sin(2*math.Pi*ditherToneHz*float64(frame)/ditherSampleRate)))
+
+		for range ditherChannels {
+			out = append(out, byte(sample), byte(sample>>bitsPerByte), byte(sample>>(2*bitsPerByte)))
+		}
+	}
+
+	return out
+}
+
+// writeDitherWAV writes pcm as a well-formed WAV at the dither fixture rate and channel count.
+func writeDitherWAV(helper tig.T, path string, bitDepth int, pcm []byte) string {
+	helper.Helper()
+
+	format := WAVFormat{SampleRate: ditherSampleRate, BitDepth: bitDepth, Channels: ditherChannels}
+	file, err := BuildWAV(format, pcm, WAVWellFormed, WAVChunksNone)
+	if err != nil {
+		helper.Log(err.Error())
+		helper.FailNow()
+	}
+
+	writeFixtureFile(helper, path, file.Bytes())
+
+	return path
+}
+
+// DitherAnalysis describes the requantization noise of a 16-bit (or other) signal made of a few tones.
+type DitherAnalysis struct {
+	// FloorDB is the noise floor in the 2-15 kHz band relative to the floor of plain rounding to the
+	// same step (step²/12 of white noise): about 0 dB undithered, +3 dB rectangular, +4.8 dB TPDF.
+	FloorDB float64
+	// TiltDB is the 15-20 kHz noise level minus the 2-5 kHz level. Noise shaping makes it strongly positive.
+	TiltDB float64
+	// SpurDB is the strongest bin above 1.5 kHz over the floor. Undithered requantization of a tone leaves
+	// harmonic spurs; dither turns them into noise.
+	SpurDB float64
+	// Dither is the classification.
+	Dither Dither
+	// Confidence is between 0 and 1, for the classification given by Dither.
+	Confidence float64
+}
+
+// Dithered reports whether any dither was found.
+func (a DitherAnalysis) Dithered() bool {
+	return a.Dither != DitherNone
+}
+
+// String summarises the analysis on one line.
+func (a DitherAnalysis) String() string {
+	return fmt.Sprintf("dither %s (confidence %.2f): floor %+.1f dB, tilt %+.1f dB, spur %.1f dB",
+		a.Dither, a.Confidence, a.FloorDB, a.TiltDB, a.SpurDB)
+}
+
+// AnalyzeDither classifies the requantization noise of interleaved little-endian signed PCM. It expects
+// sparse tonal material such as DitherSource24bit, where everything between the tones is requantization
+// noise; music carries its own noise and cannot be classified this way. Floors are bin medians, so tones
+// do not bias them.
+func AnalyzeDither(pcm []byte, bitDepth, channels, sampleRate int) DitherAnalysis {
+	if !pcmLayoutValid(bitDepth, channels) {
+		return DitherAnalysis{Dither: DitherNone}
+	}
+
+	// Analyse the first channel in units of one quantization step.
+	width := PCMBytesPerSample(bitDepth)
+	first := make([]float64, len(pcm)/width/channels)
+
+	for idx := range first {
+		first[idx] = float64(pcmValue(pcm[idx*width*channels:], width))
+	}
+
+	power := powerSpectrum(first, ditherAnalysisSize)
+	windowEnergy := 0.0
+
+	for _, weight := range hannWindow(ditherAnalysisSize) {
+		windowEnergy += weight * weight
+	}
+
+	// White noise of variance 1/12 step² gives a mean bin power of windowEnergy/12. Welch averaging makes
+	// the bin powers close to normal, so their median is their mean.
+	roundingFloor := windowEnergy / 12 //nolint:mnd // variance of uniform rounding error.
+
+	levels := make([]float64, len(power))
+	for idx, value := range power {
+		levels[idx] = decibels(value / roundingFloor)
+	}
+
+	nyquist := float64(sampleRate) / 2
+	analysis := DitherAnalysis{
+		FloorDB: medianBandDB(levels, sampleRate, ditherLowBandHz, ditherHighBandLowHz),
+		TiltDB: medianBandDB(levels, sampleRate, ditherHighBandLowHz, min(ditherHighBandMaxHz, nyquist)) -
+			medianBandDB(levels, sampleRate, ditherLowBandHz, ditherMidBandHz),
+	}
+
+	spur := math.Inf(-1)
+	for idx := frequencyBin(ditherSpurHz, sampleRate, len(levels)); idx < len(levels); idx++ {
+		spur = max(spur, levels[idx])
+	}
+
+	analysis.SpurDB = spur - analysis.FloorDB
+	analysis.Dither, analysis.Confidence = classifyDither(analysis)
+
+	return analysis
+}
+
+// classifyDither maps the noise measurements to a dither and a confidence, the latter growing with the
+// distance from the nearest decision boundary.
+func classifyDither(analysis DitherAnalysis) (Dither, float64) {
+	margin := func(distance, span float64) float64 {
+		return min(max(distance/span, 0), 1)
+	}
+
+	switch {
+	case analysis.TiltDB >= ditherShapedTiltDB:
+		return DitherNoiseShaped, margin(analysis.TiltDB-ditherShapedTiltDB, ditherShapedTiltDB)
+	case analysis.SpurDB >= ditherSpurDB || analysis.FloorDB < ditherUndetectedDB:
+		return DitherNone, max(margin(analysis.SpurDB-ditherSpurDB, ditherSpurDB),
+			margin(ditherUndetectedDB-analysis.FloorDB, ditherDecisionSpanDB))
+	}
+
+	boundary := (ditherRectangularDB + ditherTPDFDB) / 2 //nolint:mnd // midway between the two floors.
+	if analysis.FloorDB < boundary {
+		return DitherRectangular, margin(min(boundary-analysis.FloorDB, analysis.FloorDB-ditherUndetectedDB),
+			(boundary-ditherUndetectedDB)/2) //nolint:mnd // half the width of the rectangular range.
+	}
+
+	// A floor well above TPDF is noise in the material itself, which this analysis cannot classify.
+	return DitherTPDF, margin(min(analysis.FloorDB-boundary, ditherTPDFDB+ditherNoiseSpanDB-analysis.FloorDB),
+		boundary-ditherRectangularDB)
+}
+
+// medianBandDB returns the median level of the bins between two frequencies.
+func medianBandDB(levels []float64, sampleRate int, lowHz, highHz float64) float64 {
+	low, high := frequencyBin(lowHz, sampleRate, len(levels)), frequencyBin(highHz, sampleRate, len(levels))
+
+	return median(levels[low : high+1])
+}
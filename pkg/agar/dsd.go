@@ -17,9 +17,10 @@
 package agar
 
 import (
+	"encoding/binary"
 	"fmt"
 	"math"
-	"os"
+	"math/bits"
 	"path/filepath"
 
 	"github.com/containerd/nerdctl/mod/tigron/tig"
@@ -45,8 +46,13 @@ const (
 	// bitsPerByte is used for bit-packing arithmetic.
 	bitsPerByte = 8
 
-	// dsdFileMode is the permission mode for generated DSD test files.
-	dsdFileMode = 0o600
+	// DSF layout: chunk sizes, format version, LSB-first bit order, and per-channel block size.
+	dsfDSDChunkSize   = 28
+	dsfFmtChunkSize   = 52
+	dsfDataHeaderSize = 12
+	dsfFormatVersion  = 1
+	dsfBitsLSBFirst   = 1
+	dsfBlockSize      = 4096
 )
 
 // DSDSine writes raw DSD bytes for a sine wave at the given frequency.
@@ -56,6 +62,16 @@ const (
 func DSDSine(dir string, helper tig.T, dsdRate int, freqHz float64) string {
 	helper.Helper()
 
+	dsdBytes := dsdSine(dsdRate, freqHz)
+
+	outputPath := filepath.Join(dir, fmt.Sprintf("dsd-sine-%dhz-%d.raw", int(freqHz), dsdRate))
+	writeFixtureFile(helper, outputPath, dsdBytes)
+
+	return outputPath
+}
+
+// dsdSine returns packed DSD bytes (MSB first) for a sine wave at the given frequency.
+func dsdSine(dsdRate int, freqHz float64) []byte {
 	numSamples := int(dsdTestDuration * dsdBasePCMRate)
 	pcm := make([]float64, numSamples)
 
@@ -63,13 +79,7 @@ func DSDSine(dir string, helper tig.T, dsdRate int, freqHz float64) string {
 		pcm[sample] = dsdSineAmplitude * math.Sin(2*math.Pi*freqHz*float64(sample)/dsdBasePCMRate)
 	}
 
-	oversampleRatio := dsdRate / dsdBasePCMRate
-	dsdBytes := sigmaDeltaModulate(pcm, oversampleRatio)
-
-	outputPath := filepath.Join(dir, fmt.Sprintf("dsd-sine-%dhz-%d.raw", int(freqHz), dsdRate))
-	writeDSDFile(helper, outputPath, dsdBytes)
-
-	return outputPath
+	return sigmaDeltaModulate(pcm, dsdRate/dsdBasePCMRate)
 }
 
 // DSDSilence writes raw DSD bytes for silence (zero signal).
@@ -86,7 +96,7 @@ func DSDSilence(dir string, helper tig.T, dsdRate int) string {
 	dsdBytes := sigmaDeltaModulate(pcm, oversampleRatio)
 
 	outputPath := filepath.Join(dir, fmt.Sprintf("dsd-silence-%d.raw", dsdRate))
-	writeDSDFile(helper, outputPath, dsdBytes)
+	writeFixtureFile(helper, outputPath, dsdBytes)
 
 	return outputPath
 }
@@ -110,7 +120,7 @@ func DSDDC(dir string, helper tig.T, dsdRate int, level float64) string {
 	dsdBytes := sigmaDeltaModulate(pcm, oversampleRatio)
 
 	outputPath := filepath.Join(dir, fmt.Sprintf("dsd-dc-%.2f-%d.raw", level, dsdRate))
-	writeDSDFile(helper, outputPath, dsdBytes)
+	writeFixtureFile(helper, outputPath, dsdBytes)
 
 	return outputPath
 }
@@ -161,11 +171,65 @@ func sigmaDeltaModulate(pcm []float64, oversampleRatio int) []byte {
 	return packed
 }
 
-func writeDSDFile(helper tig.T, path string, data []byte) {
-	helper.Helper()
+// dsfChannelTypes maps channel counts to DSF channel types: 4 is quad (not 4.0 with LFE, type 5),
+// 5 is L, R, C, Ls, Rs (type 6) and 6 is 5.1 (type 7).
+//
+//nolint:gochecknoglobals // lookup table
+var dsfChannelTypes = map[int]uint32{1: 1, 2: 2, 3: 3, 4: 4, 5: 6, 6: 7}
+
+// EncodeDSF wraps per-channel DSD bytes (MSB first, as produced by the DSD generators) in a DSF container.
+// All channels must have the same length. Samples are stored LSB first, in 4096-byte blocks per channel,
+// with the last block zero-padded. When metadata is non-empty (typically an ID3v2 tag),
+// it is appended after the data chunk and referenced by the metadata pointer.
+// DSF defines layouts for 1 to 6 channels; other counts are written with channel type 0, which is invalid.
+//
+//nolint:gosec // G115: fixture sizes and channel counts are small.
+func EncodeDSF(channels [][]byte, dsdRate int, metadata []byte) []byte {
+	channelBytes := 0
+	if len(channels) > 0 {
+		channelBytes = len(channels[0])
+	}
+
+	blocks := (channelBytes + dsfBlockSize - 1) / dsfBlockSize
+	dataSize := dsfDataHeaderSize + blocks*dsfBlockSize*len(channels)
+	fileSize := dsfDSDChunkSize + dsfFmtChunkSize + dataSize + len(metadata)
 
-	if err := os.WriteFile(path, data, dsdFileMode); err != nil {
-		helper.Log("writing DSD file: " + err.Error())
-		helper.FailNow()
+	var metadataOffset uint64
+	if len(metadata) > 0 {
+		metadataOffset = uint64(dsfDSDChunkSize + dsfFmtChunkSize + dataSize)
 	}
+
+	out := []byte("DSD ")
+	out = binary.LittleEndian.AppendUint64(out, dsfDSDChunkSize)
+	out = binary.LittleEndian.AppendUint64(out, uint64(fileSize))
+	out = binary.LittleEndian.AppendUint64(out, metadataOffset)
+
+	out = append(out, "fmt "...)
+	out = binary.LittleEndian.AppendUint64(out, dsfFmtChunkSize)
+	out = binary.LittleEndian.AppendUint32(out, dsfFormatVersion)
+	out = binary.LittleEndian.AppendUint32(out, 0) // format ID: DSD raw
+	out = binary.LittleEndian.AppendUint32(out, dsfChannelTypes[len(channels)])
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(channels)))
+	out = binary.LittleEndian.AppendUint32(out, uint32(dsdRate))
+	out = binary.LittleEndian.AppendUint32(out, dsfBitsLSBFirst)
+	out = binary.LittleEndian.AppendUint64(out, uint64(channelBytes*bitsPerByte))
+	out = binary.LittleEndian.AppendUint32(out, dsfBlockSize)
+	out = binary.LittleEndian.AppendUint32(out, 0) // reserved
+
+	out = append(out, "data"...)
+	out = binary.LittleEndian.AppendUint64(out, uint64(dataSize))
+
+	for block := range blocks {
+		for _, channel := range channels {
+			chunk := make([]byte, dsfBlockSize)
+
+			for idx, value := range channel[block*dsfBlockSize : min((block+1)*dsfBlockSize, len(channel))] {
+				chunk[idx] = bits.Reverse8(value)
+			}
+
+			out = append(out, chunk...)
+		}
+	}
+
+	return append(out, metadata...)
 }
@@ -17,10 +17,8 @@
 package agar
 
 import (
-	"bytes"
 	"context"
 	"io"
-	"os/exec"
 	"strconv"
 	"testing"
 )
@@ -42,7 +40,7 @@ type FFmpegOptions struct {
 	// When nil, stdout is captured and returned in FFmpegResult.Stdout.
 	Stdout io.Writer
 	// Stderr receives the command's standard error when non-nil.
-	// When nil, stderr is captured and included in the fatal message on failure.
+	// When nil, stderr is captured and included in the ToolError on failure.
 	Stderr io.Writer
 }
 
@@ -58,42 +56,20 @@ type FFmpegResult struct {
 func FFmpeg(t *testing.T, opts FFmpegOptions) FFmpegResult {
 	t.Helper()
 
-	ffmpegPath, err := LookFor(ffmpegBinary)
+	result, err := RunFFmpeg(context.Background(), opts)
 	if err != nil {
-		t.Log(ffmpegBinary + ": " + err.Error())
-		t.FailNow()
+		t.Fatal(err.Error())
 	}
 
-	//nolint:gosec // arguments are test-controlled
-	cmd := exec.CommandContext(context.Background(), ffmpegPath, opts.Args...)
-
-	if opts.Stdin != nil {
-		cmd.Stdin = opts.Stdin
-	}
-
-	var stdoutBuf bytes.Buffer
-
-	if opts.Stdout != nil {
-		cmd.Stdout = opts.Stdout
-	} else {
-		cmd.Stdout = &stdoutBuf
-	}
-
-	var stderrBuf bytes.Buffer
-
-	if opts.Stderr != nil {
-		cmd.Stderr = opts.Stderr
-	} else {
-		cmd.Stderr = &stderrBuf
-	}
+	return result
+}
 
-	if err := cmd.Run(); err != nil {
-		t.Fatalf("ffmpeg: %v\n%s", err, stderrBuf.String())
-	}
+// RunFFmpeg runs ffmpeg with the given options until it exits or ctx is done.
+// Failures are returned as a *ToolError.
+func RunFFmpeg(ctx context.Context, opts FFmpegOptions) (FFmpegResult, error) {
+	stdout, err := runTool(ctx, ffmpegBinary, toolOptions(opts))
 
-	return FFmpegResult{
-		Stdout: stdoutBuf.Bytes(),
-	}
+	return FFmpegResult{Stdout: stdout}, err
 }
 
 // FFmpegEncodeOptions configures encoding raw PCM to a compressed format.
@@ -157,21 +133,8 @@ type FFmpegDecodeOptions struct {
 func FFmpegDecode(t *testing.T, opts FFmpegDecodeOptions) []byte {
 	t.Helper()
 
-	args := []string{
-		"-i", opts.Src,
-		"-f", RawPCMFormat(opts.BitDepth),
-	}
-
-	if opts.Channels > 0 {
-		args = append(args, "-ac", strconv.Itoa(opts.Channels))
-	}
-
-	args = append(args, "-acodec", RawPCMCodec(opts.BitDepth))
-	args = append(args, opts.Args...)
-	args = append(args, "-")
-
 	result := FFmpeg(t, FFmpegOptions{
-		Args:   args,
+		Args:   ffmpegDecodeArgs(opts),
 		Stdout: opts.Stdout,
 	})
 
@@ -205,3 +168,20 @@ func RawPCMCodec(bitDepth int) string {
 		return "pcm_s16le"
 	}
 }
+
+// ffmpegDecodeArgs returns the ffmpeg arguments that decode opts.Src to raw PCM on stdout.
+func ffmpegDecodeArgs(opts FFmpegDecodeOptions) []string {
+	args := []string{
+		"-i", opts.Src,
+		"-f", RawPCMFormat(opts.BitDepth),
+	}
+
+	if opts.Channels > 0 {
+		args = append(args, "-ac", strconv.Itoa(opts.Channels))
+	}
+
+	args = append(args, "-acodec", RawPCMCodec(opts.BitDepth))
+	args = append(args, opts.Args...)
+
+	return append(args, "-")
+}
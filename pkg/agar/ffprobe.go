@@ -61,6 +61,22 @@ type FFProbeStream struct {
 	NbFrames         string `json:"nb_frames,omitempty"`
 	DurationTS       int64  `json:"duration_ts,omitempty"`
 	TimeBase         string `json:"time_base,omitempty"`
+	// Disposition flags distinguish cover art (attached_pic) from real video.
+	Disposition FFProbeDisposition `json:"disposition"`
+	// Tags holds stream metadata, such as the filename and mimetype of Matroska attachments.
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// FFProbeDisposition holds the stream disposition flags reported by ffprobe (1 when set).
+type FFProbeDisposition struct {
+	Default         int `json:"default"`
+	Dub             int `json:"dub"`
+	Original        int `json:"original"`
+	Comment         int `json:"comment"`
+	Forced          int `json:"forced"`
+	AttachedPic     int `json:"attached_pic"`
+	TimedThumbnails int `json:"timed_thumbnails"`
+	StillImage      int `json:"still_image"`
 }
 
 // FFProbeFormat represents container-level metadata from ffprobe.
@@ -119,6 +135,29 @@ func (r *FFProbeResult) AudioStream() (*FFProbeStream, error) {
 	return nil, ErrNoAudioStream
 }
 
+// CoverArtStreams returns the attached_pic video streams (MP4 covr, ID3 APIC and FLAC PICTURE as seen by ffmpeg).
+func (r *FFProbeResult) CoverArtStreams() []FFProbeStream {
+	var covers []FFProbeStream
+
+	for _, stream := range r.Streams {
+		if stream.IsCoverArt() {
+			covers = append(covers, stream)
+		}
+	}
+
+	return covers
+}
+
+// IsCoverArt reports whether the stream is a picture attached to the audio rather than real video.
+func (s *FFProbeStream) IsCoverArt() bool {
+	return s.CodecType == "video" && s.Disposition.AttachedPic == 1
+}
+
+// IsAttachment reports whether the stream is a Matroska attachment (fonts, cover images).
+func (s *FFProbeStream) IsAttachment() bool {
+	return s.CodecType == "attachment"
+}
+
 // BitDepth returns the effective bit depth for the stream.
 // It prefers BitsPerRawSample (most reliable for lossless codecs like FLAC/ALAC),
 // falls back to BitsPerSample (reliable for WAV/AIFF), then defaults to 16.
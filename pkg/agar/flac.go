@@ -0,0 +1,134 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"testing"
+)
+
+// FlacOptions configures a flac invocation.
+type FlacOptions struct {
+	// Args are passed directly to the flac binary.
+	Args []string
+	// Stdin is connected to the command's standard input when non-nil.
+	Stdin io.Reader
+	// Stdout receives the command's standard output when non-nil.
+	// When nil, stdout is captured and returned in FlacResult.Stdout.
+	Stdout io.Writer
+	// Stderr receives the command's standard error when non-nil.
+	// When nil, stderr is captured and included in the ToolError on failure.
+	Stderr io.Writer
+}
+
+// FlacResult holds captured output from a flac invocation.
+type FlacResult struct {
+	// Stdout contains captured standard output, populated only when
+	// FlacOptions.Stdout was nil.
+	Stdout []byte
+}
+
+// Flac runs the reference flac encoder/decoder with the given options.
+// It fatals the test if flac cannot be found or the command returns an error.
+func Flac(t *testing.T, opts FlacOptions) FlacResult {
+	t.Helper()
+
+	result, err := RunFlac(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return result
+}
+
+// RunFlac runs flac with the given options until it exits or ctx is done.
+// Failures are returned as a *ToolError.
+func RunFlac(ctx context.Context, opts FlacOptions) (FlacResult, error) {
+	stdout, err := runTool(ctx, flacBinary, toolOptions(opts))
+
+	return FlacResult{Stdout: stdout}, err
+}
+
+// FlacEncodeOptions configures encoding raw PCM to FLAC with the reference encoder.
+type FlacEncodeOptions struct {
+	// Src is the path to the raw little-endian signed PCM input file.
+	Src string
+	// Dst is the path for the encoded output file.
+	Dst string
+	// BitDepth of the input PCM (--bps).
+	BitDepth int
+	// SampleRate of the input PCM (--sample-rate).
+	SampleRate int
+	// Channels in the input PCM (--channels).
+	Channels int
+	// Args are optional extra encoder options placed before the input.
+	// For example: "-8", "--no-padding".
+	Args []string
+}
+
+// FlacEncode encodes a raw PCM file to FLAC.
+// It fatals the test if flac cannot be found or the command returns an error.
+func FlacEncode(t *testing.T, opts FlacEncodeOptions) {
+	t.Helper()
+
+	args := []string{
+		"-s", "-f",
+		"--force-raw-format", "--endian=little", "--sign=signed",
+		"--bps=" + strconv.Itoa(opts.BitDepth),
+		"--sample-rate=" + strconv.Itoa(opts.SampleRate),
+		"--channels=" + strconv.Itoa(opts.Channels),
+	}
+
+	args = append(args, opts.Args...)
+	args = append(args, "-o", opts.Dst, opts.Src)
+
+	Flac(t, FlacOptions{Args: args})
+}
+
+// FlacDecodeOptions configures decoding a FLAC file to raw PCM with the reference decoder.
+type FlacDecodeOptions struct {
+	// Src is the path to the FLAC input file.
+	Src string
+	// Stdout receives the decoded PCM. When nil, output is captured and returned as []byte.
+	Stdout io.Writer
+	// Args are optional extra decoder options placed before the input.
+	// For example: "-F" to continue through errors.
+	Args []string
+}
+
+// FlacDecode decodes a FLAC file to raw little-endian signed PCM at the stream's own bit depth and channel count.
+// Returns captured PCM bytes when Stdout is nil; returns nil when Stdout is non-nil.
+func FlacDecode(t *testing.T, opts FlacDecodeOptions) []byte {
+	t.Helper()
+
+	result := Flac(t, FlacOptions{
+		Args:   flacDecodeArgs(opts),
+		Stdout: opts.Stdout,
+	})
+
+	return result.Stdout
+}
+
+// flacDecodeArgs returns the flac arguments that decode opts.Src to raw PCM on stdout.
+func flacDecodeArgs(opts FlacDecodeOptions) []string {
+	args := []string{"-d", "-s", "-c", "--force-raw-format", "--endian=little", "--sign=signed"}
+	args = append(args, opts.Args...)
+
+	return append(args, opts.Src)
+}
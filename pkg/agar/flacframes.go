@@ -0,0 +1,395 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// FLAC frame layout constants.
+const (
+	flacStreamInfoSize      = 34
+	flacFrameHeaderMinSize  = 5
+	flacFrameFooterSize     = 2
+	flacVariableBlockSize   = 0x01
+	flacCRC8Polynomial      = 0x07
+	flacCRC16Polynomial     = 0x8005
+	flacUTF8MaxBytes        = 7
+	flacSampleRateFromInfo  = 0
+	flacSampleRate8BitKHz   = 12
+	flacSampleRate16BitHz   = 13
+	flacSampleRate16Bit10Hz = 14
+	flacShortBlockSize      = 192
+	flacBlockSize576        = 576
+	flacBlockSize576Code    = 2
+	flacBlockSize8Bit       = 6
+	flacBlockSize16Bit      = 7
+	flacBlockSize256        = 256
+	flacBlockSize256Code    = 8
+	flacChannelsIndependent = 8
+	flacChannelsReserved    = 11
+	flacMaxBitDepthCode     = 7
+)
+
+// FLAC stereo decorrelation channel assignments. Assignments 0-7 code 1-8 independent channels.
+const (
+	FLACChannelLeftSide  = 8
+	FLACChannelRightSide = 9
+	FLACChannelMidSide   = 10
+)
+
+// Sentinel errors for FLAC frame parsing.
+var (
+	ErrInvalidStreamInfo = errors.New("invalid STREAMINFO block")
+	ErrInvalidFLACFrame  = errors.New("invalid FLAC frame")
+)
+
+// flacFixedSampleRates maps frame header sample rate codes 1-11 to Hz.
+var flacFixedSampleRates = []int{ //nolint:gochecknoglobals // lookup table
+	0, 88200, 176400, 192000, 8000, 16000, 22050, 24000, 32000, 44100, 48000, 96000,
+}
+
+// flacBitDepths maps frame header sample size codes to bits; 0 means "from STREAMINFO", -1 is reserved.
+var flacBitDepths = []int{0, 8, 12, -1, 16, 20, 24, 32} //nolint:gochecknoglobals // lookup table
+
+// FLACStreamInfo is a decoded STREAMINFO block.
+type FLACStreamInfo struct {
+	MinBlockSize int
+	MaxBlockSize int
+	MinFrameSize int
+	MaxFrameSize int
+	SampleRate   int
+	Channels     int
+	BitDepth     int
+	// TotalSamples is the number of inter-channel samples, 0 when unknown.
+	TotalSamples uint64
+	MD5          [16]byte
+}
+
+// ParseFLACStreamInfo decodes the payload of a STREAMINFO block.
+func ParseFLACStreamInfo(data []byte) (FLACStreamInfo, error) {
+	if len(data) < flacStreamInfoSize {
+		return FLACStreamInfo{}, fmt.Errorf("%w: %d bytes", ErrInvalidStreamInfo, len(data))
+	}
+
+	//nolint:mnd // STREAMINFO bit layout: 20-bit rate, 3-bit channels, 5-bit depth, 36-bit sample count.
+	info := FLACStreamInfo{
+		MinBlockSize: int(binary.BigEndian.Uint16(data[0:])),
+		MaxBlockSize: int(binary.BigEndian.Uint16(data[2:])),
+		MinFrameSize: int(data[4])<<16 | int(data[5])<<8 | int(data[6]),
+		MaxFrameSize: int(data[7])<<16 | int(data[8])<<8 | int(data[9]),
+		SampleRate:   int(data[10])<<12 | int(data[11])<<4 | int(data[12])>>4,
+		Channels:     int(data[12]>>1&0x07) + 1,
+		BitDepth:     int(data[12]&0x01)<<4 | int(data[13]>>4) + 1,
+		TotalSamples: binary.BigEndian.Uint64(data[10:18]) & (1<<36 - 1),
+	}
+
+	copy(info.MD5[:], data[18:flacStreamInfoSize])
+
+	return info, nil
+}
+
+// Bytes encodes the payload of a STREAMINFO block, the inverse of ParseFLACStreamInfo.
+//
+//nolint:gosec,mnd // G115: fields are masked to their bit widths; STREAMINFO bit layout.
+func (i FLACStreamInfo) Bytes() []byte {
+	out := binary.BigEndian.AppendUint16(nil, uint16(i.MinBlockSize))
+	out = binary.BigEndian.AppendUint16(out, uint16(i.MaxBlockSize))
+	out = append(out, byte(i.MinFrameSize>>16), byte(i.MinFrameSize>>8), byte(i.MinFrameSize))
+	out = append(out, byte(i.MaxFrameSize>>16), byte(i.MaxFrameSize>>8), byte(i.MaxFrameSize))
+
+	packed := uint64(i.SampleRate&0xfffff)<<44 | uint64((i.Channels-1)&0x07)<<41 |
+		uint64((i.BitDepth-1)&0x1f)<<36 | i.TotalSamples&(1<<36-1)
+	out = binary.BigEndian.AppendUint64(out, packed)
+
+	return append(out, i.MD5[:]...)
+}
+
+// FLACFrame is the header and extent of a single FLAC frame.
+type FLACFrame struct {
+	// Offset is the file offset of the frame sync code.
+	Offset int
+	// Size is the frame size, header and CRC-16 footer included.
+	Size int
+	// VariableBlockSize is set for variable blocking strategy streams.
+	VariableBlockSize bool
+	// Number is the frame number for fixed block size streams, or the first sample number otherwise.
+	Number uint64
+	// BlockSize is the number of inter-channel samples in the frame.
+	BlockSize  int
+	SampleRate int
+	// ChannelAssignment is 0-7 for 1-8 independent channels, or a FLACChannel stereo decorrelation mode.
+	ChannelAssignment uint8
+	Channels          int
+	BitDepth          int
+	// HeaderCRC is the CRC-8 of the frame header, which WalkFLACFrames always verifies.
+	HeaderCRC uint8
+	// CRC is the stored CRC-16 of the frame; CRCValid reports whether it matches.
+	CRC      uint16
+	CRCValid bool
+}
+
+// String describes the frame on one line.
+func (f FLACFrame) String() string {
+	strategy := "fixed"
+	if f.VariableBlockSize {
+		strategy = "variable"
+	}
+
+	crc := "ok"
+	if !f.CRCValid {
+		crc = "bad"
+	}
+
+	return fmt.Sprintf("frame @%d size %d %s #%d blocksize %d rate %d channels %d (assignment %d) depth %d crc %04x %s",
+		f.Offset, f.Size, strategy, f.Number, f.BlockSize, f.SampleRate, f.Channels, f.ChannelAssignment,
+		f.BitDepth, f.CRC, crc)
+}
+
+// WalkFLACFrames locates every frame of a FLAC stream, after any leading ID3v2 tag and before any trailing tag.
+// Frame boundaries are found by searching for the next header with a valid CRC-8 at which the running
+// CRC-16 matches; a frame whose CRC-16 never matches ends at the next valid header and has CRCValid unset.
+func WalkFLACFrames(data []byte) ([]FLACFrame, error) {
+	start := leadingID3v2Size(data)
+
+	file, err := ReadFLAC(data[start:])
+	if err != nil {
+		return nil, err
+	}
+
+	block, ok := file.Block(FLACBlockStreamInfo)
+	if !ok {
+		return nil, fmt.Errorf("%w: missing", ErrInvalidStreamInfo)
+	}
+
+	info, err := ParseFLACStreamInfo(block.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	base := len(data) - len(file.Audio)
+	end, _ := splitTrailingTags(data, len(data))
+	audio := data[base:max(end, base)]
+
+	var frames []FLACFrame
+
+	for pos := 0; pos < len(audio); {
+		frame, headerSize, ok := parseFLACFrameHeader(audio[pos:], info)
+		if !ok {
+			return frames, fmt.Errorf("%w: no frame header at offset %d", ErrInvalidFLACFrame, base+pos)
+		}
+
+		frameEnd, crcValid := flacFrameEnd(audio, pos, headerSize, info)
+		if frameEnd-pos < headerSize+flacFrameFooterSize {
+			return frames, fmt.Errorf("%w: truncated frame at offset %d", ErrInvalidFLACFrame, base+pos)
+		}
+
+		frame.Offset = base + pos
+		frame.Size = frameEnd - pos
+		frame.CRC = binary.BigEndian.Uint16(audio[frameEnd-flacFrameFooterSize:])
+		frame.CRCValid = crcValid
+		frames = append(frames, frame)
+		pos = frameEnd
+	}
+
+	return frames, nil
+}
+
+// flacFrameEnd returns the end of the frame starting at pos, whose header is headerSize bytes.
+func flacFrameEnd(audio []byte, pos, headerSize int, info FLACStreamInfo) (int, bool) {
+	crc := flacCRC16(0, audio[pos:pos+headerSize])
+	fallback := len(audio)
+
+	// At each candidate end, crc covers the frame up to, excluding, the two footer bytes.
+	for end := pos + headerSize + flacFrameFooterSize; end <= len(audio); end++ {
+		atHeader := false
+		if end < len(audio) {
+			_, _, atHeader = parseFLACFrameHeader(audio[end:], info)
+		}
+
+		if end == len(audio) || atHeader {
+			if crc == binary.BigEndian.Uint16(audio[end-flacFrameFooterSize:]) {
+				return end, true
+			}
+
+			fallback = min(fallback, end)
+		}
+
+		crc = flacCRC16(crc, audio[end-flacFrameFooterSize:end-flacFrameFooterSize+1])
+	}
+
+	return fallback, false
+}
+
+// parseFLACFrameHeader decodes a frame header at the start of data, taking fields coded as "from STREAMINFO"
+// from info. ok is false unless data starts with a sync code, a header without reserved values and a valid CRC-8.
+func parseFLACFrameHeader(data []byte, info FLACStreamInfo) (FLACFrame, int, bool) {
+	if len(data) < flacFrameHeaderMinSize || data[0] != flacFrameSync ||
+		data[1]&^flacVariableBlockSize != flacFrameSyncLow {
+		return FLACFrame{}, 0, false
+	}
+
+	blockCode := data[2] >> 4
+	rateCode := data[2] & 0x0f
+	channelCode := data[3] >> 4
+	depthCode := data[3] >> 1 & flacMaxBitDepthCode
+
+	if blockCode == 0 || rateCode == 0x0f || channelCode >= flacChannelsReserved ||
+		flacBitDepths[depthCode] < 0 || data[3]&0x01 != 0 {
+		return FLACFrame{}, 0, false
+	}
+
+	number, pos, ok := decodeFLACNumber(data, 4)
+	if !ok {
+		return FLACFrame{}, 0, false
+	}
+
+	frame := FLACFrame{
+		VariableBlockSize: data[1]&flacVariableBlockSize != 0,
+		Number:            number,
+		ChannelAssignment: channelCode,
+		Channels:          int(channelCode) + 1,
+		BitDepth:          flacBitDepths[depthCode],
+	}
+
+	if channelCode >= flacChannelsIndependent {
+		frame.Channels = 2
+	}
+
+	if frame.BitDepth == 0 {
+		frame.BitDepth = info.BitDepth
+	}
+
+	frame.BlockSize, pos, ok = decodeFLACBlockSize(data, pos, blockCode)
+	if !ok {
+		return FLACFrame{}, 0, false
+	}
+
+	frame.SampleRate, pos, ok = decodeFLACSampleRate(data, pos, rateCode, info)
+	if !ok || pos >= len(data) || flacCRC8(data[:pos]) != data[pos] {
+		return FLACFrame{}, 0, false
+	}
+
+	frame.HeaderCRC = data[pos]
+
+	return frame, pos + 1, true
+}
+
+// decodeFLACNumber decodes the UTF-8-like coded frame or sample number at data[pos:].
+func decodeFLACNumber(data []byte, pos int) (uint64, int, bool) {
+	first := data[pos]
+	length := bits.LeadingZeros8(^first)
+
+	switch {
+	case length == 0:
+		return uint64(first), pos + 1, true
+	case length == 1 || length > flacUTF8MaxBytes || pos+length > len(data):
+		// A single leading one bit is a continuation byte, not a valid start.
+		return 0, 0, false
+	}
+
+	value := uint64(first & (0x7f >> length))
+
+	for _, b := range data[pos+1 : pos+length] {
+		if b&0xc0 != 0x80 {
+			return 0, 0, false
+		}
+
+		value = value<<6 | uint64(b&0x3f)
+	}
+
+	return value, pos + length, true
+}
+
+// decodeFLACBlockSize decodes a block size code (1-15), reading its 8 or 16-bit extension at data[pos:].
+func decodeFLACBlockSize(data []byte, pos int, code byte) (int, int, bool) {
+	switch {
+	case code == 1:
+		return flacShortBlockSize, pos, true
+	case code < flacBlockSize8Bit:
+		return flacBlockSize576 << (code - flacBlockSize576Code), pos, true
+	case code == flacBlockSize8Bit:
+		if pos >= len(data) {
+			return 0, 0, false
+		}
+
+		return int(data[pos]) + 1, pos + 1, true
+	case code == flacBlockSize16Bit:
+		if pos+1 >= len(data) {
+			return 0, 0, false
+		}
+
+		return int(binary.BigEndian.Uint16(data[pos:])) + 1, pos + 2, true
+	default:
+		return flacBlockSize256 << (code - flacBlockSize256Code), pos, true
+	}
+}
+
+// decodeFLACSampleRate decodes a sample rate code, reading its 8 or 16-bit extension at data[pos:].
+func decodeFLACSampleRate(data []byte, pos int, code byte, info FLACStreamInfo) (int, int, bool) {
+	switch {
+	case code == flacSampleRateFromInfo:
+		return info.SampleRate, pos, true
+	case int(code) < len(flacFixedSampleRates):
+		return flacFixedSampleRates[code], pos, true
+	case code == flacSampleRate8BitKHz && pos < len(data):
+		return int(data[pos]) * 1000, pos + 1, true //nolint:mnd // kHz.
+	case code == flacSampleRate16BitHz && pos+1 < len(data):
+		return int(binary.BigEndian.Uint16(data[pos:])), pos + 2, true
+	case code == flacSampleRate16Bit10Hz && pos+1 < len(data):
+		return int(binary.BigEndian.Uint16(data[pos:])) * 10, pos + 2, true //nolint:mnd // tens of Hz.
+	default:
+		return 0, 0, false
+	}
+}
+
+// flacCRC8 computes the frame header CRC-8 (polynomial 0x07, zero init).
+func flacCRC8(data []byte) uint8 {
+	var crc uint8
+
+	for _, b := range data {
+		crc ^= b
+		for range 8 {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ flacCRC8Polynomial
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
+
+// flacCRC16 continues the frame CRC-16 (polynomial 0x8005, zero init) over data.
+func flacCRC16(crc uint16, data []byte) uint16 {
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ flacCRC16Polynomial
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
@@ -0,0 +1,357 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+)
+
+// FLAC metadata block types.
+const (
+	FLACBlockStreamInfo    = 0
+	FLACBlockPadding       = 1
+	FLACBlockApplication   = 2
+	FLACBlockSeekTable     = 3
+	FLACBlockVorbisComment = 4
+	FLACBlockCueSheet      = 5
+	FLACBlockPicture       = 6
+)
+
+// FLAC metadata layout constants.
+const (
+	flacMarker             = "fLaC"
+	flacBlockHeaderSize    = 4
+	flacBlockLastFlag      = 0x80
+	flacBlockTypeMask      = 0x7f
+	flacMaxBlockLength     = 1<<24 - 1
+	flacSeekPointSize      = 18
+	flacFrameSync          = 0xff
+	flacFrameSyncMask      = 0xfc
+	flacFrameSyncLow       = 0xf8
+	flacPlaceholderSeek    = 0xffffffffffffffff
+	flacSeekPointSpacing   = 44100
+	flacSeekPointSamples   = 4096
+	flacPlaceholderCount   = 3
+	flacVendorOverflow     = 100
+	flacReservedBlockLen   = 16
+	flacUnknownAppID       = "AGAR"
+	flacUnknownAppDataSize = 64
+	flacVendorString       = "agar"
+)
+
+// Sentinel errors for FLAC metadata reading and rebuilding.
+var (
+	// ErrNotFLAC is returned when data does not start with the fLaC marker.
+	ErrNotFLAC = errors.New("not a FLAC stream")
+	// ErrUnknownFLACMalformation is returned by BuildFLACMalformed for a value outside the FLACMalformation
+	// constants.
+	ErrUnknownFLACMalformation = errors.New("unknown FLAC metadata edge case")
+)
+
+// FLACBlock is a single metadata block.
+type FLACBlock struct {
+	// Type is the block type (0-126); 7-126 are reserved.
+	Type uint8
+	// Data is the block payload.
+	Data []byte
+	// OverrideLength writes DeclaredLength in the block header instead of len(Data).
+	OverrideLength bool
+	// DeclaredLength is the length field written when OverrideLength is set.
+	DeclaredLength uint32
+}
+
+// FLACFile is a FLAC stream split into its metadata blocks and the audio frames that follow them.
+type FLACFile struct {
+	// Blocks are written in order; STREAMINFO must come first for a valid stream.
+	Blocks []FLACBlock
+	// Audio holds the raw frame data, written verbatim after the last block.
+	Audio []byte
+	// OmitLastFlag clears the last-metadata-block flag on the final block.
+	OmitLastFlag bool
+}
+
+// Bytes serializes the stream.
+func (f FLACFile) Bytes() []byte {
+	out := []byte(flacMarker)
+
+	for idx, block := range f.Blocks {
+		header := block.Type & flacBlockTypeMask
+		if idx == len(f.Blocks)-1 && !f.OmitLastFlag {
+			header |= flacBlockLastFlag
+		}
+
+		length := uint32(len(block.Data)) //nolint:gosec // G115: blocks are at most 16 MiB.
+		if block.OverrideLength {
+			length = block.DeclaredLength
+		}
+
+		out = append(out, header, byte(length>>16), byte(length>>8), byte(length)) //nolint:mnd // 24-bit big-endian.
+		out = append(out, block.Data...)
+	}
+
+	return append(out, f.Audio...)
+}
+
+// Block returns the first block of the given type.
+func (f FLACFile) Block(blockType uint8) (FLACBlock, bool) {
+	for _, block := range f.Blocks {
+		if block.Type == blockType {
+			return block, true
+		}
+	}
+
+	return FLACBlock{}, false
+}
+
+// ReadFLAC splits a FLAC stream into metadata blocks and audio frames.
+// Parsing stops at the last-metadata-block flag, or, when the flag is missing, at the first frame sync code.
+// A block whose length runs past the end of data is returned with the available bytes.
+func ReadFLAC(data []byte) (FLACFile, error) {
+	if len(data) < len(flacMarker) || string(data[:len(flacMarker)]) != flacMarker {
+		return FLACFile{}, ErrNotFLAC
+	}
+
+	file := FLACFile{OmitLastFlag: true}
+	offset := len(flacMarker)
+
+	for offset+flacBlockHeaderSize <= len(data) {
+		if data[offset] == flacFrameSync && data[offset+1]&flacFrameSyncMask == flacFrameSyncLow {
+			break
+		}
+
+		header := data[offset]
+		length := int(data[offset+1])<<16 | int(data[offset+2])<<8 | int(data[offset+3]) //nolint:mnd // 24-bit.
+		start := offset + flacBlockHeaderSize
+		end := min(start+length, len(data))
+
+		file.Blocks = append(file.Blocks, FLACBlock{Type: header & flacBlockTypeMask, Data: data[start:end]})
+		offset = end
+
+		if header&flacBlockLastFlag != 0 {
+			file.OmitLastFlag = false
+
+			break
+		}
+	}
+
+	file.Audio = data[offset:]
+
+	return file, nil
+}
+
+// FLACSeekPoint is a single SEEKTABLE entry.
+type FLACSeekPoint struct {
+	// SampleNumber is the first sample of the target frame, or 0xFFFFFFFFFFFFFFFF for a placeholder.
+	SampleNumber uint64
+	// Offset is the byte offset of the target frame from the first frame.
+	Offset uint64
+	// Samples is the number of samples in the target frame.
+	Samples uint16
+}
+
+// FLACSeekTableBlock returns a SEEKTABLE block with the points in the given order.
+func FLACSeekTableBlock(points []FLACSeekPoint) FLACBlock {
+	data := make([]byte, 0, len(points)*flacSeekPointSize)
+
+	for _, point := range points {
+		data = binary.BigEndian.AppendUint64(data, point.SampleNumber)
+		data = binary.BigEndian.AppendUint64(data, point.Offset)
+		data = binary.BigEndian.AppendUint16(data, point.Samples)
+	}
+
+	return FLACBlock{Type: FLACBlockSeekTable, Data: data}
+}
+
+// FLACApplicationBlock returns an APPLICATION block with the given four-byte identifier.
+func FLACApplicationBlock(id string, payload []byte) FLACBlock {
+	return FLACBlock{Type: FLACBlockApplication, Data: append([]byte(padFourCC(id)), payload...)}
+}
+
+// FLACPaddingBlock returns a zero-filled PADDING block of the given size.
+func FLACPaddingBlock(size int) FLACBlock {
+	return FLACBlock{Type: FLACBlockPadding, Data: make([]byte, size)}
+}
+
+// FLACPicture describes the payload of a PICTURE block.
+type FLACPicture struct {
+	// Type is the ID3v2 APIC picture type, e.g. 3 for the front cover.
+	Type        uint32
+	MIMEType    string
+	Description string
+	Width       uint32
+	Height      uint32
+	// Depth is the colour depth in bits per pixel.
+	Depth uint32
+	// Colors is the palette size for indexed images, 0 otherwise.
+	Colors uint32
+	Data   []byte
+}
+
+// FLACPictureBlock returns a PICTURE block holding picture.
+//
+//nolint:gosec // G115: test strings and images are small.
+func FLACPictureBlock(picture FLACPicture) FLACBlock {
+	data := binary.BigEndian.AppendUint32(nil, picture.Type)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(picture.MIMEType)))
+	data = append(data, picture.MIMEType...)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(picture.Description)))
+	data = append(data, picture.Description...)
+
+	for _, field := range []uint32{picture.Width, picture.Height, picture.Depth, picture.Colors} {
+		data = binary.BigEndian.AppendUint32(data, field)
+	}
+
+	data = binary.BigEndian.AppendUint32(data, uint32(len(picture.Data)))
+
+	return FLACBlock{Type: FLACBlockPicture, Data: append(data, picture.Data...)}
+}
+
+// FLACVorbisCommentBlock returns a VORBIS_COMMENT block holding "KEY=value" comments.
+func FLACVorbisCommentBlock(vendor string, comments []string) FLACBlock {
+	return FLACBlock{Type: FLACBlockVorbisComment, Data: vorbisCommentData(vendor, comments)}
+}
+
+// vorbisCommentData serializes a Vorbis comment header (without framing bit).
+//
+//nolint:gosec // G115: test strings are short.
+func vorbisCommentData(vendor string, comments []string) []byte {
+	out := binary.LittleEndian.AppendUint32(nil, uint32(len(vendor)))
+	out = append(out, vendor...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(comments)))
+
+	for _, comment := range comments {
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(comment)))
+		out = append(out, comment...)
+	}
+
+	return out
+}
+
+// FLACMalformation selects a metadata block edge case for BuildFLACMalformed. Its value names the fixture file.
+type FLACMalformation string
+
+// FLAC metadata block edge cases. Audio frames are left untouched in all of them.
+const (
+	// FLACMetadataWellFormed keeps STREAMINFO and writes a single VORBIS_COMMENT.
+	FLACMetadataWellFormed FLACMalformation = "well-formed"
+	// FLACSeekTablePlaceholders writes a SEEKTABLE with one real point followed by placeholder points.
+	FLACSeekTablePlaceholders FLACMalformation = "seektable-placeholders"
+	// FLACSeekTableUnsorted writes a SEEKTABLE whose sample numbers are out of order.
+	FLACSeekTableUnsorted FLACMalformation = "seektable-unsorted"
+	// FLACUnknownApplication writes an APPLICATION block with an unregistered identifier.
+	FLACUnknownApplication FLACMalformation = "unknown-application"
+	// FLACHugePadding writes a PADDING block of the maximum block length (16 MiB - 1).
+	FLACHugePadding FLACMalformation = "huge-padding"
+	// FLACReservedBlockTypes writes blocks of reserved types 7, 64 and 126.
+	FLACReservedBlockTypes FLACMalformation = "reserved-block-types"
+	// FLACMissingLastFlag clears the last-metadata-block flag, so the first frame follows an unterminated list.
+	FLACMissingLastFlag FLACMalformation = "missing-last-flag"
+	// FLACVorbisVendorOverflow writes a VORBIS_COMMENT whose vendor length points past the block.
+	FLACVorbisVendorOverflow FLACMalformation = "vorbis-vendor-overflow"
+	// FLACMultipleVorbisComments writes two VORBIS_COMMENT blocks with different titles.
+	FLACMultipleVorbisComments FLACMalformation = "multiple-vorbis-comments"
+)
+
+// BuildFLACMalformed rebuilds the metadata of source: STREAMINFO is kept, every other block is dropped,
+// and the blocks for the given edge case are added after a regular VORBIS_COMMENT. Audio is kept verbatim.
+func BuildFLACMalformed(source FLACFile, malformation FLACMalformation) (FLACFile, error) {
+	var blocks []FLACBlock
+
+	if streamInfo, ok := source.Block(FLACBlockStreamInfo); ok {
+		blocks = append(blocks, streamInfo)
+	}
+
+	comment := FLACVorbisCommentBlock(flacVendorString, []string{"TITLE=Test Title", "ARTIST=Test Artist"})
+	file := FLACFile{Audio: source.Audio}
+
+	switch malformation {
+	case FLACMetadataWellFormed:
+		blocks = append(blocks, comment)
+	case FLACSeekTablePlaceholders:
+		points := []FLACSeekPoint{{SampleNumber: 0, Offset: 0, Samples: flacSeekPointSamples}}
+		for range flacPlaceholderCount {
+			points = append(points, FLACSeekPoint{SampleNumber: flacPlaceholderSeek})
+		}
+
+		blocks = append(blocks, FLACSeekTableBlock(points), comment)
+	case FLACSeekTableUnsorted:
+		// Offsets are left at zero: the edge case is the ordering, not the targets.
+		blocks = append(blocks, FLACSeekTableBlock([]FLACSeekPoint{
+			{SampleNumber: 2 * flacSeekPointSpacing, Samples: flacSeekPointSamples},
+			{SampleNumber: 0, Samples: flacSeekPointSamples},
+			{SampleNumber: flacSeekPointSpacing, Samples: flacSeekPointSamples},
+		}), comment)
+	case FLACUnknownApplication:
+		payload := make([]byte, flacUnknownAppDataSize)
+		for idx := range payload {
+			payload[idx] = byte(idx)
+		}
+
+		blocks = append(blocks, comment, FLACApplicationBlock(flacUnknownAppID, payload))
+	case FLACHugePadding:
+		blocks = append(blocks, comment, FLACPaddingBlock(flacMaxBlockLength))
+	case FLACReservedBlockTypes:
+		blocks = append(blocks, comment)
+		for _, reserved := range []uint8{7, 64, 126} {
+			blocks = append(blocks, FLACBlock{Type: reserved, Data: make([]byte, flacReservedBlockLen)})
+		}
+	case FLACMissingLastFlag:
+		blocks = append(blocks, comment)
+		file.OmitLastFlag = true
+	case FLACVorbisVendorOverflow:
+		overflow := uint32(len(comment.Data) + flacVendorOverflow) //nolint:gosec // G115: small block.
+		binary.LittleEndian.PutUint32(comment.Data, overflow)
+		blocks = append(blocks, comment)
+	case FLACMultipleVorbisComments:
+		blocks = append(blocks, comment,
+			FLACVorbisCommentBlock(flacVendorString, []string{"TITLE=Second Title", "ALBUM=Second Album"}))
+	default:
+		return FLACFile{}, fmt.Errorf("%w: %q", ErrUnknownFLACMalformation, malformation)
+	}
+
+	file.Blocks = blocks
+
+	return file, nil
+}
+
+// FLACMetadataFixture returns path to a FLAC built from Genuine16bit44k with its metadata rebuilt
+// for the given edge case.
+func FLACMetadataFixture(data test.Data, helpers test.Helpers, malformation FLACMalformation) string {
+	helpers.T().Helper()
+
+	source, err := ReadFLAC(readFixtureFile(helpers.T(), Genuine16bit44k(data, helpers)))
+	if err != nil {
+		helpers.T().Log(err.Error())
+		helpers.T().FailNow()
+	}
+
+	file, err := BuildFLACMalformed(source, malformation)
+	if err != nil {
+		helpers.T().Log(err.Error())
+		helpers.T().FailNow()
+	}
+
+	path := filepath.Join(data.Temp().Dir(), "flac-"+string(malformation)+".flac")
+	writeFixtureFile(helpers.T(), path, file.Bytes())
+
+	return path
+}
@@ -0,0 +1,273 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+	"github.com/containerd/nerdctl/mod/tigron/tig"
+)
+
+// Filesystem condition permissions.
+const (
+	fsReadOnlyMode      = 0o444
+	fsUnwritableDirMode = 0o555
+	fsWritableDirMode   = 0o700
+)
+
+// FSCondition selects a filesystem situation to place a fixture in. Its value names the fixture directory.
+type FSCondition string
+
+// Filesystem conditions.
+const (
+	// FSReadOnly makes the file read-only (0444) in a writable directory.
+	FSReadOnly FSCondition = "read-only"
+	// FSUnwritableDir keeps the file writable but makes its directory read-only (0555), so the file can be
+	// edited in place but not replaced through a temporary file and rename.
+	FSUnwritableDir FSCondition = "unwritable-dir"
+	// FSSymlink points a symbolic link at the file.
+	FSSymlink FSCondition = "symlink"
+	// FSSymlinkChain points a symbolic link at another symbolic link to the file.
+	FSSymlinkChain FSCondition = "symlink-chain"
+	// FSDanglingSymlink is a symbolic link to a file that does not exist.
+	FSDanglingSymlink FSCondition = "dangling-symlink"
+	// FSSymlinkLoop is a symbolic link to a second link pointing back at the first.
+	FSSymlinkLoop FSCondition = "symlink-loop"
+	// FSHardlink gives the file a second hard link; Siblings holds the other name.
+	FSHardlink FSCondition = "hardlink"
+	// FSNoExtension names the file without any extension.
+	FSNoExtension FSCondition = "no-extension"
+	// FSWrongExtension names the file with the extension of another format (see MisleadingExtension).
+	FSWrongExtension FSCondition = "wrong-extension"
+	// FSCaseCollision places the file next to an empty file whose name differs only in case.
+	FSCaseCollision FSCondition = "case-collision"
+)
+
+// Permissions reports whether the condition relies on permission checks, which root bypasses.
+func (c FSCondition) Permissions() bool {
+	return c == FSReadOnly || c == FSUnwritableDir
+}
+
+// FSFixture is a fixture placed in a filesystem condition.
+type FSFixture struct {
+	// Path is the path to hand to the binary under test.
+	Path string
+	// Target is the regular file Path leads to, or empty for dangling and looping links.
+	Target string
+	// Siblings are the other paths created alongside: the second hard link, the links of a chain or loop,
+	// the case-colliding file.
+	Siblings []string
+}
+
+// FSConditionFixture copies the file at src into a directory named after the condition and the base name of src,
+// and arranges the given condition around it. Permissions changed by FSReadOnly and FSUnwritableDir are restored
+// when the test ends, so that the temporary directory can be removed. Conditions the platform cannot represent
+// (links failing with EPERM, ENOTSUP or ENOSYS, case collisions on a case-insensitive filesystem) skip the test;
+// other link errors fail it.
+func FSConditionFixture(data test.Data, helpers test.Helpers, src string, condition FSCondition) FSFixture {
+	helpers.T().Helper()
+
+	content := readFixtureFile(helpers.T(), src)
+	base := filepath.Base(src)
+	dir := data.Temp().Dir("fs-" + string(condition) + "-" + base)
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	target := filepath.Join(dir, base)
+
+	switch condition {
+	case FSReadOnly:
+		writeFixtureFile(helpers.T(), target, content)
+		fsChmod(helpers.T(), target, fsReadOnlyMode)
+		fsRestoreOnCleanup(helpers.T(), target, fixtureFileMode)
+
+		return FSFixture{Path: target, Target: target}
+	case FSUnwritableDir:
+		writeFixtureFile(helpers.T(), target, content)
+		fsChmod(helpers.T(), dir, fsUnwritableDirMode)
+		fsRestoreOnCleanup(helpers.T(), dir, fsWritableDirMode)
+
+		return FSFixture{Path: target, Target: target}
+	case FSSymlink:
+		writeFixtureFile(helpers.T(), target, content)
+		link := filepath.Join(dir, "link-"+base)
+		fsSymlink(helpers.T(), base, link)
+
+		return FSFixture{Path: link, Target: target}
+	case FSSymlinkChain:
+		writeFixtureFile(helpers.T(), target, content)
+		middle := filepath.Join(dir, "middle-"+base)
+		link := filepath.Join(dir, "link-"+base)
+		fsSymlink(helpers.T(), base, middle)
+		fsSymlink(helpers.T(), filepath.Base(middle), link)
+
+		return FSFixture{Path: link, Target: target, Siblings: []string{middle}}
+	case FSDanglingSymlink:
+		link := filepath.Join(dir, base)
+		fsSymlink(helpers.T(), "missing-"+base, link)
+
+		return FSFixture{Path: link}
+	case FSSymlinkLoop:
+		link := filepath.Join(dir, base)
+		other := filepath.Join(dir, "loop-"+base)
+		fsSymlink(helpers.T(), filepath.Base(other), link)
+		fsSymlink(helpers.T(), base, other)
+
+		return FSFixture{Path: link, Siblings: []string{other}}
+	case FSHardlink:
+		writeFixtureFile(helpers.T(), target, content)
+		other := filepath.Join(dir, "hardlink-"+base)
+
+		if err := os.Link(target, other); err != nil {
+			fsLinkFailed(helpers.T(), "hard", err)
+		}
+
+		return FSFixture{Path: target, Target: target, Siblings: []string{other}}
+	case FSNoExtension:
+		target = filepath.Join(dir, stem)
+		writeFixtureFile(helpers.T(), target, content)
+
+		return FSFixture{Path: target, Target: target}
+	case FSWrongExtension:
+		target = filepath.Join(dir, stem+MisleadingExtension(content))
+		writeFixtureFile(helpers.T(), target, content)
+
+		return FSFixture{Path: target, Target: target}
+	case FSCaseCollision:
+		upper, lower, ok := caseVariants(base)
+		if !ok {
+			helpers.T().Log("no cased letter to vary in " + base)
+			helpers.T().FailNow()
+		}
+
+		target = filepath.Join(dir, upper)
+		other := filepath.Join(dir, lower)
+		writeFixtureFile(helpers.T(), target, content)
+		writeFixtureFile(helpers.T(), other, nil)
+
+		if info, err := os.Stat(target); err != nil || info.Size() == 0 {
+			helpers.T().Skip("filesystem is case-insensitive")
+		}
+
+		return FSFixture{Path: target, Target: target, Siblings: []string{other}}
+	default:
+		helpers.T().Log("unknown filesystem condition " + string(condition))
+		helpers.T().FailNow()
+
+		return FSFixture{}
+	}
+}
+
+// MisleadingExtension returns the extension of a different format than the container of data: FLAC is named
+// .mp3, MP4 .flac, MPEG audio .m4a, Ogg .wav, RIFF .flac, AIFF .mp3 and anything else .mp3.
+func MisleadingExtension(data []byte) string {
+	switch sniffContainer(data) {
+	case PayloadFormatFLAC, ContainerAIFF:
+		return ".mp3"
+	case PayloadFormatMP4, ContainerRIFF:
+		return ".flac"
+	case PayloadFormatMPEG:
+		return ".m4a"
+	case PayloadFormatOgg:
+		return ".wav"
+	default:
+		return ".mp3"
+	}
+}
+
+// PermissionsEnforced is a requirement that skips permission-based conditions when they cannot be observed:
+// as root, which bypasses file modes, and on Windows, which ignores them.
+func PermissionsEnforced() *test.Requirement {
+	return &test.Requirement{
+		Check: func(_ test.Data, _ test.Helpers) (bool, string) {
+			if runtime.GOOS == "windows" {
+				return false, "file modes are not enforced on windows"
+			}
+
+			if os.Geteuid() == 0 {
+				return false, "running as root bypasses file modes"
+			}
+
+			return true, "file modes are enforced"
+		},
+	}
+}
+
+// caseVariants returns name with its first cased letter in upper and in lower case. It reports false when
+// name has no letter whose two cases differ.
+func caseVariants(name string) (string, string, bool) {
+	for idx, char := range name {
+		upper, lower := unicode.ToUpper(char), unicode.ToLower(char)
+		if upper == lower {
+			continue
+		}
+
+		rest := name[idx+utf8.RuneLen(char):]
+
+		return name[:idx] + string(upper) + rest, name[:idx] + string(lower) + rest, true
+	}
+
+	return "", "", false
+}
+
+// fsChmod changes the mode of path or fails the test.
+func fsChmod(helper tig.T, path string, mode os.FileMode) {
+	helper.Helper()
+
+	if err := os.Chmod(path, mode); err != nil {
+		helper.Log("chmod " + path + ": " + err.Error())
+		helper.FailNow()
+	}
+}
+
+// fsSymlink creates link pointing at target, skipping the test when symbolic links are unavailable.
+func fsSymlink(helper tig.T, target, link string) {
+	helper.Helper()
+
+	if err := os.Symlink(target, link); err != nil {
+		fsLinkFailed(helper, "symbolic", err)
+	}
+}
+
+// fsLinkFailed skips the test when err says the platform or filesystem does not support the kind of link,
+// or does not allow it to this user, and fails it on any other error.
+func fsLinkFailed(helper tig.T, kind string, err error) {
+	helper.Helper()
+
+	if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.ENOTSUP) || errors.Is(err, syscall.ENOSYS) {
+		helper.Skip(kind + " links unavailable: " + err.Error())
+	}
+
+	helper.Log(kind + " link: " + err.Error())
+	helper.FailNow()
+}
+
+// fsRestoreOnCleanup resets the mode of path when the test ends, if the test implementation supports cleanups.
+func fsRestoreOnCleanup(helper tig.T, path string, mode os.FileMode) {
+	if cleaner, ok := helper.(interface{ Cleanup(fn func()) }); ok {
+		cleaner.Cleanup(func() {
+			_ = os.Chmod(path, mode)
+		})
+	}
+}
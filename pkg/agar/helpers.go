@@ -18,11 +18,16 @@ package agar
 
 import (
 	"context"
+	"os"
 	"os/exec"
 
 	"github.com/containerd/nerdctl/mod/tigron/test"
+	"github.com/containerd/nerdctl/mod/tigron/tig"
 )
 
+// fixtureFileMode is the permission mode for natively generated test files.
+const fixtureFileMode = 0o600
+
 // generate runs an ffmpeg command to create an audio file in the test's temp directory.
 func generate(helpers test.Helpers, outputPath string, args []string) string {
 	helpers.T().Helper()
@@ -76,3 +81,26 @@ func generateWithPipe(helpers test.Helpers, outputPath string, firstArgs, second
 
 	return outputPath
 }
+
+// writeFixtureFile writes natively generated fixture bytes to path, failing the test on error.
+func writeFixtureFile(helper tig.T, path string, data []byte) {
+	helper.Helper()
+
+	if err := os.WriteFile(path, data, fixtureFileMode); err != nil {
+		helper.Log("writing fixture file: " + err.Error())
+		helper.FailNow()
+	}
+}
+
+// readFixtureFile reads a generated fixture back, failing the test on error.
+func readFixtureFile(helper tig.T, path string) []byte {
+	helper.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		helper.Log("reading fixture file: " + err.Error())
+		helper.FailNow()
+	}
+
+	return data
+}
@@ -0,0 +1,196 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"strings"
+)
+
+// Effective bit depth and upsampling analysis parameters.
+const (
+	// bitUnusedThreshold is the share of non-zero samples below which a low bit counts as padding.
+	bitUnusedThreshold = 0.01
+	// bitGenuineUsage is the share of samples with a genuine low bit set.
+	bitGenuineUsage = 0.5
+	// minConfidentSamples is the number of non-zero samples needed for full confidence.
+	minConfidentSamples = 4096
+	// upsamplingBandShare is the lowest cutoff, as a share of the original Nyquist, attributed to that rate.
+	upsamplingBandShare = 0.85
+)
+
+// upsamplingSourceRates are the sample rates an upsampled file is commonly made from, in ascending order.
+var upsamplingSourceRates = []int{ //nolint:gochecknoglobals // lookup table
+	22050, 24000, 32000, 44100, 48000, 88200, 96000, 176400, 192000,
+}
+
+// BitDepthAnalysis estimates how many bits of a PCM stream carry signal.
+type BitDepthAnalysis struct {
+	// ContainerBits is the declared bit depth of the PCM.
+	ContainerBits int
+	// EffectiveBits is ContainerBits minus the low bits that are (almost) never set.
+	EffectiveBits int
+	// BitUsage holds, per bit position (0 is the LSB), the share of non-zero samples with that bit set.
+	// Bits carrying signal sit near 0.5; padding bits sit at 0.
+	BitUsage []float64
+	// TrailingZeros is a histogram of the number of trailing zero bits of every non-zero sample.
+	TrailingZeros []int
+	// Samples is the number of non-zero samples analysed.
+	Samples int
+	// Confidence is between 0 and 1.
+	Confidence float64
+}
+
+// Padded reports whether the stream uses fewer bits than its container.
+func (a BitDepthAnalysis) Padded() bool {
+	return a.EffectiveBits < a.ContainerBits
+}
+
+// String summarises the analysis on one line.
+func (a BitDepthAnalysis) String() string {
+	usage := make([]string, min(len(a.BitUsage), a.ContainerBits-a.EffectiveBits+1))
+	for idx := range usage {
+		usage[idx] = fmt.Sprintf("%.3f", a.BitUsage[idx])
+	}
+
+	return fmt.Sprintf("effective %d of %d bits (confidence %.2f, %d samples, low bit usage %s)",
+		a.EffectiveBits, a.ContainerBits, a.Confidence, a.Samples, strings.Join(usage, " "))
+}
+
+// AnalyzeBitDepth estimates the effective bit depth of interleaved little-endian signed PCM from the usage of
+// each bit across all channels. Padding bits (a 16-bit master in a 24-bit container) are never set; genuine
+// low bits are set in about half of the samples. Confidence grows with the number of non-zero samples and
+// falls as the boundary bits move away from those two extremes.
+func AnalyzeBitDepth(pcm []byte, bitDepth int) BitDepthAnalysis {
+	if !pcmLayoutValid(bitDepth, 1) {
+		return BitDepthAnalysis{ContainerBits: bitDepth}
+	}
+
+	width := PCMBytesPerSample(bitDepth)
+	analysis := BitDepthAnalysis{
+		ContainerBits: bitDepth,
+		BitUsage:      make([]float64, bitDepth),
+		TrailingZeros: make([]int, bitDepth+1),
+	}
+
+	counts := make([]int, bitDepth)
+
+	for offset := 0; offset+width <= len(pcm); offset += width {
+		sample := uint64(pcmValue(pcm[offset:], width)) //nolint:gosec // G115: two's complement bits are wanted.
+		if sample == 0 {
+			continue
+		}
+
+		analysis.Samples++
+		analysis.TrailingZeros[min(bits.TrailingZeros64(sample), bitDepth)]++
+
+		for bit := range counts {
+			counts[bit] += int(sample >> bit & 1)
+		}
+	}
+
+	if analysis.Samples == 0 {
+		return analysis
+	}
+
+	for bit, count := range counts {
+		analysis.BitUsage[bit] = float64(count) / float64(analysis.Samples)
+	}
+
+	padding := 0
+	for padding < bitDepth && analysis.BitUsage[padding] < bitUnusedThreshold {
+		padding++
+	}
+
+	analysis.EffectiveBits = bitDepth - padding
+
+	// The lowest carrying bit should look random; padding bits should look empty.
+	confidence := 1.0
+	if padding < bitDepth {
+		confidence = 1 - math.Abs(analysis.BitUsage[padding]-bitGenuineUsage)/bitGenuineUsage
+	}
+
+	if padding > 0 {
+		confidence = min(confidence, 1-analysis.BitUsage[padding-1]/bitUnusedThreshold)
+	}
+
+	analysis.Confidence = confidence * min(float64(analysis.Samples)/minConfidentSamples, 1)
+
+	return analysis
+}
+
+// UpsamplingAnalysis estimates whether a stream was upsampled from the empty band above the original Nyquist.
+type UpsamplingAnalysis struct {
+	SampleRate int
+	// CutoffHz is the top of the occupied band; the Nyquist frequency when the band is full.
+	CutoffHz float64
+	// DropDB is the level just below CutoffHz over the noise floor above it; 0 when the band is full.
+	DropDB float64
+	// OriginalRate is the estimated sample rate before upsampling, 0 when the stream does not look upsampled.
+	OriginalRate int
+	// Confidence is between 0 and 1, for the verdict given by OriginalRate.
+	Confidence float64
+}
+
+// Upsampled reports whether an original sample rate was found.
+func (a UpsamplingAnalysis) Upsampled() bool {
+	return a.OriginalRate != 0
+}
+
+// String summarises the analysis on one line.
+func (a UpsamplingAnalysis) String() string {
+	verdict := "full band"
+	if a.Upsampled() {
+		verdict = fmt.Sprintf("upsampled from %d Hz", a.OriginalRate)
+	}
+
+	return fmt.Sprintf("%d Hz: %s (cutoff %.0f Hz, drop %.1f dB, confidence %.2f)",
+		a.SampleRate, verdict, a.CutoffHz, a.DropDB, a.Confidence)
+}
+
+// AnalyzeUpsampling looks for an empty band above the Nyquist frequency of a lower common sample rate in the
+// average spectrum of interleaved little-endian signed PCM. The original rate is the lowest common rate whose
+// Nyquist frequency sits just above the cutoff; confidence follows the depth of the drop. A full-band stream is
+// reported with the confidence that the band really is full.
+func AnalyzeUpsampling(pcm []byte, bitDepth, channels, sampleRate int) UpsamplingAnalysis {
+	if !pcmLayoutValid(bitDepth, channels) {
+		return UpsamplingAnalysis{SampleRate: sampleRate}
+	}
+
+	levels := smoothedDB(powerSpectrum(pcmMono(pcm, bitDepth, channels), spectrumSize), spectrumSmoothBins)
+	cutoff, drop := spectralCutoff(levels, sampleRate)
+
+	analysis := UpsamplingAnalysis{SampleRate: sampleRate, CutoffHz: cutoff, DropDB: drop}
+
+	for _, rate := range upsamplingSourceRates {
+		nyquist := float64(rate) / 2
+		if rate >= sampleRate || cutoff > nyquist || cutoff < nyquist*upsamplingBandShare {
+			continue
+		}
+
+		analysis.OriginalRate = rate
+		analysis.Confidence = dropConfidence(drop)
+
+		return analysis
+	}
+
+	analysis.Confidence = 1 - dropConfidence(drop)
+
+	return analysis
+}
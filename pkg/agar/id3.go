@@ -0,0 +1,679 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf16"
+)
+
+// ID3v2 layout constants.
+const (
+	id3v2HeaderSize    = 10
+	id3v22FrameHeader  = 6
+	id3v2FrameHeader   = 10
+	id3v22FrameIDSize  = 3
+	id3v2FrameIDSize   = 4
+	syncsafeBits       = 7
+	syncsafeMask       = 0x7f
+	id3EncodingUTF16   = 0x01
+	id3EncodingUTF8    = 0x03
+	id3MajorVersion22  = 2
+	id3MajorVersion23  = 3
+	id3MajorVersion24  = 4
+	utf16BOMLittleEnd0 = 0xff
+	utf16BOMLittleEnd1 = 0xfe
+	id3FlagFooter      = 0x10
+	id3FlagExtended    = 0x40
+	id3FlagUnsync      = 0x80
+	id3EncodingUTF16BE = 0x02
+	id3FooterMagic     = "3DI"
+	id3v1TagSize       = 128
+
+	// ID3v2.3 frame format flags.
+	id3v23FrameCompressed = 0x0080
+	id3v23FrameEncrypted  = 0x0040
+	id3v23FrameGrouping   = 0x0020
+
+	// ID3v2.4 frame format flags.
+	id3v24FrameGrouping   = 0x0040
+	id3v24FrameCompressed = 0x0008
+	id3v24FrameEncrypted  = 0x0004
+	id3v24FrameUnsync     = 0x0002
+	id3v24FrameDataLength = 0x0001
+	id3v24DataLengthSize  = 4
+	id3GroupingIDSize     = 1
+)
+
+// id3v24OnlyFrames lists frames introduced by ID3v2.4, written as TXXX in earlier versions.
+//
+//nolint:gochecknoglobals // lookup table
+var id3v24OnlyFrames = map[string]bool{
+	"TSST": true,
+	"TMOO": true,
+	"TDRL": true,
+	"TDRC": true,
+	"TDOR": true,
+}
+
+// Sentinel errors for ID3v2 decoding.
+var (
+	ErrNoID3v2Tag      = errors.New("no ID3v2 tag")
+	ErrInvalidID3v2Tag = errors.New("invalid ID3v2 tag")
+)
+
+// ID3Frame is a raw ID3v2 frame: a frame identifier and its undecoded payload.
+// IDs are three characters for ID3v2.2 and four characters for ID3v2.3/2.4.
+type ID3Frame struct {
+	ID   string
+	Data []byte
+}
+
+// ID3TextFrame builds a text information frame (T***) with the encoding
+// appropriate for the target version: UTF-8 for ID3v2.4, UTF-16 with BOM otherwise.
+func ID3TextFrame(version ID3Version, id, value string) ID3Frame {
+	return ID3Frame{ID: id, Data: id3EncodeText(version, value)}
+}
+
+// ID3UserTextFrame builds a user-defined text frame (TXXX, or TXX for ID3v2.2).
+func ID3UserTextFrame(version ID3Version, description, value string) ID3Frame {
+	id := "TXXX"
+	if version == ID3v22 {
+		id = "TXX"
+	}
+
+	return ID3Frame{ID: id, Data: id3EncodeTextList(version, []string{description, value})}
+}
+
+// ID3UniqueFileIDFrame builds a unique file identifier frame (UFID, or UFI for ID3v2.2):
+// a Latin-1 owner URL, a terminator, then the raw identifier.
+func ID3UniqueFileIDFrame(version ID3Version, owner, identifier string) ID3Frame {
+	id := "UFID"
+	if version == ID3v22 {
+		id = "UFI"
+	}
+
+	data := append([]byte(owner), 0)
+
+	return ID3Frame{ID: id, Data: append(data, identifier...)}
+}
+
+// ID3FramesForTags builds the frames storing values (keyed by semantic name) in the given version,
+// using the inverse of the mapping applied by ParseID3v2. Frames follow the sorted semantic names;
+// involved people and musician credits are gathered into TIPL and TMCL (IPLS before ID3v2.4).
+// For ID3v2.2 and 2.3, "date" is split into TYER, TDAT and TIME, and "originaldate" is reduced to TORY.
+func ID3FramesForTags(version ID3Version, values map[string]string) []ID3Frame {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	slices.Sort(keys)
+
+	var frames []ID3Frame
+
+	var involved, musicians []string
+
+	for _, key := range keys {
+		value := values[key]
+
+		if instrument, ok := strings.CutPrefix(key, "performer:"); ok {
+			musicians = append(musicians, instrument, value)
+
+			continue
+		}
+
+		if role, ok := semanticToID3InvolvedRole(key); ok {
+			involved = append(involved, role, value)
+
+			continue
+		}
+
+		frames = append(frames, id3FramesForTag(version, key, value)...)
+	}
+
+	if version == ID3v24 {
+		if len(involved) > 0 {
+			frames = append(frames, ID3Frame{ID: "TIPL", Data: id3EncodeTextList(version, involved)})
+		}
+
+		if len(musicians) > 0 {
+			frames = append(frames, ID3Frame{ID: "TMCL", Data: id3EncodeTextList(version, musicians)})
+		}
+	} else if people := slices.Concat(involved, musicians); len(people) > 0 {
+		ipls := ID3Frame{ID: "IPLS", Data: id3EncodeTextList(version, people)}
+		frames = append(frames, id3VersionFrame(version, ipls))
+	}
+
+	return frames
+}
+
+// id3FramesForTag builds the frames storing one semantic value.
+func id3FramesForTag(version ID3Version, key, value string) []ID3Frame {
+	if key == "musicbrainz_recordingid" {
+		return []ID3Frame{ID3UniqueFileIDFrame(version, musicBrainzOwner, value)}
+	}
+
+	if version != ID3v24 {
+		switch key {
+		case "date":
+			return id3SplitDateFrames(version, value)
+		case "originaldate":
+			year := value[:min(len(value), len("YYYY"))]
+
+			return []ID3Frame{id3VersionFrame(version, ID3TextFrame(version, "TORY", year))}
+		default:
+		}
+	}
+
+	id, ok := semanticToID3Frame[key]
+	if !ok || !id3FrameSupported(version, id) {
+		description, known := semanticToID3UserText[key]
+		if !known {
+			description = key
+		}
+
+		return []ID3Frame{ID3UserTextFrame(version, description, value)}
+	}
+
+	switch {
+	case id == "COMM" || id == "USLT":
+		return []ID3Frame{id3VersionFrame(version, id3LanguageFrame(version, id, value))}
+	case strings.HasPrefix(id, "W"):
+		return []ID3Frame{id3VersionFrame(version, ID3Frame{ID: id, Data: []byte(value)})}
+	default:
+		return []ID3Frame{id3VersionFrame(version, ID3TextFrame(version, id, value))}
+	}
+}
+
+// id3SplitDateFrames stores a date as ID3v2.3 TYER, TDAT (DDMM) and TIME (HHMM) frames,
+// as far as its precision allows. Unparseable dates are written to TYER verbatim.
+func id3SplitDateFrames(version ID3Version, value string) []ID3Frame {
+	date, err := ParseTagDate(value)
+	if err != nil {
+		return []ID3Frame{id3VersionFrame(version, ID3TextFrame(version, "TYER", value))}
+	}
+
+	frames := []ID3Frame{id3VersionFrame(version, ID3TextFrame(version, "TYER", fmt.Sprintf("%04d", date.Year)))}
+
+	if date.Precision >= DatePrecisionDay {
+		tdat := fmt.Sprintf("%02d%02d", date.Day, date.Month)
+		frames = append(frames, id3VersionFrame(version, ID3TextFrame(version, "TDAT", tdat)))
+	}
+
+	if date.Precision >= DatePrecisionMinute {
+		tim := fmt.Sprintf("%02d%02d", date.Hour, date.Minute)
+		frames = append(frames, id3VersionFrame(version, ID3TextFrame(version, "TIME", tim)))
+	}
+
+	return frames
+}
+
+// id3LanguageFrame builds a COMM or USLT frame with language "eng" and an empty description.
+func id3LanguageFrame(version ID3Version, id, value string) ID3Frame {
+	description := id3EncodeText(version, "")
+
+	data := append([]byte{description[0]}, "eng"...)
+	data = append(data, description[1:]...)
+	data = append(data, id3TextTerminator(version)...)
+	data = append(data, id3EncodeText(version, value)[1:]...)
+
+	return ID3Frame{ID: id, Data: data}
+}
+
+// id3FrameSupported reports whether an ID3v2.4 frame ID can be written in the given version.
+func id3FrameSupported(version ID3Version, id string) bool {
+	switch version {
+	case ID3v24:
+		return true
+	case ID3v23:
+		return !id3v24OnlyFrames[id]
+	default:
+		for _, v23 := range id3v22FrameToV23 {
+			if v23 == id {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// id3VersionFrame renames an ID3v2.3/2.4 frame to its ID3v2.2 equivalent when writing ID3v2.2.
+// Frames without an ID3v2.2 equivalent are kept as is.
+func id3VersionFrame(version ID3Version, frame ID3Frame) ID3Frame {
+	if version != ID3v22 {
+		return frame
+	}
+
+	for v22, v23 := range id3v22FrameToV23 {
+		if v23 == frame.ID {
+			frame.ID = v22
+
+			break
+		}
+	}
+
+	return frame
+}
+
+// semanticToID3InvolvedRole returns the TIPL/IPLS role for a semantic name.
+func semanticToID3InvolvedRole(semantic string) (string, bool) {
+	for role, name := range id3InvolvedRoleToSemantic {
+		if name == semantic {
+			return role, true
+		}
+	}
+
+	return "", false
+}
+
+// EncodeID3v2 serializes frames into a complete ID3v2 tag (header included).
+// Sizes are syncsafe in the tag header, and in frame headers for ID3v2.4 only.
+func EncodeID3v2(version ID3Version, frames []ID3Frame) []byte {
+	var body []byte
+
+	for _, frame := range frames {
+		body = append(body, id3EncodeFrame(version, frame)...)
+	}
+
+	header := make([]byte, id3v2HeaderSize)
+	copy(header, "ID3")
+	header[3] = id3MajorVersion(version)
+	copy(header[6:], syncsafe(uint32(len(body)))) //nolint:gosec // G115: tags are far below 256 MB.
+
+	return append(header, body...)
+}
+
+// id3EncodeFrame serializes a single frame with a version-appropriate header.
+func id3EncodeFrame(version ID3Version, frame ID3Frame) []byte {
+	size := uint32(len(frame.Data)) //nolint:gosec // G115: frames are far below 4 GB.
+
+	if version == ID3v22 {
+		out := make([]byte, id3v22FrameHeader, id3v22FrameHeader+len(frame.Data))
+		copy(out, padFrameID(frame.ID, id3v22FrameIDSize))
+		out[3] = byte(size >> (2 * bitsPerByte))
+		out[4] = byte(size >> bitsPerByte)
+		out[5] = byte(size)
+
+		return append(out, frame.Data...)
+	}
+
+	out := make([]byte, id3v2FrameHeader, id3v2FrameHeader+len(frame.Data))
+	copy(out, padFrameID(frame.ID, id3v2FrameIDSize))
+
+	if version == ID3v24 {
+		copy(out[4:], syncsafe(size))
+	} else {
+		binary.BigEndian.PutUint32(out[4:], size)
+	}
+
+	return append(out, frame.Data...)
+}
+
+// id3EncodeText encodes a text value prefixed by its encoding byte.
+func id3EncodeText(version ID3Version, value string) []byte {
+	if version == ID3v24 {
+		return append([]byte{id3EncodingUTF8}, value...)
+	}
+
+	out := []byte{id3EncodingUTF16, utf16BOMLittleEnd0, utf16BOMLittleEnd1}
+	for _, unit := range utf16.Encode([]rune(value)) {
+		out = binary.LittleEndian.AppendUint16(out, unit)
+	}
+
+	return out
+}
+
+// id3EncodeTextList encodes null-separated values sharing one encoding byte.
+// With UTF-16, every value carries its own byte order mark.
+func id3EncodeTextList(version ID3Version, values []string) []byte {
+	if len(values) == 0 {
+		return id3EncodeText(version, "")
+	}
+
+	out := id3EncodeText(version, values[0])
+	for _, value := range values[1:] {
+		out = append(out, id3TextTerminator(version)...)
+		out = append(out, id3EncodeText(version, value)[1:]...)
+	}
+
+	return out
+}
+
+// id3TextTerminator returns the string terminator for the encoding used by id3EncodeText.
+func id3TextTerminator(version ID3Version) []byte {
+	if version == ID3v24 {
+		return []byte{0}
+	}
+
+	return []byte{0, 0}
+}
+
+// id3MajorVersion returns the major version byte for the tag header.
+func id3MajorVersion(version ID3Version) byte {
+	switch version {
+	case ID3v22:
+		return id3MajorVersion22
+	case ID3v23:
+		return id3MajorVersion23
+	default:
+		return id3MajorVersion24
+	}
+}
+
+// syncsafe encodes a 28-bit value as four 7-bit bytes (ID3v2 syncsafe integer).
+func syncsafe(value uint32) []byte {
+	return []byte{
+		byte(value>>(3*syncsafeBits)) & syncsafeMask,
+		byte(value>>(2*syncsafeBits)) & syncsafeMask,
+		byte(value>>syncsafeBits) & syncsafeMask,
+		byte(value) & syncsafeMask,
+	}
+}
+
+// padFrameID truncates or space-pads a frame identifier to the given width.
+func padFrameID(id string, width int) []byte {
+	out := []byte("    ")[:width]
+	copy(out, id)
+
+	return out
+}
+
+// AppendID3v2Footer sets the footer flag of an ID3v2.4 tag and appends the matching "3DI" footer,
+// which lets readers locate a tag placed at the end of a file by scanning backwards.
+func AppendID3v2Footer(tag []byte) []byte {
+	if len(tag) < id3v2HeaderSize {
+		return tag
+	}
+
+	out := append([]byte(nil), tag...)
+	out[5] |= id3FlagFooter
+
+	footer := append([]byte("3DI"), out[3:id3v2HeaderSize]...)
+
+	return append(out, footer...)
+}
+
+// id3v2TagSize returns the total size of the ID3v2 tag at the start of data (header, body and
+// footer), or 0 when data does not start with a tag.
+func id3v2TagSize(data []byte) int {
+	if len(data) < id3v2HeaderSize || string(data[:3]) != "ID3" {
+		return 0
+	}
+
+	size := id3v2HeaderSize + int(unsyncsafe(data[6:id3v2HeaderSize]))
+	if data[5]&id3FlagFooter != 0 {
+		size += id3v2HeaderSize
+	}
+
+	return min(size, len(data))
+}
+
+// unsyncsafe decodes a four-byte ID3v2 syncsafe integer.
+func unsyncsafe(data []byte) uint32 {
+	var value uint32
+
+	for _, b := range data[:4] {
+		value = value<<syncsafeBits | uint32(b&syncsafeMask)
+	}
+
+	return value
+}
+
+// FindID3v2 locates an ID3v2 tag in a file: at the start, or at the end when it has a footer
+// (optionally followed by an ID3v1 tag). It returns the tag bytes, header included.
+func FindID3v2(data []byte) ([]byte, error) {
+	if size := id3v2TagSize(data); size > 0 {
+		return data[:size], nil
+	}
+
+	for _, end := range []int{len(data), len(data) - id3v1TagSize} {
+		if end < 2*id3v2HeaderSize || string(data[end-id3v2HeaderSize:end-id3v2HeaderSize+3]) != id3FooterMagic {
+			continue
+		}
+
+		size := 2*id3v2HeaderSize + int(unsyncsafe(data[end-4:end]))
+		if size <= end {
+			return data[end-size : end], nil
+		}
+	}
+
+	return nil, ErrNoID3v2Tag
+}
+
+// DecodeID3v2 decodes a complete ID3v2 tag into its version and frames.
+// Unsynchronisation is reversed, extended headers, grouping bytes and data length indicators are
+// skipped, and compressed or encrypted frames are dropped. Frame data is otherwise returned undecoded.
+func DecodeID3v2(tag []byte) (ID3Version, []ID3Frame, error) {
+	if len(tag) < id3v2HeaderSize || string(tag[:3]) != "ID3" {
+		return "", nil, ErrNoID3v2Tag
+	}
+
+	var version ID3Version
+
+	switch tag[3] {
+	case id3MajorVersion22:
+		version = ID3v22
+	case id3MajorVersion23:
+		version = ID3v23
+	case id3MajorVersion24:
+		version = ID3v24
+	default:
+		return "", nil, fmt.Errorf("%w: unsupported major version %d", ErrInvalidID3v2Tag, tag[3])
+	}
+
+	flags := tag[5]
+	body := tag[id3v2HeaderSize:min(len(tag), id3v2HeaderSize+int(unsyncsafe(tag[6:id3v2HeaderSize])))]
+
+	if flags&id3FlagUnsync != 0 && version != ID3v24 {
+		body = id3RemoveUnsync(body)
+	}
+
+	if flags&id3FlagExtended != 0 && version != ID3v22 {
+		skip, ok := id3ExtendedHeaderSize(version, body)
+		if !ok {
+			return "", nil, fmt.Errorf("%w: truncated extended header", ErrInvalidID3v2Tag)
+		}
+
+		body = body[skip:]
+	}
+
+	var frames []ID3Frame
+
+	for len(body) > 0 && body[0] != 0 {
+		frame, consumed, ok := id3DecodeFrame(version, body)
+		if !ok {
+			break
+		}
+
+		if frame.ID != "" {
+			frames = append(frames, frame)
+		}
+
+		body = body[consumed:]
+	}
+
+	return version, frames, nil
+}
+
+// id3ExtendedHeaderSize returns the number of bytes taken by the extended header at the start of body.
+func id3ExtendedHeaderSize(version ID3Version, body []byte) (int, bool) {
+	if len(body) < 4 {
+		return 0, false
+	}
+
+	// ID3v2.3 excludes the size field itself; ID3v2.4 includes it and makes it syncsafe.
+	size := int(binary.BigEndian.Uint32(body)) + 4
+	if version == ID3v24 {
+		size = int(unsyncsafe(body))
+	}
+
+	return size, size <= len(body)
+}
+
+// id3DecodeFrame decodes the frame at the start of body and returns the bytes it consumed.
+// Compressed and encrypted frames are returned with an empty ID; false means the header is invalid.
+func id3DecodeFrame(version ID3Version, body []byte) (ID3Frame, int, bool) {
+	headerSize, idSize := id3v2FrameHeader, id3v2FrameIDSize
+	if version == ID3v22 {
+		headerSize, idSize = id3v22FrameHeader, id3v22FrameIDSize
+	}
+
+	if len(body) < headerSize {
+		return ID3Frame{}, 0, false
+	}
+
+	var (
+		size  int
+		flags uint16
+	)
+
+	switch version {
+	case ID3v22:
+		size = int(body[3])<<(2*bitsPerByte) | int(body[4])<<bitsPerByte | int(body[5])
+	case ID3v23:
+		size = int(binary.BigEndian.Uint32(body[4:]))
+		flags = binary.BigEndian.Uint16(body[8:])
+	default:
+		size = int(unsyncsafe(body[4:]))
+		flags = binary.BigEndian.Uint16(body[8:])
+	}
+
+	if size > len(body)-headerSize {
+		return ID3Frame{}, 0, false
+	}
+
+	frame := ID3Frame{ID: string(body[:idSize]), Data: body[headerSize : headerSize+size]}
+	consumed := headerSize + size
+
+	switch version {
+	case ID3v23:
+		if flags&(id3v23FrameCompressed|id3v23FrameEncrypted) != 0 {
+			return ID3Frame{}, consumed, true
+		}
+
+		if flags&id3v23FrameGrouping != 0 && len(frame.Data) >= id3GroupingIDSize {
+			frame.Data = frame.Data[id3GroupingIDSize:]
+		}
+	case ID3v24:
+		if flags&(id3v24FrameCompressed|id3v24FrameEncrypted) != 0 {
+			return ID3Frame{}, consumed, true
+		}
+
+		if flags&id3v24FrameGrouping != 0 && len(frame.Data) >= id3GroupingIDSize {
+			frame.Data = frame.Data[id3GroupingIDSize:]
+		}
+
+		if flags&id3v24FrameDataLength != 0 && len(frame.Data) >= id3v24DataLengthSize {
+			frame.Data = frame.Data[id3v24DataLengthSize:]
+		}
+
+		if flags&id3v24FrameUnsync != 0 {
+			frame.Data = id3RemoveUnsync(frame.Data)
+		}
+	default:
+	}
+
+	return frame, consumed, true
+}
+
+// id3RemoveUnsync reverses ID3v2 unsynchronisation (0xFF 0x00 becomes 0xFF).
+func id3RemoveUnsync(data []byte) []byte {
+	return bytes.ReplaceAll(data, []byte{0xff, 0x00}, []byte{0xff})
+}
+
+// id3DecodeStrings decodes the null-separated strings of a text payload (encoding byte excluded).
+// Trailing empty strings left by terminators are dropped.
+func id3DecodeStrings(encoding byte, data []byte) []string {
+	var values []string
+
+	for len(data) > 0 {
+		value, rest := id3DecodeString(encoding, data)
+		values = append(values, value)
+		data = rest
+	}
+
+	for len(values) > 1 && values[len(values)-1] == "" {
+		values = values[:len(values)-1]
+	}
+
+	return values
+}
+
+// id3DecodeString decodes one terminated (or final) string and returns the remaining bytes.
+func id3DecodeString(encoding byte, data []byte) (string, []byte) {
+	if encoding != id3EncodingUTF16 && encoding != id3EncodingUTF16BE {
+		value, rest, found := bytes.Cut(data, []byte{0})
+		if !found {
+			rest = nil
+		}
+
+		if encoding == id3EncodingUTF8 {
+			return string(value), rest
+		}
+
+		return latin1ToString(value), rest
+	}
+
+	end := len(data) &^ 1
+
+	for idx := 0; idx+1 < len(data); idx += 2 {
+		if data[idx] == 0 && data[idx+1] == 0 {
+			end = idx
+
+			break
+		}
+	}
+
+	value := data[:end]
+	rest := data[min(end+2, len(data)):]
+
+	var order binary.ByteOrder = binary.BigEndian
+
+	if encoding == id3EncodingUTF16 && len(value) >= 2 {
+		switch {
+		case value[0] == utf16BOMLittleEnd0 && value[1] == utf16BOMLittleEnd1:
+			order = binary.LittleEndian
+			value = value[2:]
+		case value[0] == utf16BOMLittleEnd1 && value[1] == utf16BOMLittleEnd0:
+			value = value[2:]
+		}
+	}
+
+	units := make([]uint16, 0, len(value)/2)
+	for idx := 0; idx+1 < len(value); idx += 2 {
+		units = append(units, order.Uint16(value[idx:]))
+	}
+
+	return string(utf16.Decode(units)), rest
+}
+
+// latin1ToString converts ISO-8859-1 bytes to a string.
+func latin1ToString(data []byte) string {
+	runes := make([]rune, len(data))
+	for idx, b := range data {
+		runes[idx] = rune(b)
+	}
+
+	return string(runes)
+}
@@ -0,0 +1,146 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"path/filepath"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+)
+
+// dsfFixtureRate is the DSD64 sample rate used for the DSF fixture.
+const dsfFixtureRate = 2822400
+
+// ID3Placement selects where an ID3v2 tag is placed in a non-MP3 container.
+type ID3Placement string
+
+// ID3v2 placements outside of MP3. Every fixture carries the same ID3v2.4 tag
+// ("ID3 Title", "ID3 Artist", "ID3 Album"), which differs from the container's native tags when it has any.
+const (
+	// ID3PrependedFLAC places the tag before the fLaC marker; native tags are a VORBIS_COMMENT.
+	ID3PrependedFLAC ID3Placement = "flac-prepended"
+	// ID3AppendedFLAC places the tag, with an ID3v2.4 footer, after the last FLAC frame.
+	ID3AppendedFLAC ID3Placement = "flac-appended"
+	// ID3InWAVChunk places the tag in an "id3 " chunk; native tags are LIST/INFO.
+	ID3InWAVChunk ID3Placement = "wav-chunk"
+	// ID3InAIFFChunk places the tag in an "ID3 " chunk; native tags are NAME, AUTH, ANNO and (c).
+	ID3InAIFFChunk ID3Placement = "aiff-chunk"
+	// ID3InDSFMetadata places the tag at the DSF metadata pointer. DSF has no other tag format.
+	ID3InDSFMetadata ID3Placement = "dsf-metadata"
+	// ID3PrependedADTS places the tag before an ADTS AAC stream. ADTS has no other tag format.
+	ID3PrependedADTS ID3Placement = "adts-prepended"
+)
+
+// ID3PlacementFixture returns path to a file carrying an ID3v2 tag at the given placement.
+func ID3PlacementFixture(data test.Data, helpers test.Helpers, placement ID3Placement) string {
+	helpers.T().Helper()
+
+	switch placement {
+	case ID3PrependedFLAC:
+		return FLACWithPrependedID3(data, helpers)
+	case ID3AppendedFLAC:
+		return FLACWithAppendedID3(data, helpers)
+	case ID3InWAVChunk:
+		return WAVInfoID3Conflict(data, helpers)
+	case ID3InAIFFChunk:
+		return AIFFTextID3Conflict(data, helpers)
+	case ID3InDSFMetadata:
+		return DSFWithID3(data, helpers)
+	case ID3PrependedADTS:
+		return ADTSWithPrependedID3(data, helpers)
+	default:
+		helpers.T().Log("unknown ID3 placement " + string(placement))
+		helpers.T().FailNow()
+
+		return ""
+	}
+}
+
+// FLACWithPrependedID3 returns path to a FLAC (Genuine16bit44k audio, TITLE "Test Title",
+// ARTIST "Test Artist") preceded by a conflicting ID3v2.4 tag.
+func FLACWithPrependedID3(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	flac := id3FLACSource(data, helpers)
+	path := filepath.Join(data.Temp().Dir(), "id3-flac-prepended.flac")
+	writeFixtureFile(helpers.T(), path, append(conflictingID3Tag(), flac...))
+
+	return path
+}
+
+// FLACWithAppendedID3 returns path to a FLAC (Genuine16bit44k audio, TITLE "Test Title",
+// ARTIST "Test Artist") followed by a conflicting ID3v2.4 tag with footer.
+func FLACWithAppendedID3(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	flac := id3FLACSource(data, helpers)
+	path := filepath.Join(data.Temp().Dir(), "id3-flac-appended.flac")
+	writeFixtureFile(helpers.T(), path, append(flac, AppendID3v2Footer(conflictingID3Tag())...))
+
+	return path
+}
+
+// DSFWithID3 returns path to a stereo DSD64 DSF of a 1kHz sine, with a conflicting ID3v2.4 tag
+// at the metadata pointer.
+func DSFWithID3(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	const freqHz = 1000
+
+	channel := dsdSine(dsfFixtureRate, freqHz)
+	path := filepath.Join(data.Temp().Dir(), "id3-dsf-metadata.dsf")
+	writeFixtureFile(helpers.T(), path, EncodeDSF([][]byte{channel, channel}, dsfFixtureRate, conflictingID3Tag()))
+
+	return path
+}
+
+// ADTSWithPrependedID3 returns path to a 3 second 44.1kHz stereo ADTS AAC stream
+// preceded by a conflicting ID3v2.4 tag.
+func ADTSWithPrependedID3(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	adtsPath := generate(helpers, filepath.Join(data.Temp().Dir(), "id3-adts-source.aac"), []string{
+		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + shortDuration,
+		"-af", "pan=stereo|c0=c0|c1=c0,volume=-6dB",
+		"-ar", "44100", "-c:a", "aac", "-b:a", "128k", "-f", "adts",
+	})
+
+	path := filepath.Join(data.Temp().Dir(), "id3-adts-prepended.aac")
+	writeFixtureFile(helpers.T(), path, append(conflictingID3Tag(), readFixtureFile(helpers.T(), adtsPath)...))
+
+	return path
+}
+
+// id3FLACSource returns the bytes of Genuine16bit44k with its metadata reduced to STREAMINFO and
+// a single VORBIS_COMMENT.
+func id3FLACSource(data test.Data, helpers test.Helpers) []byte {
+	helpers.T().Helper()
+
+	source, err := ReadFLAC(readFixtureFile(helpers.T(), Genuine16bit44k(data, helpers)))
+	if err != nil {
+		helpers.T().Log(err.Error())
+		helpers.T().FailNow()
+	}
+
+	file, err := BuildFLACMalformed(source, FLACMetadataWellFormed)
+	if err != nil {
+		helpers.T().Log(err.Error())
+		helpers.T().FailNow()
+	}
+
+	return file.Bytes()
+}
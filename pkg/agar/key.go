@@ -0,0 +1,200 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"math"
+	"path/filepath"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+)
+
+// Key fixture synthesis parameters.
+const (
+	semitonesPerOctave = 12
+	midiA4             = 69
+	frequencyA4        = 440.0
+	chordDuration      = 2.0
+	chordRepeats       = 2
+	chordHarmonics     = 6
+	chordDecay         = 0.6
+	chordAttack        = 0.01
+	chordOctave        = 4
+	bassGain           = 0.8
+	triadSize          = 3
+	minorThird         = 3
+	diminishedFifth    = 6
+)
+
+// pitchClassNames are the note names of pitch classes 0 (C) to 11 (B), with sharps.
+var pitchClassNames = []string{ //nolint:gochecknoglobals // lookup table
+	"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#", "B",
+}
+
+// MusicalMode is a diatonic mode, named as it appears in KeyTruth.
+type MusicalMode string
+
+// Diatonic modes.
+const (
+	ModeMajor MusicalMode = "major"
+	// ModeMinor is natural minor (aeolian).
+	ModeMinor      MusicalMode = "minor"
+	ModeDorian     MusicalMode = "dorian"
+	ModeMixolydian MusicalMode = "mixolydian"
+)
+
+// intervals returns the semitone offsets of the seven scale degrees from the tonic.
+func (m MusicalMode) intervals() []int {
+	switch m {
+	case ModeMinor:
+		return []int{0, 2, 3, 5, 7, 8, 10}
+	case ModeDorian:
+		return []int{0, 2, 3, 5, 7, 9, 10}
+	case ModeMixolydian:
+		return []int{0, 2, 4, 5, 7, 9, 10}
+	default:
+		return []int{0, 2, 4, 5, 7, 9, 11}
+	}
+}
+
+// KeyFixture describes a synthesized chord progression.
+type KeyFixture struct {
+	// Name is used for the file name.
+	Name string
+	// Tonic is the pitch class of the key, 0 (C) to 11 (B).
+	Tonic int
+	Mode  MusicalMode
+	// Progression lists scale degrees, 1 to 7; each plays the diatonic triad built on it.
+	Progression []int
+}
+
+// KeyTruth is the ground truth of a key fixture, written next to it as JSON.
+type KeyTruth struct {
+	// Key is the tonic name and mode, e.g. "F# minor".
+	Key   string `json:"key"`
+	Tonic string `json:"tonic"`
+	Mode  string `json:"mode"`
+	// Chords are the chord symbols of one pass of the progression, e.g. "F#m", "D", "A", "E".
+	Chords []string `json:"chords"`
+	// ChordDurationSec is the length of each chord; the progression is played Repeats times.
+	ChordDurationSec float64 `json:"chordDurationSec"`
+	Repeats          int     `json:"repeats"`
+	DurationSec      float64 `json:"durationSec"`
+}
+
+// KeyFixtures returns chord progressions in major and minor keys, including enharmonic-heavy keys, and in
+// dorian and mixolydian. Render one with KeyFixtureFile.
+func KeyFixtures() []KeyFixture {
+	return []KeyFixture{
+		{Name: "c-major", Tonic: 0, Mode: ModeMajor, Progression: []int{1, 5, 6, 4}},
+		{Name: "a-minor", Tonic: 9, Mode: ModeMinor, Progression: []int{1, 6, 3, 7}},
+		{Name: "f-sharp-major", Tonic: 6, Mode: ModeMajor, Progression: []int{1, 4, 5, 1}},
+		{Name: "d-sharp-minor", Tonic: 3, Mode: ModeMinor, Progression: []int{1, 4, 5, 1}},
+		{Name: "d-dorian", Tonic: 2, Mode: ModeDorian, Progression: []int{1, 4, 1, 4}},
+		{Name: "g-mixolydian", Tonic: 7, Mode: ModeMixolydian, Progression: []int{1, 7, 4, 1}},
+	}
+}
+
+// Truth returns the key and chord symbols of the fixture.
+func (f KeyFixture) Truth() KeyTruth {
+	truth := KeyTruth{
+		Key:              pitchClassNames[f.Tonic] + " " + string(f.Mode),
+		Tonic:            pitchClassNames[f.Tonic],
+		Mode:             string(f.Mode),
+		ChordDurationSec: chordDuration,
+		Repeats:          chordRepeats,
+		DurationSec:      chordDuration * float64(len(f.Progression)*chordRepeats),
+	}
+
+	for _, degree := range f.Progression {
+		truth.Chords = append(truth.Chords, chordSymbol(f.triad(degree)))
+	}
+
+	return truth
+}
+
+// triad returns the MIDI notes of the diatonic triad on a scale degree, rooted in octave chordOctave.
+func (f KeyFixture) triad(degree int) []int {
+	intervals := f.Mode.intervals()
+	root := (chordOctave+1)*semitonesPerOctave + f.Tonic
+	notes := make([]int, triadSize)
+
+	for idx := range notes {
+		step := degree - 1 + 2*idx
+		notes[idx] = root + intervals[step%len(intervals)] + step/len(intervals)*semitonesPerOctave
+	}
+
+	return notes
+}
+
+// chordSymbol names a triad from its intervals: "A" major, "Am" minor, "Bdim" diminished.
+func chordSymbol(notes []int) string {
+	name := pitchClassNames[notes[0]%semitonesPerOctave]
+
+	switch {
+	case notes[1]-notes[0] == minorThird && notes[2]-notes[0] == diminishedFifth:
+		return name + "dim"
+	case notes[1]-notes[0] == minorThird:
+		return name + "m"
+	default:
+		return name
+	}
+}
+
+// KeyFixtureFile returns path to a 16-bit 44.1kHz mono WAV of the progression played twice, each chord a
+// triad of harmonic tones over its root an octave below. The ground truth is written beside it, with a .json
+// extension.
+func KeyFixtureFile(data test.Data, helpers test.Helpers, fixture KeyFixture) string {
+	helpers.T().Helper()
+
+	truth := fixture.Truth()
+	samples := make([]float64, int(math.Ceil(truth.DurationSec*musicSampleRate)))
+
+	for repeat := range chordRepeats {
+		for idx, degree := range fixture.Progression {
+			onset := chordDuration * float64(repeat*len(fixture.Progression)+idx)
+			notes := fixture.triad(degree)
+
+			for _, note := range notes {
+				mixHarmonicTone(samples, onset, midiFrequency(note), 1)
+			}
+
+			mixHarmonicTone(samples, onset, midiFrequency(notes[0]-semitonesPerOctave), bassGain)
+		}
+	}
+
+	return writeMusicFixture(helpers.T(), filepath.Join(data.Temp().Dir(), "key-"+fixture.Name+".wav"),
+		samples, truth)
+}
+
+// midiFrequency returns the equal-tempered frequency of a MIDI note.
+func midiFrequency(note int) float64 {
+	return frequencyA4 * math.Pow(2, float64(note-midiA4)/semitonesPerOctave)
+}
+
+// mixHarmonicTone adds a chordDuration tone with chordHarmonics harmonics at 1/n amplitude, a short linear
+// attack and an exponential decay.
+func mixHarmonicTone(samples []float64, onset, frequency, gain float64) {
+	mixTone(samples, onset, chordDuration, chordDecay, func(t float64) float64 {
+		value := 0.0
+		for harmonic := 1.0; harmonic <= chordHarmonics; harmonic++ {
+			value += math.Sin(2*math.Pi*frequency*harmonic*t) / harmonic
+		}
+
+		return gain * value * min(t/chordAttack, 1)
+	})
+}
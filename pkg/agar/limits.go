@@ -0,0 +1,433 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/containerd/nerdctl/mod/tigron/expect"
+	"github.com/containerd/nerdctl/mod/tigron/test"
+	"github.com/containerd/nerdctl/mod/tigron/tig"
+)
+
+// Config keys read by agarSetup.CustomCommand to limit the binary under test. Values are decimal
+// integers, except ConfigLimitTimeout and ConfigLimitCPU which are time.Duration strings.
+const (
+	// ConfigLimitTimeout is the wall-clock timeout.
+	ConfigLimitTimeout test.ConfigKey = "agar.limit.timeout"
+	// ConfigLimitMemory is RLIMIT_AS, in bytes.
+	ConfigLimitMemory test.ConfigKey = "agar.limit.memory"
+	// ConfigLimitCPU is RLIMIT_CPU, rounded up to whole seconds.
+	ConfigLimitCPU test.ConfigKey = "agar.limit.cpu"
+	// ConfigLimitFileSize is RLIMIT_FSIZE, in bytes.
+	ConfigLimitFileSize test.ConfigKey = "agar.limit.fsize"
+	// ConfigLimitOpenFiles is RLIMIT_NOFILE.
+	ConfigLimitOpenFiles test.ConfigKey = "agar.limit.nofile"
+)
+
+const (
+	limitConfigPrefix = "agar.limit."
+
+	prlimitBinary = "prlimit"
+	shellBinary   = "sh"
+
+	// limitStatusFile and limitStderrFile are written to the case temp directory by the limit wrapper.
+	limitStatusFile = "agar-limit.status"
+	limitStderrFile = "agar-limit.stderr"
+
+	// limitSignalBase is added to the signal number by the shell when a child is killed by a signal.
+	limitSignalBase = 128
+
+	// limitWrapperScript runs the limited command with its stderr captured to a file, replays that
+	// stderr, and records the exit status. $1 is the status file, $2 the stderr file.
+	limitWrapperScript = `status_file=$1; stderr_file=$2; shift 2
+"$@" 2>"$stderr_file"
+status=$?
+cat "$stderr_file" >&2
+echo "$status" >"$status_file"
+exit "$status"`
+)
+
+// ErrInvalidLimitReport is returned when the limit wrapper status file cannot be interpreted.
+var ErrInvalidLimitReport = errors.New("invalid limit report")
+
+// memoryFailureMarkers are lowercase stderr fragments printed by common runtimes when an allocation fails:
+// Go (including its start-up address space reservations), glibc strerror(ENOMEM), Rust, C++, Python and
+// ffmpeg-style messages.
+var memoryFailureMarkers = []string{ //nolint:gochecknoglobals // lookup table
+	"out of memory",
+	"failed to reserve",
+	"cannot allocate memory",
+	"memory allocation of",
+	"bad_alloc",
+	"memoryerror",
+	"failed to allocate",
+}
+
+// openFilesFailureMarker is strerror(EMFILE), lowercased.
+const openFilesFailureMarker = "too many open files"
+
+// LimitBreach identifies which resource limit a run of the binary under test exceeded, by the name
+// LimitReport prints.
+type LimitBreach string
+
+// Limit breaches.
+const (
+	// LimitNone means no limit was breached.
+	LimitNone LimitBreach = "none"
+	// LimitTimeout means the wall-clock timeout expired.
+	LimitTimeout LimitBreach = "timeout"
+	// LimitMemory means an allocation failed against RLIMIT_AS.
+	LimitMemory LimitBreach = "oom-limit"
+	// LimitCPU means RLIMIT_CPU was reached (SIGXCPU, or SIGKILL at the hard limit).
+	LimitCPU LimitBreach = "cpu-limit"
+	// LimitFileSize means a write went past RLIMIT_FSIZE (SIGXFSZ).
+	LimitFileSize LimitBreach = "file-size-limit"
+	// LimitOpenFiles means opening a file failed against RLIMIT_NOFILE (EMFILE).
+	LimitOpenFiles LimitBreach = "open-files-limit"
+)
+
+// ResourceLimits describes the limits applied to the binary under test. Zero fields are not limited.
+//
+// Use Config to attach them to a test.Case:
+//
+//	testCase.Config = agar.ResourceLimits{Memory: 1 << 30, CPU: 2 * time.Second}.Config()
+//
+// The rlimits are applied with prlimit(1) through a small sh wrapper that records the exit status
+// for ReadLimitReport; the wrapper captures stderr and replays it once the command exits, and
+// signals sent with TestableCommand.Signal reach the wrapper rather than the binary. Commands built
+// from helpers.Command in the same case are limited too. Cases with rlimits are skipped when
+// prlimit is not available.
+type ResourceLimits struct {
+	// Timeout is the wall-clock limit, enforced by tigron.
+	Timeout time.Duration
+	// Memory is the address-space limit in bytes. It counts virtual reservations, not resident memory:
+	// Go binaries reserve several hundred MiB at start-up and fail to start below roughly 768 MiB.
+	Memory int64
+	// CPU is the CPU time limit. The hard limit is one second above it, so SIGXCPU is delivered first.
+	CPU time.Duration
+	// FileSize is the largest file the binary may write, in bytes.
+	FileSize int64
+	// OpenFiles is the maximum number of open file descriptors.
+	OpenFiles int
+}
+
+// Config returns a test.Config carrying the limits, or nil when no limit is set.
+func (l ResourceLimits) Config() test.Config {
+	var cfg test.Config
+
+	for _, entry := range l.entries() {
+		if cfg == nil {
+			cfg = test.WithConfig(entry.key, test.ConfigValue(entry.value))
+		} else {
+			cfg.Write(entry.key, test.ConfigValue(entry.value))
+		}
+	}
+
+	return cfg
+}
+
+// String describes the limits on one line, e.g. "memory 104857600, cpu 2s".
+func (l ResourceLimits) String() string {
+	parts := []string{}
+
+	for _, entry := range l.entries() {
+		parts = append(parts, strings.TrimPrefix(string(entry.key), limitConfigPrefix)+" "+entry.value)
+	}
+
+	if len(parts) == 0 {
+		return "unlimited"
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// limitEntry is one configured limit in its config form.
+type limitEntry struct {
+	key   test.ConfigKey
+	value string
+}
+
+// entries returns the config form of the limits that are set.
+func (l ResourceLimits) entries() []limitEntry {
+	var entries []limitEntry
+
+	if l.Timeout > 0 {
+		entries = append(entries, limitEntry{ConfigLimitTimeout, l.Timeout.String()})
+	}
+
+	if l.Memory > 0 {
+		entries = append(entries, limitEntry{ConfigLimitMemory, strconv.FormatInt(l.Memory, 10)})
+	}
+
+	if l.CPU > 0 {
+		entries = append(entries, limitEntry{ConfigLimitCPU, l.CPU.String()})
+	}
+
+	if l.FileSize > 0 {
+		entries = append(entries, limitEntry{ConfigLimitFileSize, strconv.FormatInt(l.FileSize, 10)})
+	}
+
+	if l.OpenFiles > 0 {
+		entries = append(entries, limitEntry{ConfigLimitOpenFiles, strconv.Itoa(l.OpenFiles)})
+	}
+
+	return entries
+}
+
+// hasRlimits reports whether any limit needs prlimit.
+func (l ResourceLimits) hasRlimits() bool {
+	return l.Memory > 0 || l.CPU > 0 || l.FileSize > 0 || l.OpenFiles > 0
+}
+
+// prlimitArgs returns the prlimit options for the limits, ending with "--".
+func (l ResourceLimits) prlimitArgs() []string {
+	var args []string
+
+	if l.Memory > 0 {
+		args = append(args, fmt.Sprintf("--as=%d", l.Memory))
+	}
+
+	if l.CPU > 0 {
+		seconds := int64((l.CPU + time.Second - 1) / time.Second)
+		args = append(args, fmt.Sprintf("--cpu=%d:%d", seconds, seconds+1))
+	}
+
+	if l.FileSize > 0 {
+		args = append(args, fmt.Sprintf("--fsize=%d", l.FileSize))
+	}
+
+	if l.OpenFiles > 0 {
+		args = append(args, fmt.Sprintf("--nofile=%d", l.OpenFiles))
+	}
+
+	return append(args, "--")
+}
+
+// readLimits parses the limits from a config. Malformed values are ignored.
+func readLimits(cfg interface {
+	Read(key test.ConfigKey) test.ConfigValue
+},
+) ResourceLimits {
+	var limits ResourceLimits
+
+	if d, err := time.ParseDuration(string(cfg.Read(ConfigLimitTimeout))); err == nil {
+		limits.Timeout = d
+	}
+
+	if n, err := strconv.ParseInt(string(cfg.Read(ConfigLimitMemory)), 10, 64); err == nil {
+		limits.Memory = n
+	}
+
+	if d, err := time.ParseDuration(string(cfg.Read(ConfigLimitCPU))); err == nil {
+		limits.CPU = d
+	}
+
+	if n, err := strconv.ParseInt(string(cfg.Read(ConfigLimitFileSize)), 10, 64); err == nil {
+		limits.FileSize = n
+	}
+
+	if n, err := strconv.Atoi(string(cfg.Read(ConfigLimitOpenFiles))); err == nil {
+		limits.OpenFiles = n
+	}
+
+	return limits
+}
+
+// applyLimits configures the timeout and, when rlimits are set, the prlimit wrapper on cmd.
+func applyLimits(cmd test.CustomizableCommand, testCase *test.Case, helper tig.T) {
+	if testCase == nil || testCase.Config == nil {
+		return
+	}
+
+	limits := readLimits(testCase.Config)
+
+	if limits.Timeout > 0 {
+		cmd.WithTimeout(limits.Timeout)
+	}
+
+	if !limits.hasRlimits() {
+		return
+	}
+
+	prlimit, err := LookFor(prlimitBinary)
+	if err != nil {
+		helper.Skip("resource limits need " + prlimitBinary + ": " + err.Error())
+	}
+
+	shell, err := LookFor(shellBinary)
+	if err != nil {
+		helper.Skip("resource limits need " + shellBinary + ": " + err.Error())
+	}
+
+	args := []string{
+		"-c", limitWrapperScript, "agar-limit",
+		testCase.Data.Temp().Path(limitStatusFile),
+		testCase.Data.Temp().Path(limitStderrFile),
+		prlimit,
+	}
+
+	cmd.WithWrapper(shell, append(args, limits.prlimitArgs()...)...)
+}
+
+// LimitReport is the outcome of the last limited run of the binary under test in a case.
+type LimitReport struct {
+	// Limits are the limits the command ran under.
+	Limits ResourceLimits
+	// ExitCode is the exit status as seen by the shell: 128 plus the signal number when killed.
+	ExitCode int
+	// Signal is the signal that killed the command, or zero.
+	Signal syscall.Signal
+	// Stderr is the captured standard error.
+	Stderr string
+	// Breach is the limit the command exceeded, or LimitNone.
+	Breach LimitBreach
+}
+
+// String describes the report on one line.
+func (r LimitReport) String() string {
+	out := fmt.Sprintf("exit %d, breach %s (limits: %s)", r.ExitCode, r.Breach, r.Limits)
+	if r.Signal != 0 {
+		out += ", signal " + r.Signal.String()
+	}
+
+	return out
+}
+
+// ReadLimitReport reads the status recorded by the limit wrapper for the case and classifies it.
+// The case must have set rlimits through ResourceLimits.Config; a timeout alone leaves no report.
+func ReadLimitReport(data test.Data, helpers test.Helpers) (LimitReport, error) {
+	report := LimitReport{Limits: readLimits(helpers), Breach: LimitNone}
+
+	status, err := os.ReadFile(data.Temp().Path(limitStatusFile))
+	if err != nil {
+		return report, fmt.Errorf("%w: %w", ErrInvalidLimitReport, err)
+	}
+
+	report.ExitCode, err = strconv.Atoi(strings.TrimSpace(string(status)))
+	if err != nil {
+		return report, fmt.Errorf("%w: %w", ErrInvalidLimitReport, err)
+	}
+
+	if stderr, err := os.ReadFile(data.Temp().Path(limitStderrFile)); err == nil {
+		report.Stderr = string(stderr)
+	}
+
+	if report.ExitCode > limitSignalBase {
+		report.Signal = syscall.Signal(report.ExitCode - limitSignalBase)
+	}
+
+	report.Breach = classifyBreach(report)
+
+	return report, nil
+}
+
+// classifyBreach maps the exit signal and stderr to the limit that caused them. Allocation and EMFILE
+// failures are only attributed to a limit when that limit was set.
+func classifyBreach(report LimitReport) LimitBreach {
+	stderr := strings.ToLower(report.Stderr)
+
+	switch {
+	case report.Signal == sigXCPU:
+		return LimitCPU
+	case report.Signal == syscall.SIGKILL && report.Limits.CPU > 0:
+		return LimitCPU
+	case report.Signal == sigXFSZ:
+		return LimitFileSize
+	case report.ExitCode != 0 && report.Limits.Memory > 0 && containsAny(stderr, memoryFailureMarkers):
+		return LimitMemory
+	case report.ExitCode != 0 && report.Limits.OpenFiles > 0 && strings.Contains(stderr, openFilesFailureMarker):
+		return LimitOpenFiles
+	default:
+		return LimitNone
+	}
+}
+
+// containsAny reports whether s contains any of the needles.
+func containsAny(s string, needles []string) bool {
+	for _, needle := range needles {
+		if strings.Contains(s, needle) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ExpectWithinLimits expects the command to exit with exitCode and print errs on stderr, without
+// breaching any configured limit. exitCode may be one of the tigron expect codes, typically
+// expect.ExitCodeGenericFail for "must reject this file". A timeout fails the exit code check.
+func ExpectWithinLimits(exitCode int, errs ...error) test.Manager {
+	return func(data test.Data, helpers test.Helpers) *test.Expected {
+		return &test.Expected{
+			ExitCode: exitCode,
+			Errors:   errs,
+			Output: func(_ string, helper tig.T) {
+				helper.Helper()
+
+				if !readLimits(helpers).hasRlimits() {
+					return
+				}
+
+				report, err := ReadLimitReport(data, helpers)
+				if err != nil {
+					helper.Log(err.Error())
+					helper.FailNow()
+				}
+
+				if report.Breach != LimitNone {
+					helper.Log("command breached a resource limit: " + report.String())
+					helper.Fail()
+				}
+			},
+		}
+	}
+}
+
+// ExpectLimitBreach expects the command to exceed the given limit. LimitTimeout is checked by tigron's
+// timeout detection; the other kinds are read from the limit report.
+func ExpectLimitBreach(breach LimitBreach) test.Manager {
+	return func(data test.Data, helpers test.Helpers) *test.Expected {
+		if breach == LimitTimeout {
+			return &test.Expected{ExitCode: expect.ExitCodeTimeout}
+		}
+
+		return &test.Expected{
+			ExitCode: expect.ExitCodeNoCheck,
+			Output: func(_ string, helper tig.T) {
+				helper.Helper()
+
+				report, err := ReadLimitReport(data, helpers)
+				if err != nil {
+					helper.Log(err.Error())
+					helper.FailNow()
+				}
+
+				if report.Breach != breach {
+					helper.Log("expected breach " + string(breach) + ", got " + report.String())
+					helper.Fail()
+				}
+			},
+		}
+	}
+}
@@ -0,0 +1,65 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"context"
+	"io"
+	"testing"
+)
+
+// MetaflacOptions configures a metaflac invocation.
+type MetaflacOptions struct {
+	// Args are passed directly to the metaflac binary.
+	Args []string
+	// Stdin is connected to the command's standard input when non-nil.
+	Stdin io.Reader
+	// Stdout receives the command's standard output when non-nil.
+	// When nil, stdout is captured and returned in MetaflacResult.Stdout.
+	Stdout io.Writer
+	// Stderr receives the command's standard error when non-nil.
+	// When nil, stderr is captured and included in the ToolError on failure.
+	Stderr io.Writer
+}
+
+// MetaflacResult holds captured output from a metaflac invocation.
+type MetaflacResult struct {
+	// Stdout contains captured standard output, populated only when
+	// MetaflacOptions.Stdout was nil.
+	Stdout []byte
+}
+
+// Metaflac runs metaflac with the given options.
+// It fatals the test if metaflac cannot be found or the command returns an error.
+func Metaflac(t *testing.T, opts MetaflacOptions) MetaflacResult {
+	t.Helper()
+
+	result, err := RunMetaflac(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return result
+}
+
+// RunMetaflac runs metaflac with the given options until it exits or ctx is done.
+// Failures are returned as a *ToolError.
+func RunMetaflac(ctx context.Context, opts MetaflacOptions) (MetaflacResult, error) {
+	stdout, err := runTool(ctx, metaflacBinary, toolOptions(opts))
+
+	return MetaflacResult{Stdout: stdout}, err
+}
@@ -154,6 +154,15 @@ func MP3SetID3Tags(helpers test.Helpers, path string, version ID3Version, tags M
 	helpers.Custom(id3v2, args...).Run(&test.Expected{})
 }
 
+// MP3WriteID3v2Tag replaces the leading ID3v2 tag of an MP3 file (if any) with tag,
+// typically built with EncodeID3v2. Use it for frames the id3v2 tool cannot write.
+func MP3WriteID3v2Tag(helpers test.Helpers, path string, tag []byte) {
+	helpers.T().Helper()
+
+	data := readFixtureFile(helpers.T(), path)
+	writeFixtureFile(helpers.T(), path, append(append([]byte(nil), tag...), data[id3v2TagSize(data):]...))
+}
+
 // TaggedMP3 returns path to MP3 with ID3v2.4 tags (default).
 func TaggedMP3(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
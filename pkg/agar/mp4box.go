@@ -0,0 +1,384 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MP4 box layout constants.
+const (
+	mp4BoxHeaderSize      = 8
+	mp4LargeBoxHeaderSize = 16
+	mp4FullBoxHeaderSize  = 4
+	mp4StscEntrySize      = 12
+	mp4LargeSizeMarker    = 1
+	mp4ToEndSizeMarker    = 0
+	mp4SampleTablePath    = "mdia/minf/stbl"
+)
+
+// ErrInvalidMP4 is returned when an MP4 box tree or sample table is malformed.
+var ErrInvalidMP4 = errors.New("invalid MP4 structure")
+
+// mp4ContainerBoxes lists the boxes whose payload is a list of child boxes.
+var mp4ContainerBoxes = map[string]bool{ //nolint:gochecknoglobals // lookup table
+	"moov": true, "trak": true, "mdia": true, "minf": true, "stbl": true, "udta": true,
+	"edts": true, "dinf": true, "mvex": true, "moof": true, "traf": true, "meta": true,
+}
+
+// MP4Box is a box of an MP4 (ISO BMFF or QuickTime) file. Container boxes carry their parsed children.
+type MP4Box struct {
+	// Type is the four-character box type.
+	Type string
+	// Offset is the file offset of the box header.
+	Offset int
+	// HeaderSize is 8, or 16 for boxes with a 64-bit size.
+	HeaderSize int
+	// Data is the whole box, header included.
+	Data []byte
+	// Children are the boxes inside a container box, in file order.
+	Children []MP4Box
+}
+
+// Size returns the box size, header included.
+func (b MP4Box) Size() int {
+	return len(b.Data)
+}
+
+// Payload returns the box content after its header.
+func (b MP4Box) Payload() []byte {
+	return b.Data[b.HeaderSize:]
+}
+
+// String renders the box and its descendants as an indented tree, one box per line.
+func (b MP4Box) String() string {
+	var out strings.Builder
+
+	b.dump(&out, 0)
+
+	return strings.TrimSuffix(out.String(), "\n")
+}
+
+// WalkMP4Boxes parses the box tree of an MP4 file, recursing into moov, trak, mdia, minf, stbl, udta, meta,
+// edts, dinf, mvex, moof and traf. Every other box is a leaf.
+func WalkMP4Boxes(data []byte) ([]MP4Box, error) {
+	return parseMP4Boxes(data, 0)
+}
+
+// FindMP4Box returns the first box matching a slash-separated type path, e.g. "moov/udta/meta/ilst".
+func FindMP4Box(boxes []MP4Box, path string) (MP4Box, bool) {
+	return mp4Find(boxes, strings.Split(path, "/")...)
+}
+
+// dump writes the box at the given depth, then its children.
+func (b MP4Box) dump(out *strings.Builder, depth int) {
+	fmt.Fprintf(out, "%s%s @%d size %d\n", strings.Repeat("  ", depth), b.Type, b.Offset, b.Size())
+
+	for _, child := range b.Children {
+		child.dump(out, depth+1)
+	}
+}
+
+// parseMP4Boxes parses the boxes of data, which starts at file offset base, recursing into containers.
+func parseMP4Boxes(data []byte, base int) ([]MP4Box, error) {
+	var boxes []MP4Box
+
+	for offset := 0; offset < len(data); {
+		if offset+mp4BoxHeaderSize > len(data) {
+			return nil, fmt.Errorf("%w: truncated box header at offset %d", ErrInvalidMP4, base+offset)
+		}
+
+		size := uint64(binary.BigEndian.Uint32(data[offset:]))
+		boxType := string(data[offset+4 : offset+mp4BoxHeaderSize])
+		headerSize := mp4BoxHeaderSize
+
+		switch size {
+		case mp4LargeSizeMarker:
+			if offset+mp4LargeBoxHeaderSize > len(data) {
+				return nil, fmt.Errorf("%w: truncated %q header at offset %d", ErrInvalidMP4, boxType, base+offset)
+			}
+
+			size = binary.BigEndian.Uint64(data[offset+mp4BoxHeaderSize:])
+			headerSize = mp4LargeBoxHeaderSize
+		case mp4ToEndSizeMarker:
+			size = uint64(len(data) - offset)
+		}
+
+		if size < uint64(headerSize) || size > uint64(len(data)-offset) {
+			return nil, fmt.Errorf("%w: %q at offset %d has size %d", ErrInvalidMP4, boxType, base+offset, size)
+		}
+
+		end := offset + int(size) //nolint:gosec // G115: size is bounded by len(data).
+		box := MP4Box{Type: boxType, Offset: base + offset, HeaderSize: headerSize, Data: data[offset:end]}
+
+		if mp4ContainerBoxes[boxType] {
+			skip := mp4ChildrenOffset(box)
+
+			children, err := parseMP4Boxes(box.Payload()[skip:], box.Offset+headerSize+skip)
+			if err != nil {
+				return nil, err
+			}
+
+			box.Children = children
+		}
+
+		boxes = append(boxes, box)
+		offset = end
+	}
+
+	return boxes, nil
+}
+
+// encodeMP4Box serializes a box with a 32-bit size from its type and payload parts.
+//
+//nolint:gosec // G115: test boxes are far below 4 GiB.
+func encodeMP4Box(boxType string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	out := binary.BigEndian.AppendUint32(nil, uint32(mp4BoxHeaderSize+len(body)))
+	out = append(out, padFourCC(boxType)...)
+
+	return append(out, body...)
+}
+
+// encodeMP4FullBox serializes a full box: version and 24-bit flags precede the payload.
+func encodeMP4FullBox(boxType string, version byte, flags uint32, payload ...[]byte) []byte {
+	header := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)} //nolint:mnd // 24-bit flags.
+
+	return encodeMP4Box(boxType, append([][]byte{header}, payload...)...)
+}
+
+// mp4ChildrenOffset returns where the children start in a container payload: ISO meta is a full box,
+// while QuickTime meta starts with its hdlr child directly.
+func mp4ChildrenOffset(box MP4Box) int {
+	payload := box.Payload()
+	if box.Type != "meta" || len(payload) < mp4FullBoxHeaderSize+mp4BoxHeaderSize {
+		return 0
+	}
+
+	if string(payload[4:8]) == "hdlr" {
+		return 0
+	}
+
+	return mp4FullBoxHeaderSize
+}
+
+// mp4Find returns the first descendant of boxes matching the box type path.
+func mp4Find(boxes []MP4Box, path ...string) (MP4Box, bool) {
+	for _, box := range boxes {
+		if box.Type != path[0] {
+			continue
+		}
+
+		if len(path) == 1 {
+			return box, true
+		}
+
+		if found, ok := mp4Find(box.Children, path[1:]...); ok {
+			return found, true
+		}
+	}
+
+	return MP4Box{}, false
+}
+
+// mp4Leaves walks the tree depth-first and calls visit for every box without children, with its path.
+// Repeated siblings are named "#n" after the first ("trak", "trak#1").
+func mp4Leaves(boxes []MP4Box, prefix string, visit func(path string, box MP4Box)) {
+	seen := map[string]int{}
+
+	for _, box := range boxes {
+		path := prefix + box.Type
+		if count := seen[box.Type]; count > 0 {
+			path += "#" + strconv.Itoa(count)
+		}
+
+		seen[box.Type]++
+
+		if len(box.Children) == 0 {
+			visit(path, box)
+
+			continue
+		}
+
+		mp4Leaves(box.Children, path+"/", visit)
+	}
+}
+
+// mp4TrackSamples returns the sample data of every track of moov, in track order, located through the
+// stsz, stsc and stco (or co64) tables of each track.
+func mp4TrackSamples(file []byte, moov MP4Box) ([][]byte, error) {
+	var tracks [][]byte
+
+	for _, trak := range moov.Children {
+		if trak.Type != "trak" {
+			continue
+		}
+
+		stbl, ok := FindMP4Box(trak.Children, mp4SampleTablePath)
+		if !ok {
+			return nil, fmt.Errorf("%w: trak #%d has no %s", ErrInvalidMP4, len(tracks), mp4SampleTablePath)
+		}
+
+		samples, err := mp4SampleTableData(file, stbl)
+		if err != nil {
+			return nil, fmt.Errorf("trak #%d: %w", len(tracks), err)
+		}
+
+		tracks = append(tracks, samples)
+	}
+
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("%w: no tracks", ErrInvalidMP4)
+	}
+
+	return tracks, nil
+}
+
+// mp4SampleTableData concatenates the samples described by a stbl box.
+func mp4SampleTableData(file []byte, stbl MP4Box) ([]byte, error) {
+	sizes, err := mp4SampleSizes(stbl, len(file))
+	if err != nil {
+		return nil, err
+	}
+
+	offsets, err := mp4ChunkOffsets(stbl)
+	if err != nil {
+		return nil, err
+	}
+
+	stsc, ok := mp4Find(stbl.Children, "stsc")
+	if !ok || len(stsc.Payload()) < mp4FullBoxHeaderSize+4 {
+		return nil, fmt.Errorf("%w: missing stsc", ErrInvalidMP4)
+	}
+
+	count := int(binary.BigEndian.Uint32(stsc.Payload()[mp4FullBoxHeaderSize:]))
+	entries := stsc.Payload()[mp4FullBoxHeaderSize+4:]
+
+	if len(entries) < count*mp4StscEntrySize {
+		return nil, fmt.Errorf("%w: truncated stsc", ErrInvalidMP4)
+	}
+
+	entries = entries[:count*mp4StscEntrySize]
+
+	var out []byte
+
+	sample := 0
+	perChunk := 0
+
+	for chunk, offset := range offsets {
+		// Each stsc entry applies from its 1-based first chunk until the next entry.
+		for len(entries) >= mp4StscEntrySize && int(binary.BigEndian.Uint32(entries)) <= chunk+1 {
+			perChunk = int(binary.BigEndian.Uint32(entries[4:]))
+			entries = entries[mp4StscEntrySize:]
+		}
+
+		for range perChunk {
+			if sample >= len(sizes) {
+				break
+			}
+
+			end := offset + uint64(sizes[sample])
+			if offset > uint64(len(file)) || end < offset || end > uint64(len(file)) {
+				return nil, fmt.Errorf("%w: sample %d runs past the end of the file", ErrInvalidMP4, sample)
+			}
+
+			out = append(out, file[offset:end]...)
+			offset = end
+			sample++
+		}
+	}
+
+	if sample != len(sizes) {
+		return nil, fmt.Errorf("%w: chunks hold %d of %d samples", ErrInvalidMP4, sample, len(sizes))
+	}
+
+	return out, nil
+}
+
+// mp4SampleSizes decodes the stsz table, expanding a constant sample size. A constant size is only expanded
+// when the samples fit in fileSize bytes, so that a forged count cannot force a huge allocation.
+func mp4SampleSizes(stbl MP4Box, fileSize int) ([]uint32, error) {
+	stsz, ok := mp4Find(stbl.Children, "stsz")
+	if !ok || len(stsz.Payload()) < mp4FullBoxHeaderSize+8 {
+		return nil, fmt.Errorf("%w: missing stsz", ErrInvalidMP4)
+	}
+
+	constant := binary.BigEndian.Uint32(stsz.Payload()[mp4FullBoxHeaderSize:])
+	count := int(binary.BigEndian.Uint32(stsz.Payload()[mp4FullBoxHeaderSize+4:]))
+	table := stsz.Payload()[mp4FullBoxHeaderSize+8:]
+
+	if constant == 0 && len(table) < count*4 {
+		return nil, fmt.Errorf("%w: truncated stsz", ErrInvalidMP4)
+	}
+
+	//nolint:gosec // G115: count and fileSize are non-negative.
+	if constant != 0 && uint64(count)*uint64(constant) > uint64(fileSize) {
+		return nil, fmt.Errorf("%w: stsz declares %d samples of %d bytes in a %d-byte file",
+			ErrInvalidMP4, count, constant, fileSize)
+	}
+
+	sizes := make([]uint32, count)
+	for idx := range sizes {
+		sizes[idx] = constant
+		if constant == 0 {
+			sizes[idx] = binary.BigEndian.Uint32(table[idx*4:])
+		}
+	}
+
+	return sizes, nil
+}
+
+// mp4ChunkOffsets decodes the stco or co64 table.
+func mp4ChunkOffsets(stbl MP4Box) ([]uint64, error) {
+	width := 4
+
+	table, ok := mp4Find(stbl.Children, "stco")
+	if !ok {
+		width = 8
+
+		if table, ok = mp4Find(stbl.Children, "co64"); !ok {
+			return nil, fmt.Errorf("%w: missing stco and co64", ErrInvalidMP4)
+		}
+	}
+
+	if len(table.Payload()) < mp4FullBoxHeaderSize+4 {
+		return nil, fmt.Errorf("%w: truncated %s", ErrInvalidMP4, table.Type)
+	}
+
+	count := int(binary.BigEndian.Uint32(table.Payload()[mp4FullBoxHeaderSize:]))
+	entries := table.Payload()[mp4FullBoxHeaderSize+4:]
+
+	if len(entries) < count*width {
+		return nil, fmt.Errorf("%w: truncated %s", ErrInvalidMP4, table.Type)
+	}
+
+	offsets := make([]uint64, count)
+	for idx := range offsets {
+		if width == 4 {
+			offsets[idx] = uint64(binary.BigEndian.Uint32(entries[idx*width:]))
+		} else {
+			offsets[idx] = binary.BigEndian.Uint64(entries[idx*width:])
+		}
+	}
+
+	return offsets, nil
+}
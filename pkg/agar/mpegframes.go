@@ -0,0 +1,232 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MPEG audio frame layout constants.
+const (
+	mpegHeaderSize       = 4
+	mpegCRCSize          = 2
+	mpegVersion25        = 0
+	mpegVersionReserved  = 1
+	mpegVersion2         = 2
+	mpegVersion1         = 3
+	mpegLayerReserved    = 0
+	mpegLayerFromBits    = 4
+	mpegLayer1           = 1
+	mpegLayer3           = 3
+	mpegV2BitrateTable   = 3
+	mpegBitrateFree      = 0
+	mpegBitrateBad       = 15
+	mpegSampleRateBad    = 3
+	mpegLayerISlotBytes  = 4
+	mpegLayerISamples    = 384
+	mpegFullSamples      = 1152
+	mpegHalfSamples      = 576
+	mpegVBRIOffset       = 36
+	mpegSideInfoV1Stereo = 32
+	mpegSideInfoV1Mono   = 17
+	mpegSideInfoV2Stereo = 17
+	mpegSideInfoV2Mono   = 9
+	mpegKilo             = 1000
+	mpegSlotsPerSample   = 8
+)
+
+// ErrInvalidMPEG is returned when MPEG audio data is not a sequence of frames.
+var ErrInvalidMPEG = errors.New("invalid MPEG audio stream")
+
+// mpegBitrates holds kbit/s per bitrate index: MPEG-1 layers I, II, III, then MPEG-2/2.5 layer I, then II and III.
+var mpegBitrates = [5][15]int{ //nolint:gochecknoglobals // lookup table
+	{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+	{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+	{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+	{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+}
+
+// mpegSampleRates holds Hz per sample rate index, indexed by the version field (2.5, reserved, 2, 1).
+var mpegSampleRates = [4][3]int{ //nolint:gochecknoglobals // lookup table
+	{11025, 12000, 8000},
+	{},
+	{22050, 24000, 16000},
+	{44100, 48000, 32000},
+}
+
+// MPEGFrame is the header and extent of a single MPEG audio frame.
+type MPEGFrame struct {
+	// Offset is the file offset of the frame sync code.
+	Offset int
+	// Size is the frame size in bytes, header included.
+	Size int
+	// Version is "1", "2" or "2.5".
+	Version string
+	// Layer is 1, 2 or 3.
+	Layer int
+	// Bitrate is in kbit/s.
+	Bitrate    int
+	SampleRate int
+	// Samples is the number of samples per channel in the frame.
+	Samples int
+	Padding bool
+	// Protected is set when a CRC-16 follows the header.
+	Protected bool
+	// ChannelMode is "stereo", "joint-stereo", "dual-channel" or "mono".
+	ChannelMode string
+	// VBRHeader is "Xing", "Info" or "VBRI" when the frame carries an encoder VBR header instead of audio.
+	VBRHeader string
+}
+
+// String describes the frame on one line.
+func (f MPEGFrame) String() string {
+	out := fmt.Sprintf("frame @%d size %d MPEG-%s layer %d %dkbps %dHz %s samples %d",
+		f.Offset, f.Size, f.Version, f.Layer, f.Bitrate, f.SampleRate, f.ChannelMode, f.Samples)
+
+	if f.Padding {
+		out += " padded"
+	}
+
+	if f.Protected {
+		out += " crc"
+	}
+
+	if f.VBRHeader != "" {
+		out += " " + f.VBRHeader
+	}
+
+	return out
+}
+
+// WalkMPEGFrames locates every MPEG audio frame (MP3, MP2, MP1) after any leading ID3v2 tag and before
+// any trailing APEv2, ID3v1 or ID3v2 tag. Anything between frames, and free-format streams, are errors.
+func WalkMPEGFrames(data []byte) ([]MPEGFrame, error) {
+	start := leadingID3v2Size(data)
+	end, _ := splitTrailingTags(data, len(data))
+
+	var frames []MPEGFrame
+
+	for pos := start; pos < end; {
+		frame, err := parseMPEGFrameHeader(data[pos:end])
+		if err != nil {
+			return frames, fmt.Errorf("offset %d: %w", pos, err)
+		}
+
+		if pos+frame.Size > end {
+			return frames, fmt.Errorf("%w: truncated frame at offset %d", ErrInvalidMPEG, pos)
+		}
+
+		frame.Offset = pos
+		frame.VBRHeader = mpegVBRHeader(data[pos:pos+frame.Size], frame)
+		frames = append(frames, frame)
+		pos += frame.Size
+	}
+
+	return frames, nil
+}
+
+// parseMPEGFrameHeader decodes the frame header at the start of data.
+func parseMPEGFrameHeader(data []byte) (MPEGFrame, error) {
+	if len(data) < mpegHeaderSize || data[0] != mpegFrameSync || data[1]&mpegFrameSyncMask != mpegFrameSyncMask {
+		return MPEGFrame{}, fmt.Errorf("%w: no frame sync", ErrInvalidMPEG)
+	}
+
+	version := int(data[1] >> 3 & 0x03)
+	layerBits := int(data[1] >> 1 & 0x03)
+	bitrateIndex := int(data[2] >> 4)
+	rateIndex := int(data[2] >> 2 & 0x03)
+
+	switch {
+	case version == mpegVersionReserved || layerBits == mpegLayerReserved || rateIndex == mpegSampleRateBad:
+		return MPEGFrame{}, fmt.Errorf("%w: reserved header values", ErrInvalidMPEG)
+	case bitrateIndex == mpegBitrateFree || bitrateIndex == mpegBitrateBad:
+		return MPEGFrame{}, fmt.Errorf("%w: free-format or invalid bitrate", ErrInvalidMPEG)
+	}
+
+	frame := MPEGFrame{
+		Version:     map[int]string{mpegVersion1: "1", mpegVersion2: "2", mpegVersion25: "2.5"}[version],
+		Layer:       mpegLayerFromBits - layerBits,
+		SampleRate:  mpegSampleRates[version][rateIndex],
+		Padding:     data[2]&0x02 != 0,
+		Protected:   data[1]&0x01 == 0,
+		ChannelMode: []string{"stereo", "joint-stereo", "dual-channel", "mono"}[data[3]>>6],
+	}
+
+	table := frame.Layer - 1
+	if version != mpegVersion1 {
+		table = mpegV2BitrateTable + min(frame.Layer-1, 1)
+	}
+
+	frame.Bitrate = mpegBitrates[table][bitrateIndex]
+
+	padding := 0
+	if frame.Padding {
+		padding = 1
+	}
+
+	switch {
+	case frame.Layer == mpegLayer1:
+		frame.Samples = mpegLayerISamples
+		frame.Size = (frame.Samples/mpegSlotsPerSample/mpegLayerISlotBytes*frame.Bitrate*mpegKilo/frame.SampleRate +
+			padding) * mpegLayerISlotBytes
+	case frame.Layer == mpegLayer3 && version != mpegVersion1:
+		frame.Samples = mpegHalfSamples
+		frame.Size = frame.Samples/mpegSlotsPerSample*frame.Bitrate*mpegKilo/frame.SampleRate + padding
+	default:
+		frame.Samples = mpegFullSamples
+		frame.Size = frame.Samples/mpegSlotsPerSample*frame.Bitrate*mpegKilo/frame.SampleRate + padding
+	}
+
+	return frame, nil
+}
+
+// mpegVBRHeader detects a Xing, Info or VBRI header in a layer III frame.
+func mpegVBRHeader(data []byte, frame MPEGFrame) string {
+	if frame.Layer != mpegLayer3 {
+		return ""
+	}
+
+	sideInfo := mpegSideInfoV2Stereo
+
+	switch {
+	case frame.Version == "1" && frame.ChannelMode == "mono":
+		sideInfo = mpegSideInfoV1Mono
+	case frame.Version == "1":
+		sideInfo = mpegSideInfoV1Stereo
+	case frame.ChannelMode == "mono":
+		sideInfo = mpegSideInfoV2Mono
+	}
+
+	offset := mpegHeaderSize + sideInfo
+	if frame.Protected {
+		offset += mpegCRCSize
+	}
+
+	for _, candidate := range []struct {
+		offset int
+		tag    string
+	}{{offset, "Xing"}, {offset, "Info"}, {mpegVBRIOffset, "VBRI"}} {
+		if len(data) >= candidate.offset+len(candidate.tag) &&
+			string(data[candidate.offset:candidate.offset+len(candidate.tag)]) == candidate.tag {
+			return candidate.tag
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,321 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Ogg page layout constants.
+const (
+	oggCapturePattern   = "OggS"
+	oggPageHeaderSize   = 27
+	oggCRCOffset        = 22
+	oggLacingMax        = 255
+	oggCRCPolynomial    = 0x04c11db7
+	oggFLACHeaderSize   = 9
+	oggFLACHeaderPrefix = "\x7fFLAC"
+)
+
+// Sentinel errors for Ogg parsing.
+var (
+	ErrInvalidOgg      = errors.New("invalid Ogg stream")
+	ErrUnknownOggCodec = errors.New("unknown Ogg codec")
+)
+
+// oggCRCTable is the table for the Ogg page checksum (polynomial 0x04c11db7, no reflection, zero init).
+var oggCRCTable = newOggCRCTable() //nolint:gochecknoglobals // lookup table
+
+// Ogg page header type flags.
+const (
+	OggPageContinued = 0x01
+	OggPageBOS       = 0x02
+	OggPageEOS       = 0x04
+)
+
+// OggPage is a single Ogg page.
+type OggPage struct {
+	// Offset is the file offset of the capture pattern.
+	Offset int
+	// Version is the stream structure version, 0 for every conformant stream.
+	Version byte
+	// HeaderType is a combination of OggPageContinued, OggPageBOS and OggPageEOS.
+	HeaderType byte
+	// Granule is the codec-specific position at the end of the last packet completed on the page,
+	// or -1 when no packet completes on it.
+	Granule int64
+	Serial  uint32
+	// Sequence is the page number within its logical stream.
+	Sequence uint32
+	// Checksum is the stored CRC; ChecksumValid reports whether it matches the page.
+	Checksum      uint32
+	ChecksumValid bool
+	// Lacing is the segment table.
+	Lacing []byte
+	// Body is the page payload.
+	Body []byte
+}
+
+// Continued reports whether the page starts with the continuation of a packet from the previous page.
+func (p OggPage) Continued() bool {
+	return p.HeaderType&OggPageContinued != 0
+}
+
+// BOS reports whether the page is the first of its logical stream.
+func (p OggPage) BOS() bool {
+	return p.HeaderType&OggPageBOS != 0
+}
+
+// EOS reports whether the page is the last of its logical stream.
+func (p OggPage) EOS() bool {
+	return p.HeaderType&OggPageEOS != 0
+}
+
+// Size returns the page size, header and segment table included.
+func (p OggPage) Size() int {
+	return oggPageHeaderSize + len(p.Lacing) + len(p.Body)
+}
+
+// String describes the page on one line.
+func (p OggPage) String() string {
+	flags := ""
+
+	for _, flag := range []struct {
+		set  bool
+		name string
+	}{{p.Continued(), " continued"}, {p.BOS(), " bos"}, {p.EOS(), " eos"}, {!p.ChecksumValid, " bad-crc"}} {
+		if flag.set {
+			flags += flag.name
+		}
+	}
+
+	return fmt.Sprintf("page @%d serial %08x seq %d granule %d segments %d size %d%s",
+		p.Offset, p.Serial, p.Sequence, p.Granule, len(p.Lacing), p.Size(), flags)
+}
+
+// WalkOggPages splits an Ogg file into pages. A page with a wrong checksum is returned with ChecksumValid
+// unset; data that is not a sequence of complete pages is an error.
+func WalkOggPages(data []byte) ([]OggPage, error) {
+	var pages []OggPage
+
+	for offset := 0; offset < len(data); {
+		if !bytes.HasPrefix(data[offset:], []byte(oggCapturePattern)) || offset+oggPageHeaderSize > len(data) {
+			return nil, fmt.Errorf("%w: no page at offset %d", ErrInvalidOgg, offset)
+		}
+
+		header := data[offset : offset+oggPageHeaderSize]
+		segments := int(header[oggPageHeaderSize-1])
+		lacingEnd := offset + oggPageHeaderSize + segments
+
+		if lacingEnd > len(data) {
+			return nil, fmt.Errorf("%w: truncated page at offset %d", ErrInvalidOgg, offset)
+		}
+
+		bodySize := 0
+		for _, lace := range data[offset+oggPageHeaderSize : lacingEnd] {
+			bodySize += int(lace)
+		}
+
+		end := lacingEnd + bodySize
+		if end > len(data) {
+			return nil, fmt.Errorf("%w: truncated page at offset %d", ErrInvalidOgg, offset)
+		}
+
+		checksum := binary.LittleEndian.Uint32(header[oggCRCOffset:])
+		pages = append(pages, OggPage{
+			Offset:        offset,
+			Version:       header[4],
+			HeaderType:    header[5],
+			Granule:       int64(binary.LittleEndian.Uint64(header[6:])), //nolint:gosec // G115: granules are signed.
+			Serial:        binary.LittleEndian.Uint32(header[14:]),
+			Sequence:      binary.LittleEndian.Uint32(header[18:]),
+			Checksum:      checksum,
+			ChecksumValid: oggChecksum(data[offset:end]) == checksum,
+			Lacing:        data[offset+oggPageHeaderSize : lacingEnd],
+			Body:          data[lacingEnd:end],
+		})
+		offset = end
+	}
+
+	return pages, nil
+}
+
+// oggPacket is a packet reassembled from the segments of one logical stream.
+type oggPacket struct {
+	// offset is the file offset of the page on which the packet starts.
+	offset int
+	data   []byte
+}
+
+// oggStream is one logical stream of a (possibly multiplexed) Ogg file.
+type oggStream struct {
+	serial  uint32
+	pages   []OggPage
+	packets []oggPacket
+}
+
+// oggStreams groups pages by serial number, in order of first appearance, and reassembles their packets.
+// A packet left unterminated at the end of a stream is returned as is.
+func oggStreams(pages []OggPage) []*oggStream {
+	var streams []*oggStream
+
+	bySerial := map[uint32]*oggStream{}
+	pending := map[uint32]*oggPacket{}
+
+	for _, page := range pages {
+		stream, ok := bySerial[page.Serial]
+		if !ok {
+			stream = &oggStream{serial: page.Serial}
+			bySerial[page.Serial] = stream
+			streams = append(streams, stream)
+		}
+
+		stream.pages = append(stream.pages, page)
+		body := page.Body
+
+		for _, lace := range page.Lacing {
+			packet := pending[page.Serial]
+			if packet == nil {
+				packet = &oggPacket{offset: page.Offset}
+				pending[page.Serial] = packet
+			}
+
+			packet.data = append(packet.data, body[:lace]...)
+			body = body[lace:]
+
+			if lace < oggLacingMax {
+				stream.packets = append(stream.packets, *packet)
+				delete(pending, page.Serial)
+			}
+		}
+	}
+
+	for _, stream := range streams {
+		if packet := pending[stream.serial]; packet != nil {
+			stream.packets = append(stream.packets, *packet)
+		}
+	}
+
+	return streams
+}
+
+// oggHeaderNames identifies the codec of a stream from its first packet and names its header packets.
+func oggHeaderNames(first []byte) ([]string, error) {
+	switch {
+	case bytes.HasPrefix(first, []byte("\x01vorbis")), bytes.HasPrefix(first, []byte("\x80theora")):
+		return []string{"identification header", "comment header", "setup header"}, nil
+	case bytes.HasPrefix(first, []byte("OpusHead")):
+		return []string{"OpusHead", "OpusTags"}, nil
+	case bytes.HasPrefix(first, []byte("Speex   ")):
+		return []string{"Speex header", "comment header"}, nil
+	case len(first) >= oggFLACHeaderSize && bytes.HasPrefix(first, []byte(oggFLACHeaderPrefix)):
+		// The mapping header is followed by a count of the metadata block packets after it.
+		count := int(binary.BigEndian.Uint16(first[7:oggFLACHeaderSize]))
+		names := []string{"STREAMINFO"}
+
+		for idx := range count {
+			names = append(names, fmt.Sprintf("metadata#%d", idx))
+		}
+
+		return names, nil
+	default:
+		return nil, ErrUnknownOggCodec
+	}
+}
+
+// encodeOggPage serializes a page holding a single complete packet, which must be shorter than 255 * 255 bytes.
+func encodeOggPage(serial, sequence uint32, headerType byte, granule uint64, packet []byte) []byte {
+	var lacing []byte
+
+	for remaining := len(packet); ; remaining -= oggLacingMax {
+		if remaining < oggLacingMax {
+			lacing = append(lacing, byte(remaining))
+
+			break
+		}
+
+		lacing = append(lacing, oggLacingMax)
+	}
+
+	page := append([]byte(oggCapturePattern), 0, headerType)
+	page = binary.LittleEndian.AppendUint64(page, granule)
+	page = binary.LittleEndian.AppendUint32(page, serial)
+	page = binary.LittleEndian.AppendUint32(page, sequence)
+	page = binary.LittleEndian.AppendUint32(page, 0)
+	page = append(page, byte(len(lacing))) //nolint:gosec // G115: at most 255 lacing values for supported packets.
+	page = append(page, lacing...)
+	page = append(page, packet...)
+
+	binary.LittleEndian.PutUint32(page[oggCRCOffset:], oggChecksum(page))
+
+	return page
+}
+
+// vorbisIdentificationHeader returns a Vorbis identification header packet with a nominal bitrate of
+// 128 kbit/s and block sizes of 256 and 2048.
+func vorbisIdentificationHeader(channels byte, sampleRate uint32) []byte {
+	const (
+		nominalBitrate = 128000
+		blockSizes     = 0xb8
+	)
+
+	header := append([]byte("\x01vorbis"), 0, 0, 0, 0, channels)
+	header = binary.LittleEndian.AppendUint32(header, sampleRate)
+	header = binary.LittleEndian.AppendUint32(header, 0)
+	header = binary.LittleEndian.AppendUint32(header, nominalBitrate)
+	header = binary.LittleEndian.AppendUint32(header, 0)
+
+	return append(header, blockSizes, 1)
+}
+
+// oggChecksum computes the page checksum with the CRC field treated as zero.
+func oggChecksum(page []byte) uint32 {
+	var crc uint32
+
+	for idx, b := range page {
+		if idx >= oggCRCOffset && idx < oggCRCOffset+4 {
+			b = 0
+		}
+
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+
+	return crc
+}
+
+// newOggCRCTable builds oggCRCTable.
+func newOggCRCTable() [256]uint32 {
+	var table [256]uint32
+
+	for idx := range table {
+		crc := uint32(idx) << 24 //nolint:gosec // G115: idx is below 256.
+		for range 8 {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ oggCRCPolynomial
+			} else {
+				crc <<= 1
+			}
+		}
+
+		table[idx] = crc
+	}
+
+	return table
+}
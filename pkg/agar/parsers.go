@@ -22,6 +22,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
 	"os/exec"
 	"regexp"
 	"strconv"
@@ -30,6 +31,7 @@ import (
 
 // Sentinel errors for unsupported formats.
 var (
+	// Deprecated: ParseID3v2 is implemented natively; never returned.
 	ErrMP3NotSupported  = errors.New("MP3/ID3v2 parsing not yet supported")
 	ErrOGGNotSupported  = errors.New("OGG Vorbis parsing not yet supported")
 	ErrOpusNotSupported = errors.New("opus parsing not yet supported")
@@ -169,25 +171,12 @@ func ParseAtomicParsley(ctx context.Context, filePath string) (*ParsedTags, erro
 
 // ParseMetaflac runs metaflac --export-tags-to=- on the file and parses output.
 func ParseMetaflac(ctx context.Context, filePath string) (*ParsedTags, error) {
-	metaflac, err := LookFor(metaflacBinary)
+	result, err := RunMetaflac(ctx, MetaflacOptions{Args: []string{"--export-tags-to=-", filePath}})
 	if err != nil {
-		return nil, fmt.Errorf("%s: %w", metaflacBinary, err)
+		return nil, fmt.Errorf("metaflac failed: %w", err)
 	}
 
-	//nolint:gosec // binary resolved by LookFor
-	cmd := exec.CommandContext(ctx, metaflac, "--export-tags-to=-", filePath)
-
-	var stdout, stderr bytes.Buffer
-
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	err = cmd.Run()
-	if err != nil {
-		return nil, fmt.Errorf("metaflac failed: %w\nstderr: %s", err, stderr.String())
-	}
-
-	output := stdout.Bytes()
+	output := result.Stdout
 
 	tags := NewParsedTags()
 	scanner := bufio.NewScanner(bytes.NewReader(output))
@@ -241,18 +230,12 @@ func ParseMetaflac(ctx context.Context, filePath string) (*ParsedTags, error) {
 
 // countMetaflacPictures counts PICTURE blocks in a FLAC file.
 func countMetaflacPictures(ctx context.Context, filePath string) int {
-	metaflac, err := LookFor(metaflacBinary)
+	result, err := RunMetaflac(ctx, MetaflacOptions{Args: []string{"--list", "--block-type=PICTURE", filePath}})
 	if err != nil {
 		return 0
 	}
 
-	//nolint:gosec // binary resolved by LookFor
-	cmd := exec.CommandContext(ctx, metaflac, "--list", "--block-type=PICTURE", filePath)
-
-	output, err := cmd.Output()
-	if err != nil {
-		return 0
-	}
+	output := result.Stdout
 
 	// Count "type: 6 (PICTURE)" lines
 	count := 0
@@ -267,9 +250,133 @@ func countMetaflacPictures(ctx context.Context, filePath string) int {
 	return count
 }
 
-// ParseID3v2 is a stub that logs a warning - MP3 not yet supported.
+// ParseID3v2 reads the ID3v2 tag of a file natively (at the start, or at the end with a footer)
+// and maps its frames to semantic names following Picard. ID3v2.3 TYER, TDAT and TIME are folded
+// into a single ISO 8601 "date" value.
 func ParseID3v2(filePath string) (*ParsedTags, error) {
-	return nil, fmt.Errorf("%w: %s", ErrMP3NotSupported, filePath)
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", filePath, err)
+	}
+
+	tag, err := FindID3v2(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, filePath)
+	}
+
+	version, frames, err := DecodeID3v2(tag)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, filePath)
+	}
+
+	tags := NewParsedTags()
+	split := map[string]string{}
+
+	for _, frame := range frames {
+		id := frame.ID
+		if version == ID3v22 {
+			if mapped, ok := id3v22FrameToV23[id]; ok {
+				id = mapped
+			}
+		}
+
+		switch id {
+		case "TYER", "TDAT", "TIME":
+			if len(frame.Data) > 0 {
+				if values := id3DecodeStrings(frame.Data[0], frame.Data[1:]); len(values) > 0 {
+					split[id] = values[0]
+				}
+			}
+		default:
+			addID3Frame(tags, id, frame.Data)
+		}
+	}
+
+	if tyer := split["TYER"]; tyer != "" {
+		value := tyer
+		if date, err := ParseID3v23Date(tyer, split["TDAT"], split["TIME"]); err == nil {
+			value = date.String()
+		}
+
+		tags.Text["date"] = append(tags.Text["date"], value)
+	}
+
+	return tags, nil
+}
+
+// addID3Frame adds one ID3v2.3/2.4 frame to tags.
+func addID3Frame(tags *ParsedTags, id string, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+
+	switch {
+	case id == "APIC":
+		tags.PictureCount++
+	case id == "UFID":
+		owner, identifier, _ := bytes.Cut(data, []byte{0})
+		if string(owner) == musicBrainzOwner {
+			tags.Text["musicbrainz_recordingid"] = append(tags.Text["musicbrainz_recordingid"], string(identifier))
+		}
+	case id == "TXXX":
+		values := id3DecodeStrings(data[0], data[1:])
+		if len(values) > 1 {
+			key := freeformToSemanticName(values[0])
+			tags.Text[key] = append(tags.Text[key], values[1:]...)
+		}
+	case id == "COMM" || id == "USLT":
+		addID3LanguageFrame(tags, id, data)
+	case id == "TIPL" || id == "IPLS" || id == "TMCL":
+		values := id3DecodeStrings(data[0], data[1:])
+		for idx := 0; idx+1 < len(values); idx += 2 {
+			key := id3InvolvedRoleToSemanticName(id, values[idx])
+			tags.Text[key] = append(tags.Text[key], values[idx+1])
+		}
+	case id == "TRCK":
+		if values := id3DecodeStrings(data[0], data[1:]); len(values) > 0 {
+			tags.Track, tags.TrackTotal = parsePairValue(values[0])
+			tags.Text["tracknumber"] = append(tags.Text["tracknumber"], values[0])
+		}
+	case id == "TPOS":
+		if values := id3DecodeStrings(data[0], data[1:]); len(values) > 0 {
+			tags.Disc, tags.DiscTotal = parsePairValue(values[0])
+			tags.Text["discnumber"] = append(tags.Text["discnumber"], values[0])
+		}
+	case id3IsTextFrame(id):
+		key := id3FrameToSemanticName(id)
+		tags.Text[key] = append(tags.Text[key], id3DecodeStrings(data[0], data[1:])...)
+	case strings.HasPrefix(id, "W") && id != "WXXX":
+		key := id3FrameToSemanticName(id)
+		tags.Text[key] = append(tags.Text[key], latin1ToString(bytes.TrimRight(data, "\x00")))
+	default:
+		// Other frames (PRIV, GEOB, POPM, ...) carry no semantic text.
+	}
+}
+
+// id3IsTextFrame reports whether a frame is a text information frame: T*** except TXXX,
+// plus the iTunes movement and grouping frames.
+func id3IsTextFrame(id string) bool {
+	return (strings.HasPrefix(id, "T") && id != "TXXX") || id == "MVNM" || id == "MVIN" || id == "GRP1"
+}
+
+// addID3LanguageFrame adds a COMM or USLT frame: encoding, language, description, then text.
+// Frames with a description are stored as "comment:description" (or "lyrics:description").
+func addID3LanguageFrame(tags *ParsedTags, id string, data []byte) {
+	const languageSize = 3
+
+	if len(data) < 1+languageSize {
+		return
+	}
+
+	description, rest := id3DecodeString(data[0], data[1+languageSize:])
+	text, _ := id3DecodeString(data[0], rest)
+
+	key := id3FrameToSemanticName(id)
+	if description != "" {
+		key += ":" + description
+	}
+
+	tags.Text[key] = append(tags.Text[key], text)
 }
 
 // ParseVorbisComment is a stub that logs a warning - OGG Vorbis not yet supported.
@@ -295,24 +402,26 @@ func formatPairValue(num, total int) string {
 func parsePairValue(value string) (num, total int) {
 	// Try "N/M" format
 	if parts := strings.Split(value, "/"); len(parts) == 2 {
-		num, _ = strconv.Atoi(strings.TrimSpace(parts[0]))
-		total, _ = strconv.Atoi(strings.TrimSpace(parts[1]))
-
-		return num, total
+		return parsePairInt(parts[0]), parsePairInt(parts[1])
 	}
 
 	// Try "N of M" format
 	if parts := strings.Split(value, " of "); len(parts) == 2 {
-		num, _ = strconv.Atoi(strings.TrimSpace(parts[0]))
-		total, _ = strconv.Atoi(strings.TrimSpace(parts[1]))
-
-		return num, total
+		return parsePairInt(parts[0]), parsePairInt(parts[1])
 	}
 
 	// Just a number
-	num, _ = strconv.Atoi(strings.TrimSpace(value))
+	return parsePairInt(value), 0
+}
 
-	return num, 0
+// parsePairInt parses one side of a pair value. Non-numeric and out of range values yield 0.
+func parsePairInt(value string) int {
+	num, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0
+	}
+
+	return num
 }
 
 // normalizeAtomName converts AtomicParsley's atom names to match go-mp4's representation.
@@ -395,6 +504,9 @@ var freeformNameToSemantic = map[string]string{
 	"ARRANGER":                          "arranger",
 	"BARCODE":                           "barcode",
 	"ISRC":                              "isrc",
+	"WORK":                              "work",
+	"MOVEMENTNAME":                      "movement",
+	"MOVEMENT":                          "movementnumber",
 }
 
 // vorbisToSemantic maps Vorbis comment tag names (UPPERCASE) to semantic names.
@@ -429,6 +541,8 @@ var vorbisToSemantic = map[string]string{
 	"LANGUAGE":        "language",
 	"LYRICS":          "lyrics",
 	"MEDIA":           "media",
+	"MOVEMENT":        "movementnumber",
+	"MOVEMENTNAME":    "movement",
 	"ORIGINALDATE":    "originaldate",
 	"ORIGINALYEAR":    "originalyear",
 	"PERFORMER":       "performer",
@@ -454,6 +568,215 @@ var vorbisToSemantic = map[string]string{
 	"ACOUSTID_ID":                "acoustid_id",
 }
 
+// id3v22FrameToV23 maps ID3v2.2 three-character frame IDs to their ID3v2.3/2.4 equivalents.
+//
+//nolint:gochecknoglobals // lookup table
+var id3v22FrameToV23 = map[string]string{
+	"TT1": "TIT1",
+	"TT2": "TIT2",
+	"TT3": "TIT3",
+	"TAL": "TALB",
+	"TP1": "TPE1",
+	"TP2": "TPE2",
+	"TP3": "TPE3",
+	"TP4": "TPE4",
+	"TCM": "TCOM",
+	"TXT": "TEXT",
+	"TPB": "TPUB",
+	"TCO": "TCON",
+	"TCR": "TCOP",
+	"TEN": "TENC",
+	"TSS": "TSSE",
+	"TBP": "TBPM",
+	"TKE": "TKEY",
+	"TLA": "TLAN",
+	"TMT": "TMED",
+	"TRC": "TSRC",
+	"TYE": "TYER",
+	"TDA": "TDAT",
+	"TIM": "TIME",
+	"TOR": "TORY",
+	"TRK": "TRCK",
+	"TPA": "TPOS",
+	"TS2": "TSO2",
+	"TSP": "TSOP",
+	"TSA": "TSOA",
+	"TST": "TSOT",
+	"TSC": "TSOC",
+	"TCP": "TCMP",
+	"TXX": "TXXX",
+	"UFI": "UFID",
+	"COM": "COMM",
+	"ULT": "USLT",
+	"IPL": "IPLS",
+	"PIC": "APIC",
+	"WAR": "WOAR",
+	"WCP": "WCOP",
+}
+
+// id3FrameToSemantic maps ID3v2.3/2.4 text and URL frame IDs to semantic names.
+// Based on MusicBrainz Picard tag mapping (TIT1 is grouping, work goes to TXXX:WORK).
+// TXXX, UFID, TIPL/TMCL/IPLS, TRCK/TPOS and the ID3v2.3 TYER/TDAT/TIME are handled by ParseID3v2.
+//
+//nolint:gochecknoglobals // lookup table
+var id3FrameToSemantic = map[string]string{
+	"TIT1": "grouping",
+	"GRP1": "grouping",
+	"TIT2": "title",
+	"TIT3": "subtitle",
+	"TALB": "album",
+	"TSST": "discsubtitle",
+	"TPE1": "artist",
+	"TPE2": "albumartist",
+	"TPE3": "conductor",
+	"TPE4": "remixer",
+	"TCOM": "composer",
+	"TEXT": "lyricist",
+	"TPUB": "label",
+	"TCON": "genre",
+	"TCOP": "copyright",
+	"TENC": "encodedby",
+	"TSSE": "encodersettings",
+	"TBPM": "bpm",
+	"TKEY": "key",
+	"TLAN": "language",
+	"TMED": "media",
+	"TMOO": "mood",
+	"TSRC": "isrc",
+	"TDRC": "date",
+	"TDRL": "releasedate",
+	"TDOR": "originaldate",
+	"TORY": "originaldate",
+	"TSOP": "artistsort",
+	"TSO2": "albumartistsort",
+	"TSOA": "albumsort",
+	"TSOT": "titlesort",
+	"TSOC": "composersort",
+	"TCMP": "compilation",
+	"MVNM": "movement",
+	"MVIN": "movementnumber",
+	"COMM": "comment",
+	"USLT": "lyrics",
+	"WOAR": "website",
+	"WCOP": "license",
+}
+
+// id3InvolvedRoleToSemantic maps TIPL (ID3v2.4) and IPLS (ID3v2.3) roles to semantic names.
+// TMCL entries are musician credits and map to "performer:<instrument>".
+//
+//nolint:gochecknoglobals // lookup table
+var id3InvolvedRoleToSemantic = map[string]string{
+	"arranger": "arranger",
+	"engineer": "engineer",
+	"producer": "producer",
+	"DJ-mix":   "djmixer",
+	"mix":      "mixer",
+}
+
+// semanticToID3Frame is the inverse of id3FrameToSemantic for ID3v2.4, choosing one frame per semantic name.
+//
+//nolint:gochecknoglobals // lookup table
+var semanticToID3Frame = map[string]string{
+	"grouping":        "TIT1",
+	"title":           "TIT2",
+	"subtitle":        "TIT3",
+	"album":           "TALB",
+	"discsubtitle":    "TSST",
+	"artist":          "TPE1",
+	"albumartist":     "TPE2",
+	"conductor":       "TPE3",
+	"remixer":         "TPE4",
+	"composer":        "TCOM",
+	"lyricist":        "TEXT",
+	"label":           "TPUB",
+	"genre":           "TCON",
+	"copyright":       "TCOP",
+	"encodedby":       "TENC",
+	"encodersettings": "TSSE",
+	"bpm":             "TBPM",
+	"key":             "TKEY",
+	"language":        "TLAN",
+	"media":           "TMED",
+	"mood":            "TMOO",
+	"isrc":            "TSRC",
+	"date":            "TDRC",
+	"releasedate":     "TDRL",
+	"originaldate":    "TDOR",
+	"artistsort":      "TSOP",
+	"albumartistsort": "TSO2",
+	"albumsort":       "TSOA",
+	"titlesort":       "TSOT",
+	"composersort":    "TSOC",
+	"compilation":     "TCMP",
+	"movement":        "MVNM",
+	"movementnumber":  "MVIN",
+	"tracknumber":     "TRCK",
+	"discnumber":      "TPOS",
+	"comment":         "COMM",
+	"lyrics":          "USLT",
+	"website":         "WOAR",
+	"license":         "WCOP",
+}
+
+// semanticToID3UserText maps semantic names stored in TXXX frames to their Picard descriptions.
+// Semantic names found in no table are written as TXXX frames described by the name itself.
+//
+//nolint:gochecknoglobals // lookup table
+var semanticToID3UserText = map[string]string{
+	"musicbrainz_releasetrackid": "MusicBrainz Release Track Id",
+	"musicbrainz_albumid":        "MusicBrainz Album Id",
+	"musicbrainz_artistid":       "MusicBrainz Artist Id",
+	"musicbrainz_albumartistid":  "MusicBrainz Album Artist Id",
+	"musicbrainz_releasegroupid": "MusicBrainz Release Group Id",
+	"musicbrainz_workid":         "MusicBrainz Work Id",
+	"releasetype":                "MusicBrainz Album Type",
+	"releasestatus":              "MusicBrainz Album Status",
+	"releasecountry":             "MusicBrainz Album Release Country",
+	"acoustid_id":                "Acoustid Id",
+	"asin":                       "ASIN",
+	"barcode":                    "BARCODE",
+	"catalognumber":              "CATALOGNUMBER",
+	"script":                     "SCRIPT",
+	"artists":                    "ARTISTS",
+	"originalyear":               "originalyear",
+	"work":                       "WORK",
+	"movement":                   "MOVEMENTNAME",
+	"movementnumber":             "MOVEMENT",
+}
+
+// infoToSemantic maps RIFF LIST/INFO chunk identifiers to semantic names.
+// ITRK and IPRT are both used for the track number in the wild (IPRT is what ffmpeg writes).
+// IMPORTANT: INFO ISRC is the "source" field, not an International Standard Recording Code!
+//
+//nolint:gochecknoglobals // lookup table
+var infoToSemantic = map[string]string{
+	"INAM": "title",
+	"IART": "artist",
+	"IPRD": "album",
+	"ICRD": "date",
+	"ITRK": "tracknumber",
+	"IPRT": "tracknumber",
+	"IGNR": "genre",
+	"ICMT": "comment",
+	"ICOP": "copyright",
+	"ICMS": "commissioned",
+	"IENG": "engineer",
+	"ILNG": "language",
+	"ISFT": "encoder",
+	"ISRC": "source",
+	"IKEY": "keyword",
+}
+
+// aiffTextToSemantic maps AIFF text chunk identifiers to semantic names.
+//
+//nolint:gochecknoglobals // lookup table
+var aiffTextToSemantic = map[string]string{
+	"NAME": "title",
+	"AUTH": "artist",
+	"ANNO": "comment",
+	"(c) ": "copyright",
+}
+
 // vorbisToSemanticName converts a Vorbis comment tag name to a semantic name.
 func vorbisToSemanticName(vorbisKey string) string {
 	if semantic, ok := vorbisToSemantic[vorbisKey]; ok {
@@ -483,3 +806,41 @@ func freeformToSemanticName(name string) string {
 	// Default: lowercase and replace spaces with underscores
 	return strings.ToLower(strings.ReplaceAll(name, " ", "_"))
 }
+
+// infoToSemanticName converts a RIFF INFO chunk identifier to a semantic name.
+func infoToSemanticName(id string) string {
+	if semantic, ok := infoToSemantic[id]; ok {
+		return semantic
+	}
+
+	// Default: lowercase the identifier
+	return strings.ToLower(strings.TrimSpace(id))
+}
+
+// id3FrameToSemanticName converts an ID3v2.3/2.4 frame ID to a semantic name.
+func id3FrameToSemanticName(id string) string {
+	if semantic, ok := id3FrameToSemantic[id]; ok {
+		return semantic
+	}
+
+	// Default: lowercase the frame ID
+	return strings.ToLower(id)
+}
+
+// id3InvolvedRoleToSemanticName converts a TIPL/IPLS role or TMCL instrument to a semantic name.
+// IPLS holds both involved people and musicians: unknown IPLS roles are taken as instruments.
+func id3InvolvedRoleToSemanticName(frameID, role string) string {
+	if frameID == "TMCL" {
+		return "performer:" + role
+	}
+
+	if semantic, ok := id3InvolvedRoleToSemantic[role]; ok {
+		return semantic
+	}
+
+	if frameID == "IPLS" {
+		return "performer:" + role
+	}
+
+	return strings.ToLower(role)
+}
@@ -0,0 +1,510 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+	"github.com/containerd/nerdctl/mod/tigron/tig"
+)
+
+// Audio payload formats reported by ExtractAudioPayload.
+const (
+	PayloadFormatFLAC = "flac"
+	PayloadFormatMPEG = "mpeg"
+	PayloadFormatMP4  = "mp4"
+	PayloadFormatOgg  = "ogg"
+)
+
+// Tag layout constants for payload extraction.
+const (
+	apeTagMagic       = "APETAGEX"
+	apeTagFooterSize  = 32
+	apeTagHasHeader   = 0x80000000
+	mpegFrameSync     = 0xff
+	mpegFrameSyncMask = 0xe0
+)
+
+// Sentinel errors for payload comparison.
+var (
+	ErrUnknownPayloadFormat  = errors.New("unrecognised audio container")
+	ErrPayloadFormatMismatch = errors.New("container format changed")
+)
+
+// PayloadStructure is a non-audio (or audio) region of a file, identified by a stable name.
+type PayloadStructure struct {
+	// Name identifies the structure across files, e.g. "VORBIS_COMMENT", "ID3v2", "moov/udta/meta/ilst",
+	// "stream#0/comment header". Repeated structures are suffixed "#1", "#2", ...
+	Name string
+	// Offset is the file offset of the structure, or -1 for structures gathered from several regions
+	// (Ogg packet sizes and page granules).
+	Offset int
+	// Data is the raw content of the structure, header included where it has one.
+	Data []byte
+}
+
+// AudioPayload is the audio of a file with everything around it broken down into structures.
+type AudioPayload struct {
+	// Format is one of the PayloadFormat constants.
+	Format string
+	// Audio is the coded audio: FLAC frames, MPEG frames, MP4 samples in track order,
+	// or Ogg audio packets in stream order.
+	Audio []byte
+	// Structures lists tags, metadata blocks, boxes, header packets and the audio region itself, in file order.
+	Structures []PayloadStructure
+}
+
+// PayloadChangeKind classifies how a structure differs between two files, as the verb PayloadChange prints.
+type PayloadChangeKind string
+
+// Structure change kinds.
+const (
+	// PayloadAdded is a structure present only after.
+	PayloadAdded PayloadChangeKind = "added"
+	// PayloadRemoved is a structure present only before.
+	PayloadRemoved PayloadChangeKind = "removed"
+	// PayloadResized is a structure whose size changed.
+	PayloadResized PayloadChangeKind = "resized"
+	// PayloadModified is a structure with the same size but different content.
+	PayloadModified PayloadChangeKind = "modified"
+	// PayloadMoved is a structure with identical content at a different offset.
+	PayloadMoved PayloadChangeKind = "moved"
+)
+
+// PayloadChange is a structure that differs between two files.
+type PayloadChange struct {
+	Kind PayloadChangeKind
+	Name string
+	// Before and After are zero for added and removed structures respectively.
+	Before PayloadStructure
+	After  PayloadStructure
+}
+
+// String describes the change on one line, e.g. "PADDING: resized 8192 -> 4096 bytes, moved 42 -> 4138".
+func (c PayloadChange) String() string {
+	var detail string
+
+	switch c.Kind {
+	case PayloadAdded:
+		detail = fmt.Sprintf("%d bytes at %d", len(c.After.Data), c.After.Offset)
+	case PayloadRemoved:
+		detail = fmt.Sprintf("%d bytes at %d", len(c.Before.Data), c.Before.Offset)
+	case PayloadResized:
+		detail = fmt.Sprintf("%d -> %d bytes", len(c.Before.Data), len(c.After.Data))
+	case PayloadModified:
+		detail = fmt.Sprintf("%d bytes", len(c.After.Data))
+	case PayloadMoved:
+		detail = fmt.Sprintf("%d -> %d", c.Before.Offset, c.After.Offset)
+	}
+
+	if (c.Kind == PayloadResized || c.Kind == PayloadModified) && c.Before.Offset != c.After.Offset {
+		detail += fmt.Sprintf(", moved %d -> %d", c.Before.Offset, c.After.Offset)
+	}
+
+	return c.Name + ": " + string(c.Kind) + " " + detail
+}
+
+// PayloadReport is the result of comparing the audio payloads of two files.
+type PayloadReport struct {
+	Format string
+	// Identical is true when both audio payloads are byte-identical.
+	Identical bool
+	// BeforeSize and AfterSize are the audio payload sizes.
+	BeforeSize int
+	AfterSize  int
+	// FirstDifference is the payload offset of the first differing byte, or -1 when Identical.
+	FirstDifference int
+	// Changes lists every structure that was added, removed, resized, modified or moved.
+	Changes []PayloadChange
+}
+
+// String summarises the report, one structure change per line.
+func (r PayloadReport) String() string {
+	var out strings.Builder
+
+	if r.Identical {
+		fmt.Fprintf(&out, "%s audio payload unchanged (%d bytes)", r.Format, r.BeforeSize)
+	} else {
+		fmt.Fprintf(&out, "%s audio payload differs at byte %d (%d -> %d bytes)",
+			r.Format, r.FirstDifference, r.BeforeSize, r.AfterSize)
+	}
+
+	for _, change := range r.Changes {
+		out.WriteString("\n  " + change.String())
+	}
+
+	return out.String()
+}
+
+// ExtractAudioPayload locates the audio of a FLAC, MPEG audio (MP3, ADTS), MP4 or Ogg file structurally.
+// Leading ID3v2 tags and trailing APEv2, ID3v1 and footer-carrying ID3v2 tags are split off FLAC and MPEG
+// streams. MP4 samples are gathered through each track's stsz, stsc and stco/co64 tables, so moving mdat
+// does not change the payload. Ogg pages must carry valid checksums; the audio is every packet after the
+// codec's header packets.
+func ExtractAudioPayload(data []byte) (AudioPayload, error) {
+	var structures payloadStructures
+
+	start := 0
+	for size := id3v2TagSize(data); size > 0; size = id3v2TagSize(data[start:]) {
+		structures.add("ID3v2", start, data[start:start+size])
+		start += size
+	}
+
+	body := data[start:]
+
+	switch {
+	case bytes.HasPrefix(body, []byte(flacMarker)):
+		return extractFLACPayload(data, start, structures)
+	case bytes.HasPrefix(body, []byte(oggCapturePattern)):
+		return extractOggPayload(body, start, structures)
+	case len(body) >= mp4BoxHeaderSize && string(body[4:8]) == "ftyp":
+		return extractMP4Payload(data, start, structures)
+	case len(body) > 1 && body[0] == mpegFrameSync && body[1]&mpegFrameSyncMask == mpegFrameSyncMask:
+		end, trailing := splitTrailingTags(data, len(data))
+		end = max(end, start)
+		structures.add("frames", start, data[start:end])
+		structures.list = append(structures.list, trailing...)
+
+		return AudioPayload{Format: PayloadFormatMPEG, Audio: data[start:end], Structures: structures.list}, nil
+	default:
+		return AudioPayload{}, ErrUnknownPayloadFormat
+	}
+}
+
+// CompareAudioPayloads extracts the audio payloads of before and after and reports every structure that changed.
+func CompareAudioPayloads(before, after []byte) (PayloadReport, error) {
+	beforePayload, err := ExtractAudioPayload(before)
+	if err != nil {
+		return PayloadReport{}, fmt.Errorf("before: %w", err)
+	}
+
+	afterPayload, err := ExtractAudioPayload(after)
+	if err != nil {
+		return PayloadReport{}, fmt.Errorf("after: %w", err)
+	}
+
+	if beforePayload.Format != afterPayload.Format {
+		return PayloadReport{}, fmt.Errorf("%w: %s -> %s",
+			ErrPayloadFormatMismatch, beforePayload.Format, afterPayload.Format)
+	}
+
+	report := PayloadReport{
+		Format:          beforePayload.Format,
+		Identical:       bytes.Equal(beforePayload.Audio, afterPayload.Audio),
+		BeforeSize:      len(beforePayload.Audio),
+		AfterSize:       len(afterPayload.Audio),
+		FirstDifference: -1,
+		Changes:         diffPayloadStructures(beforePayload.Structures, afterPayload.Structures),
+	}
+
+	if !report.Identical {
+		report.FirstDifference = min(report.BeforeSize, report.AfterSize)
+
+		for idx := range report.FirstDifference {
+			if beforePayload.Audio[idx] != afterPayload.Audio[idx] {
+				report.FirstDifference = idx
+
+				break
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// RequireAudioPayloadUnchanged fails the test unless the audio payloads of the files at beforePath and
+// afterPath are byte-identical. Structure changes are logged either way.
+func RequireAudioPayloadUnchanged(helper tig.T, beforePath, afterPath string) PayloadReport {
+	helper.Helper()
+
+	report, err := CompareAudioPayloads(readFixtureFile(helper, beforePath), readFixtureFile(helper, afterPath))
+	if err != nil {
+		helper.Log(err.Error())
+		helper.FailNow()
+	}
+
+	helper.Log(report.String())
+
+	if !report.Identical {
+		helper.FailNow()
+	}
+
+	return report
+}
+
+// AudioPayloadUnchanged returns a comparator for test.Expected.Output that runs RequireAudioPayloadUnchanged.
+// beforePath must be a copy of the file taken before the command modified afterPath.
+func AudioPayloadUnchanged(beforePath, afterPath string) test.Comparator {
+	return func(_ string, helper tig.T) {
+		helper.Helper()
+		RequireAudioPayloadUnchanged(helper, beforePath, afterPath)
+	}
+}
+
+// payloadStructures accumulates structures, suffixing repeated names.
+type payloadStructures struct {
+	list []PayloadStructure
+	seen map[string]int
+}
+
+// add appends a structure, naming the n-th repeat of name "name#n".
+func (s *payloadStructures) add(name string, offset int, data []byte) {
+	if s.seen == nil {
+		s.seen = map[string]int{}
+	}
+
+	if count := s.seen[name]; count > 0 {
+		s.seen[name]++
+		name += "#" + strconv.Itoa(count)
+	} else {
+		s.seen[name] = 1
+	}
+
+	s.list = append(s.list, PayloadStructure{Name: name, Offset: offset, Data: data})
+}
+
+// extractFLACPayload splits a FLAC stream starting at offset start into metadata blocks, frames and trailing tags.
+func extractFLACPayload(data []byte, start int, structures payloadStructures) (AudioPayload, error) {
+	file, err := ReadFLAC(data[start:])
+	if err != nil {
+		return AudioPayload{}, err
+	}
+
+	offset := start + len(flacMarker)
+	for _, block := range file.Blocks {
+		end := offset + flacBlockHeaderSize + len(block.Data)
+		structures.add(flacBlockName(block.Type), offset, data[offset:end])
+		offset = end
+	}
+
+	end, trailing := splitTrailingTags(data, len(data))
+	if end < offset {
+		end = offset
+	}
+
+	structures.add("frames", offset, data[offset:end])
+	structures.list = append(structures.list, trailing...)
+
+	return AudioPayload{Format: PayloadFormatFLAC, Audio: data[offset:end], Structures: structures.list}, nil
+}
+
+// extractMP4Payload lists the leaf boxes of an MP4 file whose ftyp is at offset base and gathers its track samples.
+// Chunk offsets are file offsets, so anything before ftyp is accounted for.
+func extractMP4Payload(data []byte, base int, structures payloadStructures) (AudioPayload, error) {
+	boxes, err := parseMP4Boxes(data[base:], base)
+	if err != nil {
+		return AudioPayload{}, err
+	}
+
+	moov, ok := mp4Find(boxes, "moov")
+	if !ok {
+		return AudioPayload{}, fmt.Errorf("%w: no moov box", ErrInvalidMP4)
+	}
+
+	tracks, err := mp4TrackSamples(data, moov)
+	if err != nil {
+		return AudioPayload{}, err
+	}
+
+	mp4Leaves(boxes, "", func(path string, box MP4Box) {
+		structures.add(path, box.Offset, box.Data)
+	})
+
+	return AudioPayload{Format: PayloadFormatMP4, Audio: bytes.Join(tracks, nil), Structures: structures.list}, nil
+}
+
+// extractOggPayload splits an Ogg file starting at offset base into header packets and audio packets per stream.
+func extractOggPayload(data []byte, base int, structures payloadStructures) (AudioPayload, error) {
+	pages, err := WalkOggPages(data)
+	if err != nil {
+		return AudioPayload{}, err
+	}
+
+	for _, page := range pages {
+		if !page.ChecksumValid {
+			return AudioPayload{}, fmt.Errorf("%w: checksum mismatch in page at offset %d",
+				ErrInvalidOgg, base+page.Offset)
+		}
+	}
+
+	var audio []byte
+
+	for streamIdx, stream := range oggStreams(pages) {
+		prefix := fmt.Sprintf("stream#%d/", streamIdx)
+
+		if len(stream.packets) == 0 {
+			return AudioPayload{}, fmt.Errorf("%w: stream %d has no packets", ErrInvalidOgg, streamIdx)
+		}
+
+		names, nameErr := oggHeaderNames(stream.packets[0].data)
+		if nameErr != nil {
+			return AudioPayload{}, fmt.Errorf("stream %d: %w", streamIdx, nameErr)
+		}
+
+		headers := min(len(names), len(stream.packets))
+		for idx, packet := range stream.packets[:headers] {
+			structures.add(prefix+names[idx], base+packet.offset, packet.data)
+		}
+
+		var (
+			streamAudio []byte
+			sizes       []byte
+			granules    []byte
+		)
+
+		for _, packet := range stream.packets[headers:] {
+			streamAudio = append(streamAudio, packet.data...)
+			size := uint32(len(packet.data)) //nolint:gosec // G115: packets are far below 4 GB.
+			sizes = binary.BigEndian.AppendUint32(sizes, size)
+		}
+
+		audioOffset := base + len(data)
+		if headers < len(stream.packets) {
+			audioOffset = base + stream.packets[headers].offset
+		}
+
+		for _, page := range stream.pages {
+			if base+page.Offset >= audioOffset {
+				granule := uint64(page.Granule) //nolint:gosec // G115: only the bit pattern is compared.
+				granules = binary.LittleEndian.AppendUint64(granules, granule)
+			}
+		}
+
+		structures.add(prefix+"audio", audioOffset, streamAudio)
+		structures.add(prefix+"packet sizes", -1, sizes)
+		structures.add(prefix+"page granules", -1, granules)
+
+		audio = append(audio, streamAudio...)
+	}
+
+	return AudioPayload{Format: PayloadFormatOgg, Audio: audio, Structures: structures.list}, nil
+}
+
+// splitTrailingTags peels APEv2, ID3v1 and footer-carrying ID3v2 tags, in any order, off data[:end].
+// It returns the end of the remaining data and the tags in file order.
+func splitTrailingTags(data []byte, end int) (int, []PayloadStructure) {
+	var tags []PayloadStructure
+
+	for {
+		name, size := trailingTag(data[:end])
+		if size == 0 {
+			break
+		}
+
+		end -= size
+		tags = append([]PayloadStructure{{Name: name, Offset: end, Data: data[end : end+size]}}, tags...)
+	}
+
+	return end, tags
+}
+
+// trailingTag identifies the tag ending data, returning its name and size, or a zero size.
+func trailingTag(data []byte) (string, int) {
+	end := len(data)
+
+	if end >= id3v1TagSize && string(data[end-id3v1TagSize:end-id3v1TagSize+3]) == "TAG" {
+		return "ID3v1", id3v1TagSize
+	}
+
+	if footer := data[max(end-apeTagFooterSize, 0):]; len(footer) == apeTagFooterSize &&
+		bytes.HasPrefix(footer, []byte(apeTagMagic)) {
+		size := int(binary.LittleEndian.Uint32(footer[12:]))
+
+		if binary.LittleEndian.Uint32(footer[20:])&apeTagHasHeader != 0 {
+			size += apeTagFooterSize
+		}
+
+		if size >= apeTagFooterSize && size <= end {
+			return "APEv2", size
+		}
+	}
+
+	if end >= 2*id3v2HeaderSize && string(data[end-id3v2HeaderSize:end-id3v2HeaderSize+3]) == id3FooterMagic {
+		size := 2*id3v2HeaderSize + int(unsyncsafe(data[end-4:end]))
+		if size <= end {
+			return "ID3v2 (appended)", size
+		}
+	}
+
+	return "", 0
+}
+
+// diffPayloadStructures matches structures by name and reports those that differ, in before order
+// followed by additions.
+func diffPayloadStructures(before, after []PayloadStructure) []PayloadChange {
+	var changes []PayloadChange
+
+	afterByName := map[string]PayloadStructure{}
+	for _, structure := range after {
+		afterByName[structure.Name] = structure
+	}
+
+	beforeNames := map[string]bool{}
+
+	for _, old := range before {
+		beforeNames[old.Name] = true
+
+		current, ok := afterByName[old.Name]
+
+		switch {
+		case !ok:
+			changes = append(changes, PayloadChange{Kind: PayloadRemoved, Name: old.Name, Before: old})
+		case len(old.Data) != len(current.Data):
+			changes = append(changes, PayloadChange{Kind: PayloadResized, Name: old.Name, Before: old, After: current})
+		case !bytes.Equal(old.Data, current.Data):
+			changes = append(changes, PayloadChange{Kind: PayloadModified, Name: old.Name, Before: old, After: current})
+		case old.Offset != current.Offset:
+			changes = append(changes, PayloadChange{Kind: PayloadMoved, Name: old.Name, Before: old, After: current})
+		}
+	}
+
+	for _, current := range after {
+		if !beforeNames[current.Name] {
+			changes = append(changes, PayloadChange{Kind: PayloadAdded, Name: current.Name, After: current})
+		}
+	}
+
+	return changes
+}
+
+// flacBlockName returns the specification name of a metadata block type.
+func flacBlockName(blockType uint8) string {
+	switch blockType {
+	case FLACBlockStreamInfo:
+		return "STREAMINFO"
+	case FLACBlockPadding:
+		return "PADDING"
+	case FLACBlockApplication:
+		return "APPLICATION"
+	case FLACBlockSeekTable:
+		return "SEEKTABLE"
+	case FLACBlockVorbisComment:
+		return "VORBIS_COMMENT"
+	case FLACBlockCueSheet:
+		return "CUESHEET"
+	case FLACBlockPicture:
+		return "PICTURE"
+	default:
+		return "RESERVED_" + strconv.Itoa(int(blockType))
+	}
+}
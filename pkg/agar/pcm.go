@@ -19,6 +19,7 @@ package agar
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
@@ -133,11 +134,18 @@ func GenerateWhiteNoise(sampleRate, bitDepth, channels, durationSec int) []byte
 	return buf
 }
 
-// CompareLosslessSamples requires exact byte match for lossless codecs.
-// The label identifies which comparison is being made (e.g. "saprobe vs ffmpeg").
-func CompareLosslessSamples(t *testing.T, label string, expected, actual []byte, bitDepth, channels int) {
-	t.Helper()
+// PCMComparison is the outcome of comparing two PCM buffers.
+type PCMComparison struct {
+	// Match is true when the buffers agree within the comparison's tolerance.
+	Match bool
+	// Detail explains a mismatch, or a tolerated difference when Match is true. Empty for exact matches.
+	Detail string
+	// LengthMismatch is true when the buffers were not compared because their lengths differ too much.
+	LengthMismatch bool
+}
 
+// ComparePCMLossless compares the common prefix of two PCM buffers byte for byte.
+func ComparePCMLossless(expected, actual []byte, bitDepth, channels int) PCMComparison {
 	minLen := min(len(expected), len(actual))
 	differences := 0
 	firstDiff := -1
@@ -152,27 +160,26 @@ func CompareLosslessSamples(t *testing.T, label string, expected, actual []byte,
 		}
 	}
 
-	if differences > 0 {
-		bytesPerSample := PCMBytesPerSample(bitDepth)
-		sampleIndex := firstDiff / bytesPerSample / channels
-		t.Errorf("%s: PCM mismatch: %d differing bytes (%.2f%%), first diff at byte %d (sample %d)",
-			label, differences, float64(differences)/float64(minLen)*lossyLargeDiffPct, firstDiff, sampleIndex)
+	if differences == 0 {
+		return PCMComparison{Match: true}
+	}
 
-		ShowDiffs(t, label, expected, actual, bitDepth, channels, defaultMaxDiffSamples)
+	bytesPerSample := PCMBytesPerSample(bitDepth)
+	sampleIndex := firstDiff / bytesPerSample / channels
+
+	return PCMComparison{
+		Detail: fmt.Sprintf("PCM mismatch: %d differing bytes (%.2f%%), first diff at byte %d (sample %d)",
+			differences, float64(differences)/float64(minLen)*lossyLargeDiffPct, firstDiff, sampleIndex),
 	}
 }
 
-// CompareLossySamples allows small differences between decoders for lossy codecs.
-// Different decoders use different floating-point implementations,
-// resulting in +/-1-2 LSB differences per sample.
-// Length differences up to 1 frame (1152 stereo samples) are tolerated.
-func CompareLossySamples(t *testing.T, pcmA, pcmB []byte, bitDepth, channels int) {
-	t.Helper()
-
+// ComparePCMLossy compares two 16-bit PCM buffers with the tolerance of lossy decoders:
+// different floating-point implementations result in +/-1-2 LSB differences per sample,
+// up to 1% of samples may differ more (codec edge cases), and lengths may differ by up to
+// 1 frame (1152 samples per channel).
+func ComparePCMLossy(pcmA, pcmB []byte, bitDepth, channels int) PCMComparison {
 	if bitDepth != BitDepth16 {
-		t.Errorf("lossy comparison only supports 16-bit, got %d-bit", bitDepth)
-
-		return
+		return PCMComparison{Detail: fmt.Sprintf("lossy comparison only supports 16-bit, got %d-bit", bitDepth)}
 	}
 
 	// Allow length differences up to 1 frame (1152 samples * channels * 2 bytes).
@@ -186,14 +193,17 @@ func CompareLossySamples(t *testing.T, pcmA, pcmB []byte, bitDepth, channels int
 	}
 
 	if lengthDiff > maxLengthDiffBytes {
-		t.Errorf("length mismatch: a=%d, b=%d (diff=%d exceeds tolerance %d)",
-			len(pcmA), len(pcmB), lengthDiff, maxLengthDiffBytes)
-
-		return
+		return PCMComparison{
+			LengthMismatch: true,
+			Detail: fmt.Sprintf("length mismatch: a=%d, b=%d (diff=%d exceeds tolerance %d)",
+				len(pcmA), len(pcmB), lengthDiff, maxLengthDiffBytes),
+		}
 	}
 
+	result := PCMComparison{Match: true}
+
 	if lengthDiff > 0 {
-		t.Logf("length diff: a=%d, b=%d (+/-%d bytes, within tolerance)",
+		result.Detail = fmt.Sprintf("length diff: a=%d, b=%d (+/-%d bytes, within tolerance)",
 			len(pcmA), len(pcmB), lengthDiff)
 	}
 
@@ -229,9 +239,43 @@ func CompareLossySamples(t *testing.T, pcmA, pcmB []byte, bitDepth, channels int
 	// Allow up to 1% of samples to have larger differences (codec edge cases).
 	maxLargeDiffs := numSamples / lossyLargeDiffPct
 	if largeDiffs > maxLargeDiffs {
-		t.Errorf("lossy PCM mismatch: %d samples (%.2f%%) differ by more than +/-%d, max diff=%d",
-			largeDiffs, float64(largeDiffs)/float64(numSamples)*lossyLargeDiffPct, maxDiffPerSample, maxDiff)
-		ShowDiffs(t, "lossy comparison", pcmA, pcmB, bitDepth, channels, defaultMaxDiffSamples)
+		return PCMComparison{
+			Detail: fmt.Sprintf("lossy PCM mismatch: %d samples (%.2f%%) differ by more than +/-%d, max diff=%d",
+				largeDiffs, float64(largeDiffs)/float64(numSamples)*lossyLargeDiffPct, maxDiffPerSample, maxDiff),
+		}
+	}
+
+	return result
+}
+
+// CompareLosslessSamples requires exact byte match for lossless codecs.
+// The label identifies which comparison is being made (e.g. "saprobe vs ffmpeg").
+func CompareLosslessSamples(t *testing.T, label string, expected, actual []byte, bitDepth, channels int) {
+	t.Helper()
+
+	if result := ComparePCMLossless(expected, actual, bitDepth, channels); !result.Match {
+		t.Errorf("%s: %s", label, result.Detail)
+
+		ShowDiffs(t, label, expected, actual, bitDepth, channels, defaultMaxDiffSamples)
+	}
+}
+
+// CompareLossySamples allows small differences between decoders for lossy codecs,
+// with the tolerances of ComparePCMLossy.
+func CompareLossySamples(t *testing.T, pcmA, pcmB []byte, bitDepth, channels int) {
+	t.Helper()
+
+	result := ComparePCMLossy(pcmA, pcmB, bitDepth, channels)
+
+	switch {
+	case !result.Match:
+		t.Error(result.Detail)
+
+		if bitDepth == BitDepth16 && !result.LengthMismatch {
+			ShowDiffs(t, "lossy comparison", pcmA, pcmB, bitDepth, channels, defaultMaxDiffSamples)
+		}
+	case result.Detail != "":
+		t.Log(result.Detail)
 	}
 }
 
@@ -0,0 +1,366 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+)
+
+// Picard key prefix for MP4 freeform atoms, and the UFID owner of MusicBrainz recording IDs.
+const (
+	picardMP4Freeform = "----:"
+	musicBrainzOwner  = "http://musicbrainz.org"
+)
+
+// PicardTag maps one semantic tag name to its MusicBrainz Picard key in Vorbis comments, ID3v2.4 and MP4.
+// See https://picard-docs.musicbrainz.org/downloads/MusicBrainz_Picard_Tag_Map.html.
+type PicardTag struct {
+	// Semantic is the name used in ParsedTags.Text.
+	Semantic string
+	// Vorbis is the Vorbis comment field name (FLAC, Ogg Vorbis, Opus).
+	Vorbis string
+	// ID3 is the ID3v2.4 frame: a text frame ID, "TXXX:description" or "UFID:owner".
+	ID3 string
+	// MP4 is the iTunes atom, or "----:name" for a com.apple.iTunes freeform atom.
+	MP4 string
+}
+
+// PicardTagMap returns the Picard tag map used by the Picard fixtures.
+// AtomicParsley cannot write ©wrk, ©mvn and mvi, so work and movement use freeform atoms
+// in MP4, which the MP4 parser maps back to the same semantic names.
+func PicardTagMap() []PicardTag {
+	return []PicardTag{
+		{Semantic: "title", Vorbis: "TITLE", ID3: "TIT2", MP4: "©nam"},
+		{Semantic: "artist", Vorbis: "ARTIST", ID3: "TPE1", MP4: "©ART"},
+		{Semantic: "album", Vorbis: "ALBUM", ID3: "TALB", MP4: "©alb"},
+		{Semantic: "albumartist", Vorbis: "ALBUMARTIST", ID3: "TPE2", MP4: "aART"},
+		{Semantic: "composer", Vorbis: "COMPOSER", ID3: "TCOM", MP4: "©wrt"},
+		{Semantic: "date", Vorbis: "DATE", ID3: "TDRC", MP4: "©day"},
+		{Semantic: "originaldate", Vorbis: "ORIGINALDATE", ID3: "TDOR", MP4: "----:ORIGINALDATE"},
+		{Semantic: "originalyear", Vorbis: "ORIGINALYEAR", ID3: "TXXX:originalyear", MP4: "----:ORIGINALYEAR"},
+		{Semantic: "artistsort", Vorbis: "ARTISTSORT", ID3: "TSOP", MP4: "soar"},
+		{Semantic: "albumartistsort", Vorbis: "ALBUMARTISTSORT", ID3: "TSO2", MP4: "soaa"},
+		{Semantic: "albumsort", Vorbis: "ALBUMSORT", ID3: "TSOA", MP4: "soal"},
+		{Semantic: "titlesort", Vorbis: "TITLESORT", ID3: "TSOT", MP4: "sonm"},
+		{Semantic: "composersort", Vorbis: "COMPOSERSORT", ID3: "TSOC", MP4: "soco"},
+		{Semantic: "artists", Vorbis: "ARTISTS", ID3: "TXXX:ARTISTS", MP4: "----:ARTISTS"},
+		{
+			Semantic: "releasetype", Vorbis: "RELEASETYPE",
+			ID3: "TXXX:MusicBrainz Album Type", MP4: "----:MusicBrainz Album Type",
+		},
+		{
+			Semantic: "releasestatus", Vorbis: "RELEASESTATUS",
+			ID3: "TXXX:MusicBrainz Album Status", MP4: "----:MusicBrainz Album Status",
+		},
+		{
+			Semantic: "releasecountry", Vorbis: "RELEASECOUNTRY",
+			ID3: "TXXX:MusicBrainz Album Release Country", MP4: "----:MusicBrainz Album Release Country",
+		},
+		{Semantic: "isrc", Vorbis: "ISRC", ID3: "TSRC", MP4: "----:ISRC"},
+		{Semantic: "barcode", Vorbis: "BARCODE", ID3: "TXXX:BARCODE", MP4: "----:BARCODE"},
+		{Semantic: "catalognumber", Vorbis: "CATALOGNUMBER", ID3: "TXXX:CATALOGNUMBER", MP4: "----:CATALOGNUMBER"},
+		{Semantic: "label", Vorbis: "LABEL", ID3: "TPUB", MP4: "----:LABEL"},
+		{Semantic: "asin", Vorbis: "ASIN", ID3: "TXXX:ASIN", MP4: "----:ASIN"},
+		{Semantic: "media", Vorbis: "MEDIA", ID3: "TMED", MP4: "----:MEDIA"},
+		{Semantic: "script", Vorbis: "SCRIPT", ID3: "TXXX:SCRIPT", MP4: "----:SCRIPT"},
+		{Semantic: "language", Vorbis: "LANGUAGE", ID3: "TLAN", MP4: "----:LANGUAGE"},
+		{Semantic: "work", Vorbis: "WORK", ID3: "TXXX:WORK", MP4: "----:WORK"},
+		{Semantic: "movement", Vorbis: "MOVEMENTNAME", ID3: "MVNM", MP4: "----:MOVEMENTNAME"},
+		{Semantic: "movementnumber", Vorbis: "MOVEMENT", ID3: "MVIN", MP4: "----:MOVEMENT"},
+		{
+			Semantic: "musicbrainz_recordingid", Vorbis: "MUSICBRAINZ_TRACKID",
+			ID3: "UFID:" + musicBrainzOwner, MP4: "----:MusicBrainz Track Id",
+		},
+		{
+			Semantic: "musicbrainz_releasetrackid", Vorbis: "MUSICBRAINZ_RELEASETRACKID",
+			ID3: "TXXX:MusicBrainz Release Track Id", MP4: "----:MusicBrainz Release Track Id",
+		},
+		{
+			Semantic: "musicbrainz_albumid", Vorbis: "MUSICBRAINZ_ALBUMID",
+			ID3: "TXXX:MusicBrainz Album Id", MP4: "----:MusicBrainz Album Id",
+		},
+		{
+			Semantic: "musicbrainz_artistid", Vorbis: "MUSICBRAINZ_ARTISTID",
+			ID3: "TXXX:MusicBrainz Artist Id", MP4: "----:MusicBrainz Artist Id",
+		},
+		{
+			Semantic: "musicbrainz_albumartistid", Vorbis: "MUSICBRAINZ_ALBUMARTISTID",
+			ID3: "TXXX:MusicBrainz Album Artist Id", MP4: "----:MusicBrainz Album Artist Id",
+		},
+		{
+			Semantic: "musicbrainz_releasegroupid", Vorbis: "MUSICBRAINZ_RELEASEGROUPID",
+			ID3: "TXXX:MusicBrainz Release Group Id", MP4: "----:MusicBrainz Release Group Id",
+		},
+		{
+			Semantic: "musicbrainz_workid", Vorbis: "MUSICBRAINZ_WORKID",
+			ID3: "TXXX:MusicBrainz Work Id", MP4: "----:MusicBrainz Work Id",
+		},
+		{Semantic: "acoustid_id", Vorbis: "ACOUSTID_ID", ID3: "TXXX:Acoustid Id", MP4: "----:Acoustid Id"},
+	}
+}
+
+// DefaultPicardTags returns the standard Picard test metadata, keyed by semantic name.
+func DefaultPicardTags() map[string]string {
+	return map[string]string{
+		"title":                      "Test Title",
+		"artist":                     "Test Artist feat. Guest Artist",
+		"album":                      "Test Album",
+		"albumartist":                "Test AlbumArtist",
+		"composer":                   "Test Composer",
+		"date":                       "2000-01-01",
+		"originaldate":               "1999-12-31",
+		"originalyear":               "1999",
+		"artistsort":                 "Artist, Test feat. Artist, Guest",
+		"albumartistsort":            "AlbumArtist, Test",
+		"albumsort":                  "Album, Test",
+		"titlesort":                  "Title, Test",
+		"composersort":               "Composer, Test",
+		"artists":                    "Test Artist",
+		"releasetype":                "album",
+		"releasestatus":              "official",
+		"releasecountry":             "XW",
+		"isrc":                       "USRC17607839",
+		"barcode":                    "012345678905",
+		"catalognumber":              "CAT-0001",
+		"label":                      "Test Label",
+		"asin":                       "B000000000",
+		"media":                      "Digital Media",
+		"script":                     "Latn",
+		"language":                   "eng",
+		"work":                       "Test Work",
+		"movement":                   "Test Movement",
+		"movementnumber":             "2",
+		"musicbrainz_recordingid":    "12345678-1234-1234-1234-123456789012",
+		"musicbrainz_releasetrackid": "22345678-1234-1234-1234-123456789012",
+		"musicbrainz_albumid":        "abcdefab-abcd-abcd-abcd-abcdefabcdef",
+		"musicbrainz_artistid":       "11111111-2222-3333-4444-555555555555",
+		"musicbrainz_albumartistid":  "21111111-2222-3333-4444-555555555555",
+		"musicbrainz_releasegroupid": "31111111-2222-3333-4444-555555555555",
+		"musicbrainz_workid":         "41111111-2222-3333-4444-555555555555",
+		"acoustid_id":                "51111111-2222-3333-4444-555555555555",
+	}
+}
+
+// picardVorbisComments returns values as "FIELD=value" comments, in PicardTagMap order.
+func picardVorbisComments(values map[string]string) []string {
+	var comments []string
+
+	for _, tag := range PicardTagMap() {
+		if value, ok := values[tag.Semantic]; ok {
+			comments = append(comments, tag.Vorbis+"="+value)
+		}
+	}
+
+	return comments
+}
+
+// FLACSetPicardTags replaces all Vorbis comments of a FLAC file with values (keyed by semantic name)
+// using metaflac.
+func FLACSetPicardTags(helpers test.Helpers, path string, values map[string]string) {
+	helpers.T().Helper()
+
+	args := []string{"--remove-all-tags"}
+	for _, comment := range picardVorbisComments(values) {
+		args = append(args, "--set-tag="+comment)
+	}
+
+	mf := lookForOrFail(helpers.T(), metaflacBinary)
+	helpers.Custom(mf, append(args, path)...).Run(&test.Expected{})
+}
+
+// OggSetPicardTags replaces all Vorbis comments of an Ogg Vorbis file with values using vorbiscomment.
+func OggSetPicardTags(helpers test.Helpers, path string, values map[string]string) {
+	helpers.T().Helper()
+
+	args := []string{"-w"}
+	for _, comment := range picardVorbisComments(values) {
+		args = append(args, vorbisTagFlag, comment)
+	}
+
+	vc := lookForOrFail(helpers.T(), vorbiscommentBinary)
+	helpers.Custom(vc, append(args, path)...).Run(&test.Expected{})
+}
+
+// OpusSetPicardTags replaces all comments of an Opus file with values using opustags.
+func OpusSetPicardTags(helpers test.Helpers, path string, values map[string]string) {
+	helpers.T().Helper()
+
+	args := []string{"--in-place", "--delete-all"}
+	for _, comment := range picardVorbisComments(values) {
+		args = append(args, "--add", comment)
+	}
+
+	ot := lookForOrFail(helpers.T(), opustagsBinary)
+	helpers.Custom(ot, append(args, path)...).Run(&test.Expected{})
+}
+
+// MP3SetPicardTags replaces the ID3v2 tag of an MP3 file with a native ID3v2.4 tag holding values.
+// MusicBrainz identifiers go to TXXX frames, except the recording ID which goes to UFID.
+func MP3SetPicardTags(helpers test.Helpers, path string, values map[string]string) {
+	helpers.T().Helper()
+
+	MP3WriteID3v2Tag(helpers, path, EncodeID3v2(ID3v24, picardID3Frames(values)))
+}
+
+// picardID3Frames returns values as ID3v2.4 frames, in PicardTagMap order.
+func picardID3Frames(values map[string]string) []ID3Frame {
+	var frames []ID3Frame
+
+	for _, tag := range PicardTagMap() {
+		value, ok := values[tag.Semantic]
+		if !ok {
+			continue
+		}
+
+		kind, name, _ := strings.Cut(tag.ID3, ":")
+
+		switch kind {
+		case "TXXX":
+			frames = append(frames, ID3UserTextFrame(ID3v24, name, value))
+		case "UFID":
+			frames = append(frames, ID3UniqueFileIDFrame(ID3v24, name, value))
+		default:
+			frames = append(frames, ID3TextFrame(ID3v24, tag.ID3, value))
+		}
+	}
+
+	return frames
+}
+
+// mp4AtomicParsleyArgs maps the standard atoms of PicardTagMap to AtomicParsley arguments
+// (the value follows them).
+//
+//nolint:gochecknoglobals // lookup table
+var mp4AtomicParsleyArgs = map[string][]string{
+	"©nam": {"--title"},
+	"©ART": {"--artist"},
+	"©alb": {"--album"},
+	"aART": {"--albumArtist"},
+	"©wrt": {"--composer"},
+	"©day": {"--year"},
+	"soar": {"--sortOrder", "artist"},
+	"soaa": {"--sortOrder", "albumartist"},
+	"soal": {"--sortOrder", "album"},
+	"sonm": {"--sortOrder", "name"},
+	"soco": {"--sortOrder", "composer"},
+}
+
+// MP4SetPicardTags removes all metadata of an MP4 file and writes values with a single AtomicParsley run.
+func MP4SetPicardTags(helpers test.Helpers, path string, values map[string]string) {
+	helpers.T().Helper()
+
+	MP4RemoveAllTags(helpers, path)
+
+	args := []string{path}
+
+	for _, tag := range PicardTagMap() {
+		value, ok := values[tag.Semantic]
+		if !ok {
+			continue
+		}
+
+		if name, ok := strings.CutPrefix(tag.MP4, picardMP4Freeform); ok {
+			args = append(args, "--rDNSatom", value, "name="+name, "domain="+iTunesDomain)
+
+			continue
+		}
+
+		args = append(args, mp4AtomicParsleyArgs[tag.MP4]...)
+		args = append(args, value)
+	}
+
+	ap := lookForOrFail(helpers.T(), atomicParsleyBinary)
+	helpers.Custom(ap, append(args, "--overWrite")...).Run(&test.Expected{})
+}
+
+// PicardFLAC returns path to FLAC with the default Picard tags.
+func PicardFLAC(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	path := generate(helpers, filepath.Join(data.Temp().Dir(), "picard.flac"), []string{
+		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + shortDuration,
+		"-af", "pan=stereo|c0=c0|c1=c0,volume=-6dB",
+		"-ar", "44100", "-sample_fmt", "s16",
+	})
+
+	FLACSetPicardTags(helpers, path, DefaultPicardTags())
+
+	return path
+}
+
+// PicardOggVorbis returns path to Ogg Vorbis with the default Picard tags.
+func PicardOggVorbis(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	path := generate(helpers, filepath.Join(data.Temp().Dir(), "picard.ogg"), []string{
+		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + shortDuration,
+		"-af", "pan=stereo|c0=c0|c1=c0,volume=-6dB",
+		"-ar", "44100", "-c:a", "libvorbis", "-q:a", "6",
+	})
+
+	OggSetPicardTags(helpers, path, DefaultPicardTags())
+
+	return path
+}
+
+// PicardOpus returns path to Opus with the default Picard tags.
+func PicardOpus(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	path := generate(helpers, filepath.Join(data.Temp().Dir(), "picard.opus"), []string{
+		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + shortDuration,
+		"-af", "pan=stereo|c0=c0|c1=c0,volume=-6dB",
+		"-ar", "48000", "-c:a", "libopus", "-b:a", "128k",
+	})
+
+	OpusSetPicardTags(helpers, path, DefaultPicardTags())
+
+	return path
+}
+
+// PicardMP3 returns path to MP3 with the default Picard tags in ID3v2.4.
+func PicardMP3(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	path := generate(helpers, filepath.Join(data.Temp().Dir(), "picard.mp3"), []string{
+		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + shortDuration,
+		"-af", "pan=stereo|c0=c0|c1=c0,volume=-6dB",
+		"-ar", "44100", "-c:a", "libmp3lame", "-b:a", "256k",
+	})
+
+	MP3SetPicardTags(helpers, path, DefaultPicardTags())
+
+	return path
+}
+
+// PicardMP4 returns path to AAC in MP4 with the default Picard tags.
+func PicardMP4(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	path := generate(helpers, filepath.Join(data.Temp().Dir(), "picard.m4a"), []string{
+		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + shortDuration,
+		"-af", "pan=stereo|c0=c0|c1=c0,volume=-6dB",
+		"-ar", "44100", "-c:a", "aac", "-b:a", "256k",
+	})
+
+	MP4SetPicardTags(helpers, path, DefaultPicardTags())
+
+	return path
+}
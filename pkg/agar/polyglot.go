@@ -0,0 +1,248 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+	"github.com/containerd/nerdctl/mod/tigron/tig"
+)
+
+// ContainerADTS identifies a raw ADTS AAC stream. sniffContainer does not distinguish it from MPEG audio.
+const ContainerADTS = "adts"
+
+// ffprobeScoreMax is AVPROBE_SCORE_MAX: ffprobe is certain of the format.
+const ffprobeScoreMax = 100
+
+// Polyglot selects a file that plausibly matches more than one format. Its value is the fixture file stem.
+type Polyglot string
+
+// Polyglot files. Each documents its correct interpretation in Truth.
+const (
+	// PolyglotID3FLAC is an ID3v2.4 tag followed by a complete FLAC stream.
+	PolyglotID3FLAC Polyglot = "id3-flac"
+	// PolyglotWAVDataFLAC is a 16-bit stereo WAV whose data chunk holds the bytes of a FLAC file,
+	// so that "fLaC" appears at offset 44.
+	PolyglotWAVDataFLAC Polyglot = "wav-data-flac"
+	// PolyglotMP3WithOggS is an MP3 whose leading ID3v2 tag carries a PRIV frame holding a complete Ogg Vorbis
+	// identification page, so that "OggS" appears within the first KB.
+	PolyglotMP3WithOggS Polyglot = "mp3-with-oggs"
+	// PolyglotID3MP4 is an ID3v2.4 tag followed by an M4A. Chunk offsets are those of the M4A without the tag.
+	PolyglotID3MP4 Polyglot = "id3-mp4"
+	// PolyglotADTSNaiveSync is an ADTS AAC stream. Its 0xFFF1 sync word also passes the 11-bit MPEG audio
+	// frame sync that naive scanners search for, although the layer bits are the reserved value.
+	PolyglotADTSNaiveSync Polyglot = "adts-naive-sync"
+)
+
+// PolyglotTruth is the correct interpretation of a polyglot file.
+type PolyglotTruth struct {
+	// Container is a PayloadFormat constant, ContainerRIFF or ContainerADTS.
+	Container string
+	// FFProbeFormat is the format_name ffprobe reports for the correct interpretation.
+	FFProbeFormat string
+	// Reason explains why this interpretation is the correct one.
+	Reason string
+}
+
+// Truth returns the documented correct interpretation of the polyglot.
+func (p Polyglot) Truth() PolyglotTruth {
+	switch p {
+	case PolyglotID3FLAC:
+		return PolyglotTruth{
+			Container:     PayloadFormatFLAC,
+			FFProbeFormat: "flac",
+			Reason:        "an ID3v2 tag is skipped before sniffing; the stream after it starts with fLaC",
+		}
+	case PolyglotWAVDataFLAC:
+		return PolyglotTruth{
+			Container:     ContainerRIFF,
+			FFProbeFormat: "wav",
+			Reason:        "the RIFF/WAVE header at offset 0 wins; data chunk content is opaque PCM",
+		}
+	case PolyglotMP3WithOggS:
+		return PolyglotTruth{
+			Container:     PayloadFormatMPEG,
+			FFProbeFormat: "mp3",
+			Reason:        "OggS only appears inside an ID3v2 frame; the data after the tag is MPEG audio",
+		}
+	case PolyglotID3MP4:
+		return PolyglotTruth{
+			Container:     PayloadFormatMP4,
+			FFProbeFormat: "mov,mp4,m4a,3gp,3g2,mj2",
+			Reason:        "an ID3v2 tag is skipped before sniffing; the data after it starts with an ftyp box",
+		}
+	case PolyglotADTSNaiveSync:
+		return PolyglotTruth{
+			Container:     ContainerADTS,
+			FFProbeFormat: "aac",
+			Reason:        "the 12-bit ADTS sync and layer 0 rule out MPEG audio, whose layer bits 00 are reserved",
+		}
+	default:
+		return PolyglotTruth{}
+	}
+}
+
+// Extension returns the file extension of the correct interpretation, with the leading dot.
+func (p Polyglot) Extension() string {
+	switch p.Truth().Container {
+	case PayloadFormatFLAC:
+		return ".flac"
+	case ContainerRIFF:
+		return ".wav"
+	case PayloadFormatMPEG:
+		return ".mp3"
+	case PayloadFormatMP4:
+		return ".m4a"
+	case ContainerADTS:
+		return ".aac"
+	default:
+		return ".bin"
+	}
+}
+
+// PolyglotFixture returns path to the given polyglot file, named with the extension of its correct
+// interpretation. Combine with FSConditionFixture and FSWrongExtension or FSNoExtension to take the extension
+// out of the equation.
+func PolyglotFixture(data test.Data, helpers test.Helpers, polyglot Polyglot) string {
+	helpers.T().Helper()
+
+	dir := data.Temp().Dir("polyglot")
+
+	var content []byte
+
+	switch polyglot {
+	case PolyglotID3FLAC:
+		content = append(conflictingID3Tag(), id3FLACSource(data, helpers)...)
+	case PolyglotWAVDataFLAC:
+		flac := id3FLACSource(data, helpers)
+		format := DefaultWAVFormat()
+		pcm := flac[:len(flac)/format.BlockAlign()*format.BlockAlign()]
+		file, err := BuildWAV(format, pcm, WAVWellFormed, WAVChunksNone)
+		if err != nil {
+			helpers.T().Log(err.Error())
+			helpers.T().FailNow()
+		}
+
+		content = file.Bytes()
+	case PolyglotMP3WithOggS:
+		mp3 := readFixtureFile(helpers.T(), UntaggedMP3(data, helpers))
+		page := encodeOggPage(bombOggSerial, 0, OggPageBOS, 0, vorbisIdentificationHeader(bombChannels, bombSampleRate))
+		tag := EncodeID3v2(ID3v24, []ID3Frame{
+			ID3TextFrame(ID3v24, "TIT2", "Test Title"),
+			{ID: "PRIV", Data: append([]byte("agar\x00"), page...)},
+		})
+		content = append(tag, mp3[leadingID3v2Size(mp3):]...)
+	case PolyglotID3MP4:
+		m4a := generate(helpers, filepath.Join(dir, "source.m4a"), []string{
+			"-f", "lavfi", "-i", "sine=frequency=440:duration=" + shortDuration,
+			"-ar", "44100", "-c:a", "aac", "-b:a", "128k", "-map_metadata", "-1",
+		})
+		content = append(conflictingID3Tag(), readFixtureFile(helpers.T(), m4a)...)
+	case PolyglotADTSNaiveSync:
+		adts := generate(helpers, filepath.Join(dir, "source.aac"), []string{
+			"-f", "lavfi", "-i", "sine=frequency=440:duration=" + shortDuration,
+			"-ar", "44100", "-c:a", "aac", "-b:a", "128k", "-f", "adts",
+		})
+		content = readFixtureFile(helpers.T(), adts)
+	default:
+		helpers.T().Log("unknown polyglot " + string(polyglot))
+		helpers.T().FailNow()
+	}
+
+	path := filepath.Join(dir, string(polyglot)+polyglot.Extension())
+	writeFixtureFile(helpers.T(), path, content)
+
+	return path
+}
+
+// PolyglotProbe is the verdict of the binary under test on a polyglot file, alongside ffprobe's.
+type PolyglotProbe struct {
+	Polyglot Polyglot
+	Truth    PolyglotTruth
+	// Detected is the container the binary under test reported, mapped by the caller to Truth.Container's
+	// vocabulary.
+	Detected string
+	// Score is the binary under test's confidence on ffprobe's scale (0-100), or -1 when it has none.
+	Score int
+	// FFProbeFormat and FFProbeScore are ffprobe's format_name and probe_score.
+	FFProbeFormat string
+	FFProbeScore  int
+}
+
+// Correct reports whether the binary under test detected the documented interpretation.
+func (p PolyglotProbe) Correct() bool {
+	return p.Detected == p.Truth.Container
+}
+
+// FFProbeCorrect reports whether ffprobe detected the documented interpretation.
+func (p PolyglotProbe) FFProbeCorrect() bool {
+	return p.FFProbeFormat == p.Truth.FFProbeFormat
+}
+
+// Overconfident reports whether the binary under test claims more certainty than ffprobe on the same file.
+func (p PolyglotProbe) Overconfident() bool {
+	return p.Score >= 0 && p.Score > p.FFProbeScore
+}
+
+// String describes both verdicts on one line.
+func (p PolyglotProbe) String() string {
+	return fmt.Sprintf("%s: detected %s (score %d), ffprobe %s (score %d/%d), expected %s: %s",
+		p.Polyglot, p.Detected, p.Score, p.FFProbeFormat, p.FFProbeScore, ffprobeScoreMax,
+		p.Truth.Container, p.Truth.Reason)
+}
+
+// ProbePolyglot runs ffprobe on the polyglot at path and pairs its verdict with the one of the binary under test.
+func ProbePolyglot(path string, polyglot Polyglot, detected string, score int) (PolyglotProbe, error) {
+	probe := PolyglotProbe{Polyglot: polyglot, Truth: polyglot.Truth(), Detected: detected, Score: score}
+
+	result, err := FFProbe(path)
+	if err != nil {
+		return probe, err
+	}
+
+	probe.FFProbeFormat = result.Format.FormatName
+	probe.FFProbeScore = result.Format.ProbeScore
+
+	return probe, nil
+}
+
+// RequirePolyglotDetected fails the test unless the binary under test detected the documented interpretation
+// of the polyglot at path without claiming more confidence than ffprobe. Pass a score of -1 when the binary
+// reports none. ffprobe disagreeing with the documented interpretation is logged, not failed.
+func RequirePolyglotDetected(helper tig.T, path string, polyglot Polyglot, detected string, score int) PolyglotProbe {
+	helper.Helper()
+
+	probe, err := ProbePolyglot(path, polyglot, detected, score)
+	if err != nil {
+		helper.Log(err.Error())
+		helper.FailNow()
+	}
+
+	helper.Log(probe.String())
+
+	if !probe.FFProbeCorrect() {
+		helper.Log("note: ffprobe does not follow the documented interpretation")
+	}
+
+	if !probe.Correct() || probe.Overconfident() {
+		helper.FailNow()
+	}
+
+	return probe
+}
@@ -0,0 +1,329 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNotRIFF is returned when data does not start with a RIFF header.
+var ErrNotRIFF = errors.New("not a RIFF container")
+
+// RIFF layout constants.
+const (
+	riffChunkHeaderSize = 8
+	fourCCSize          = 4
+	formHeaderSize      = riffChunkHeaderSize + fourCCSize
+)
+
+// RIFFChunk is a single chunk inside a RIFF container.
+// The zero value of the override fields produces a spec-conformant chunk.
+type RIFFChunk struct {
+	// ID is the four-character chunk identifier (e.g. "fmt ", "data").
+	ID string
+	// Data is the chunk payload, excluding header and pad byte.
+	Data []byte
+	// OverrideSize writes DeclaredSize in the chunk header instead of len(Data).
+	OverrideSize bool
+	// DeclaredSize is the size field written when OverrideSize is set.
+	DeclaredSize uint32
+	// OmitPad skips the pad byte that normally follows an odd-sized payload.
+	OmitPad bool
+}
+
+// RIFFFile describes a RIFF container: a form type and an ordered list of chunks.
+type RIFFFile struct {
+	// FormType is the four-character form identifier (e.g. "WAVE").
+	FormType string
+	// Chunks are written in order, exactly as given.
+	Chunks []RIFFChunk
+	// OverrideSize writes DeclaredSize in the RIFF header instead of the actual size.
+	OverrideSize bool
+	// DeclaredSize is the RIFF size field written when OverrideSize is set.
+	DeclaredSize uint32
+}
+
+// Bytes serializes the container.
+func (f RIFFFile) Bytes() []byte {
+	return encodeContainer("RIFF", f.FormType, f.Chunks, f.OverrideSize, f.DeclaredSize, binary.LittleEndian)
+}
+
+// Bytes serializes the chunk: header, payload and (unless omitted) pad byte.
+func (c RIFFChunk) Bytes() []byte {
+	return c.encode(binary.LittleEndian)
+}
+
+// encode serializes the chunk with sizes in the given byte order.
+func (c RIFFChunk) encode(order binary.ByteOrder) []byte {
+	size := uint32(len(c.Data)) //nolint:gosec // G115: fixtures are far below 4 GB.
+	if c.OverrideSize {
+		size = c.DeclaredSize
+	}
+
+	out := make([]byte, riffChunkHeaderSize, riffChunkHeaderSize+len(c.Data)+1)
+	copy(out, padFourCC(c.ID))
+	order.PutUint32(out[fourCCSize:], size)
+	out = append(out, c.Data...)
+
+	if len(c.Data)%2 == 1 && !c.OmitPad {
+		out = append(out, 0)
+	}
+
+	return out
+}
+
+// encodeContainer serializes a top-level container chunk (RIFF or FORM) holding a form type and chunks.
+func encodeContainer(
+	id, formType string,
+	chunks []RIFFChunk,
+	overrideSize bool,
+	declaredSize uint32,
+	order binary.ByteOrder,
+) []byte {
+	body := []byte(padFourCC(formType))
+
+	for _, chunk := range chunks {
+		body = append(body, chunk.encode(order)...)
+	}
+
+	size := uint32(len(body)) //nolint:gosec // G115: fixtures are far below 4 GB.
+	if overrideSize {
+		size = declaredSize
+	}
+
+	out := make([]byte, riffChunkHeaderSize, riffChunkHeaderSize+len(body))
+	copy(out, id)
+	order.PutUint32(out[fourCCSize:], size)
+
+	return append(out, body...)
+}
+
+// RIFFListChunk builds a LIST chunk of the given list type (e.g. "INFO") from sub-chunks.
+func RIFFListChunk(listType string, subChunks []RIFFChunk) RIFFChunk {
+	data := []byte(padFourCC(listType))
+	for _, sub := range subChunks {
+		data = append(data, sub.Bytes()...)
+	}
+
+	return RIFFChunk{ID: "LIST", Data: data}
+}
+
+// padFourCC truncates or space-pads an identifier to four characters.
+func padFourCC(id string) string {
+	out := []byte("    ")
+	copy(out, id)
+
+	return string(out)
+}
+
+// ReadRIFF parses the top-level chunks of a RIFF container.
+// Parsing is lenient: a chunk whose declared size runs past the end of data is returned
+// with the available bytes, its header size in DeclaredSize and OverrideSize set.
+// The same applies to the container's own size field.
+func ReadRIFF(data []byte) (RIFFFile, error) {
+	formType, chunks, declared, override, ok := decodeContainer(data, "RIFF", binary.LittleEndian)
+	if !ok {
+		return RIFFFile{}, ErrNotRIFF
+	}
+
+	return RIFFFile{FormType: formType, Chunks: chunks, DeclaredSize: declared, OverrideSize: override}, nil
+}
+
+// decodeContainer parses a top-level container chunk (RIFF or FORM).
+// ok is false when data does not start with the expected container identifier.
+func decodeContainer(
+	data []byte,
+	id string,
+	order binary.ByteOrder,
+) (formType string, chunks []RIFFChunk, declared uint32, override, ok bool) {
+	if len(data) < riffChunkHeaderSize+fourCCSize || string(data[:fourCCSize]) != id {
+		return "", nil, 0, false, false
+	}
+
+	declared = order.Uint32(data[fourCCSize:])
+	override = int64(declared) != int64(len(data)-riffChunkHeaderSize)
+	formType = string(data[riffChunkHeaderSize : riffChunkHeaderSize+fourCCSize])
+	chunks = readChunkList(data[riffChunkHeaderSize+fourCCSize:], order)
+
+	return formType, chunks, declared, override, true
+}
+
+// Chunk returns the first chunk with the given identifier.
+func (f RIFFFile) Chunk(id string) (RIFFChunk, bool) {
+	return findChunk(f.Chunks, id)
+}
+
+// ListChunk returns the sub-chunks of the first LIST chunk of the given list type (e.g. "INFO").
+func (f RIFFFile) ListChunk(listType string) ([]RIFFChunk, bool) {
+	for _, chunk := range f.Chunks {
+		if chunk.ID != "LIST" || len(chunk.Data) < fourCCSize {
+			continue
+		}
+
+		if string(chunk.Data[:fourCCSize]) == padFourCC(listType) {
+			return readChunkList(chunk.Data[fourCCSize:], binary.LittleEndian), true
+		}
+	}
+
+	return nil, false
+}
+
+// findChunk returns the first chunk with the given identifier.
+func findChunk(chunks []RIFFChunk, id string) (RIFFChunk, bool) {
+	for _, chunk := range chunks {
+		if chunk.ID == padFourCC(id) {
+			return chunk, true
+		}
+	}
+
+	return RIFFChunk{}, false
+}
+
+// readChunkList parses consecutive chunks (four-character ID, 32-bit size, payload, pad to even).
+// RIFF uses little-endian sizes, AIFF big-endian.
+func readChunkList(data []byte, order binary.ByteOrder) []RIFFChunk {
+	layouts := walkChunkList(data, 0, order)
+	chunks := make([]RIFFChunk, 0, len(layouts))
+
+	for _, layout := range layouts {
+		chunks = append(chunks, RIFFChunk{
+			ID:           layout.ID,
+			Data:         layout.Data,
+			DeclaredSize: layout.DeclaredSize,
+			OverrideSize: int64(layout.DeclaredSize) != int64(len(layout.Data)),
+		})
+	}
+
+	return chunks
+}
+
+// ChunkLayout is a chunk of a RIFF or AIFF container located in its file.
+type ChunkLayout struct {
+	// ID is the four-character chunk identifier.
+	ID string
+	// Offset is the file offset of the chunk header.
+	Offset int
+	// DeclaredSize is the size field of the chunk header.
+	DeclaredSize uint32
+	// Data is the payload, cut short when DeclaredSize runs past the end of the file.
+	Data []byte
+	// Padded is set when a pad byte follows an odd-sized payload. An odd-sized chunk that is not last and not
+	// padded lacks the pad byte the specification requires.
+	Padded bool
+	// Children are the sub-chunks of a RIFF LIST chunk, after its list type.
+	Children []ChunkLayout
+}
+
+// Truncated reports whether the declared size runs past the end of the file.
+func (c ChunkLayout) Truncated() bool {
+	return int64(c.DeclaredSize) > int64(len(c.Data))
+}
+
+// String renders the chunk and its sub-chunks as an indented tree, one chunk per line.
+func (c ChunkLayout) String() string {
+	var out strings.Builder
+
+	c.dump(&out, 0)
+
+	return strings.TrimSuffix(out.String(), "\n")
+}
+
+// WalkRIFFChunks locates the top-level chunks of a RIFF container, and the sub-chunks of its LIST chunks.
+func WalkRIFFChunks(data []byte) ([]ChunkLayout, error) {
+	if len(data) < formHeaderSize || string(data[:fourCCSize]) != "RIFF" {
+		return nil, ErrNotRIFF
+	}
+
+	return walkChunkList(data[formHeaderSize:], formHeaderSize, binary.LittleEndian), nil
+}
+
+// dump writes the chunk at the given depth, then its sub-chunks.
+func (c ChunkLayout) dump(out *strings.Builder, depth int) {
+	fmt.Fprintf(out, "%s%q @%d size %d", strings.Repeat("  ", depth), c.ID, c.Offset, c.DeclaredSize)
+
+	if c.Padded {
+		out.WriteString(" padded")
+	}
+
+	if c.Truncated() {
+		fmt.Fprintf(out, " truncated to %d", len(c.Data))
+	}
+
+	out.WriteString("\n")
+
+	for _, child := range c.Children {
+		child.dump(out, depth+1)
+	}
+}
+
+// walkChunkList locates consecutive chunks of data, which starts at file offset base. A size running past the
+// end of data is cut short. An odd-sized payload skips a pad byte, unless the next chunk header only makes
+// sense without it, as written by encoders that forget the padding. RIFF LIST chunks are walked recursively.
+func walkChunkList(data []byte, base int, order binary.ByteOrder) []ChunkLayout {
+	var chunks []ChunkLayout
+
+	for offset := 0; offset+riffChunkHeaderSize <= len(data); {
+		chunk := ChunkLayout{
+			ID:           string(data[offset : offset+fourCCSize]),
+			Offset:       base + offset,
+			DeclaredSize: order.Uint32(data[offset+fourCCSize:]),
+		}
+
+		start := offset + riffChunkHeaderSize
+		end := start + int(chunk.DeclaredSize)
+
+		if end > len(data) || end < start {
+			end = len(data)
+		}
+
+		chunk.Data = data[start:end]
+		chunk.Padded = (end-start)%2 == 1 && end < len(data) &&
+			(plausibleChunkHeader(data, end+1) || !plausibleChunkHeader(data, end))
+
+		if chunk.ID == "LIST" && order == binary.LittleEndian && len(chunk.Data) >= fourCCSize {
+			chunk.Children = walkChunkList(chunk.Data[fourCCSize:], base+start+fourCCSize, order)
+		}
+
+		chunks = append(chunks, chunk)
+
+		offset = end
+		if chunk.Padded {
+			offset++
+		}
+	}
+
+	return chunks
+}
+
+// plausibleChunkHeader reports whether a complete chunk header whose identifier is printable ASCII starts
+// at offset.
+func plausibleChunkHeader(data []byte, offset int) bool {
+	if offset+riffChunkHeaderSize > len(data) {
+		return false
+	}
+
+	for _, char := range data[offset : offset+fourCCSize] {
+		if char < ' ' || char > '~' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -30,8 +30,8 @@ type agarSetup struct {
 	binary string
 }
 
-// CustomCommand returns a command configured with the hypha binary.
-func (hs *agarSetup) CustomCommand(_ *test.Case, _ tig.T) test.CustomizableCommand {
+// CustomCommand returns a command configured with the hypha binary and the case's resource limits.
+func (hs *agarSetup) CustomCommand(testCase *test.Case, helper tig.T) test.CustomizableCommand {
 	cmd := test.NewGenericCommand()
 	cmd.WithBinary(hs.binary)
 
@@ -50,6 +50,8 @@ func (hs *agarSetup) CustomCommand(_ *test.Case, _ tig.T) test.CustomizableComma
 		"PATHEXT",
 	})
 
+	applyLimits(&gen, testCase, helper)
+
 	return &gen
 }
 
@@ -0,0 +1,146 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"testing"
+)
+
+// SoxOptions configures a sox invocation.
+type SoxOptions struct {
+	// Args are passed directly to the sox binary.
+	Args []string
+	// Stdin is connected to the command's standard input when non-nil.
+	Stdin io.Reader
+	// Stdout receives the command's standard output when non-nil.
+	// When nil, stdout is captured and returned in SoxResult.Stdout.
+	Stdout io.Writer
+	// Stderr receives the command's standard error when non-nil.
+	// When nil, stderr is captured and included in the ToolError on failure.
+	Stderr io.Writer
+}
+
+// SoxResult holds captured output from a sox invocation.
+type SoxResult struct {
+	// Stdout contains captured standard output, populated only when
+	// SoxOptions.Stdout was nil.
+	Stdout []byte
+}
+
+// Sox runs sox with the given options.
+// It fatals the test if sox cannot be found or the command returns an error.
+func Sox(t *testing.T, opts SoxOptions) SoxResult {
+	t.Helper()
+
+	result, err := RunSox(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return result
+}
+
+// RunSox runs sox with the given options until it exits or ctx is done.
+// Failures are returned as a *ToolError.
+func RunSox(ctx context.Context, opts SoxOptions) (SoxResult, error) {
+	stdout, err := runTool(ctx, soxBinary, toolOptions(opts))
+
+	return SoxResult{Stdout: stdout}, err
+}
+
+// SoxEncodeOptions configures converting raw PCM to another format with sox.
+type SoxEncodeOptions struct {
+	// Src is the path to the raw little-endian signed PCM input file.
+	Src string
+	// Dst is the path for the output file; sox picks the format from its extension.
+	Dst string
+	// BitDepth of the input PCM (-b).
+	BitDepth int
+	// SampleRate of the input PCM (-r).
+	SampleRate int
+	// Channels in the input PCM (-c).
+	Channels int
+	// Args are optional output format options placed before Dst.
+	// For example: "-b", "24", or "-C", "8" for the FLAC compression level.
+	Args []string
+	// Effects are optional sox effects placed after Dst.
+	// For example: "dither", "-s".
+	Effects []string
+}
+
+// SoxEncode converts a raw PCM file with sox.
+// It fatals the test if sox cannot be found or the command returns an error.
+func SoxEncode(t *testing.T, opts SoxEncodeOptions) {
+	t.Helper()
+
+	args := soxRawArgs(opts.BitDepth)
+	args = append(args, "-r", strconv.Itoa(opts.SampleRate), "-c", strconv.Itoa(opts.Channels), opts.Src)
+	args = append(args, opts.Args...)
+	args = append(args, opts.Dst)
+	args = append(args, opts.Effects...)
+
+	Sox(t, SoxOptions{Args: args})
+}
+
+// SoxDecodeOptions configures decoding an audio file to raw PCM with sox.
+type SoxDecodeOptions struct {
+	// Src is the path to the encoded input file.
+	Src string
+	// BitDepth of the output PCM. sox's automatic dither is disabled, so narrowing truncates.
+	BitDepth int
+	// Channels for the output (-c). Zero preserves the source channel count.
+	Channels int
+	// Stdout receives the decoded PCM. When nil, output is captured and returned as []byte.
+	Stdout io.Writer
+	// Effects are optional sox effects placed after the output pipe.
+	// For example: "rate", "44100".
+	Effects []string
+}
+
+// SoxDecode decodes an audio file to raw little-endian signed PCM.
+// Returns captured PCM bytes when Stdout is nil; returns nil when Stdout is non-nil.
+func SoxDecode(t *testing.T, opts SoxDecodeOptions) []byte {
+	t.Helper()
+
+	result := Sox(t, SoxOptions{
+		Args:   soxDecodeArgs(opts),
+		Stdout: opts.Stdout,
+	})
+
+	return result.Stdout
+}
+
+// soxDecodeArgs returns the sox arguments that decode opts.Src to raw PCM on stdout.
+func soxDecodeArgs(opts SoxDecodeOptions) []string {
+	args := append([]string{"-D", opts.Src}, soxRawArgs(opts.BitDepth)...)
+
+	if opts.Channels > 0 {
+		args = append(args, "-c", strconv.Itoa(opts.Channels))
+	}
+
+	args = append(args, "-")
+
+	return append(args, opts.Effects...)
+}
+
+// soxRawArgs returns the sox format options for raw little-endian signed PCM.
+func soxRawArgs(bitDepth int) []string {
+	return []string{"-t", "raw", "-e", "signed-integer", "-b", strconv.Itoa(bitDepth), "-L"}
+}
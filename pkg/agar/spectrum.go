@@ -0,0 +1,254 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"math"
+	"math/bits"
+	"slices"
+)
+
+// Spectral analysis parameters.
+const (
+	spectrumSize        = 8192
+	spectrumSmoothBins  = 8
+	spectrumFloorShare  = 50 // the top 1/50 of the band is taken as the noise floor
+	spectrumFloorMargin = 10.0
+	spectrumMinDropDB   = 20.0
+	spectrumFullDropDB  = 60.0
+	spectrumPowerFloor  = 1e-30
+	decibelsPerPower    = 10
+)
+
+// fft computes the discrete Fourier transform of data in place. len(data) must be a power of two.
+func fft(data []complex128) {
+	size := len(data)
+	shift := bits.UintSize - bits.Len(uint(size)) + 1
+
+	for idx := range data {
+		rev := int(bits.Reverse(uint(idx)) >> shift) //nolint:gosec // G115: rev < size.
+		if idx < rev {
+			data[idx], data[rev] = data[rev], data[idx]
+		}
+	}
+
+	for width := 2; width <= size; width <<= 1 {
+		sin, cos := math.Sincos(-2 * math.Pi / float64(width))
+		step := complex(cos, sin)
+
+		for start := 0; start < size; start += width {
+			twiddle := complex(1, 0)
+
+			for idx := range width / 2 {
+				even := data[start+idx]
+				odd := data[start+idx+width/2] * twiddle
+				data[start+idx] = even + odd
+				data[start+idx+width/2] = even - odd
+				twiddle *= step
+			}
+		}
+	}
+}
+
+// hannWindow returns a Hann window of the given size.
+func hannWindow(size int) []float64 {
+	window := make([]float64, size)
+	for idx := range window {
+		window[idx] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(idx)/float64(size-1)) //nolint:mnd // Hann coefficients.
+	}
+
+	return window
+}
+
+// spectrogram returns the power spectrum (size/2+1 bins) of every Hann-windowed frame of samples,
+// frames starting every hop samples.
+func spectrogram(samples []float64, size, hop int) [][]float64 {
+	window := hannWindow(size)
+	buffer := make([]complex128, size)
+
+	var frames [][]float64
+
+	for start := 0; start+size <= len(samples); start += hop {
+		for idx := range buffer {
+			buffer[idx] = complex(samples[start+idx]*window[idx], 0)
+		}
+
+		fft(buffer)
+
+		power := make([]float64, size/2+1)
+		for idx := range power {
+			re, im := real(buffer[idx]), imag(buffer[idx])
+			power[idx] = re*re + im*im
+		}
+
+		frames = append(frames, power)
+	}
+
+	return frames
+}
+
+// powerSpectrum returns the Welch average power spectrum of samples (50% overlapping Hann frames).
+// Signals shorter than one frame are zero-padded.
+func powerSpectrum(samples []float64, size int) []float64 {
+	if len(samples) < size {
+		samples = slices.Concat(samples, make([]float64, size-len(samples)))
+	}
+
+	frames := spectrogram(samples, size, size/2)
+	average := make([]float64, size/2+1)
+
+	for _, frame := range frames {
+		for idx, power := range frame {
+			average[idx] += power / float64(len(frames))
+		}
+	}
+
+	return average
+}
+
+// smoothedDB averages power over 2*radius+1 neighbouring bins and converts to decibels.
+func smoothedDB(power []float64, radius int) []float64 {
+	out := make([]float64, len(power))
+
+	for idx := range power {
+		low, high := max(0, idx-radius), min(len(power)-1, idx+radius)
+		sum := 0.0
+
+		for _, value := range power[low : high+1] {
+			sum += value
+		}
+
+		out[idx] = decibels(sum / float64(high-low+1))
+	}
+
+	return out
+}
+
+// decibels converts a power ratio to decibels.
+func decibels(power float64) float64 {
+	return decibelsPerPower * math.Log10(max(power, spectrumPowerFloor))
+}
+
+// bandLevelDB returns the mean level in decibels of the bins between two frequencies.
+func bandLevelDB(levels []float64, sampleRate int, lowHz, highHz float64) float64 {
+	low, high := frequencyBin(lowHz, sampleRate, len(levels)), frequencyBin(highHz, sampleRate, len(levels))
+	if high < low {
+		return math.Inf(-1)
+	}
+
+	sum := 0.0
+	for _, level := range levels[low : high+1] {
+		sum += level
+	}
+
+	return sum / float64(high-low+1)
+}
+
+// frequencyBin maps a frequency to the nearest bin of a spectrum with the given bin count.
+func frequencyBin(hz float64, sampleRate, bins int) int {
+	bin := int(math.Round(hz / (float64(sampleRate) / 2) * float64(bins-1)))
+
+	return min(max(bin, 0), bins-1)
+}
+
+// binFrequency maps a bin of a spectrum with the given bin count to its frequency.
+func binFrequency(bin, sampleRate, bins int) float64 {
+	return float64(bin) * float64(sampleRate) / 2 / float64(bins-1)
+}
+
+// spectralCutoff locates the top of the occupied band in a smoothed spectrum (decibels). The floor is the median
+// level of the top of the band; the cutoff is the highest frequency standing spectrumFloorMargin above it, and the
+// drop is the level just below the cutoff minus the floor. A spectrum with no floor has its cutoff at Nyquist and
+// no drop.
+func spectralCutoff(levels []float64, sampleRate int) (float64, float64) {
+	nyquist := float64(sampleRate) / 2
+	top := levels[len(levels)-max(1, len(levels)/spectrumFloorShare):]
+	floor := median(top)
+
+	cutoff := 0
+	for idx := len(levels) - 1; idx >= 0; idx-- {
+		if levels[idx] > floor+spectrumFloorMargin {
+			cutoff = idx
+
+			break
+		}
+	}
+
+	cutoffHz := binFrequency(cutoff, sampleRate, len(levels))
+
+	//nolint:mnd // the content reference band spans 80-95% of the cutoff frequency.
+	drop := bandLevelDB(levels, sampleRate, cutoffHz*0.8, cutoffHz*0.95) - floor
+	if drop < spectrumMinDropDB {
+		return nyquist, max(drop, 0)
+	}
+
+	return cutoffHz, drop
+}
+
+// dropConfidence maps a level drop to a confidence between 0 (spectrumMinDropDB) and 1 (spectrumFullDropDB).
+func dropConfidence(drop float64) float64 {
+	return min(max((drop-spectrumMinDropDB)/(spectrumFullDropDB-spectrumMinDropDB), 0), 1)
+}
+
+// median returns the median of values without modifying them.
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+
+	return sorted[len(sorted)/2]
+}
+
+// pcmValue decodes one little-endian signed sample of width bytes.
+func pcmValue(data []byte, width int) int64 {
+	var sample int64
+	for idx := range width {
+		sample |= int64(data[idx]) << (bitsPerByte * idx)
+	}
+
+	shift := 64 - bitsPerByte*width
+
+	return sample << shift >> shift
+}
+
+// pcmLayoutValid reports whether bitDepth and channels describe at least one byte per frame. The analysers
+// return an empty analysis otherwise.
+func pcmLayoutValid(bitDepth, channels int) bool {
+	return channels > 0 && PCMBytesPerSample(bitDepth) > 0
+}
+
+// pcmMono decodes interleaved little-endian signed PCM to a mono mix scaled to [-1, 1].
+func pcmMono(pcm []byte, bitDepth, channels int) []float64 {
+	width := PCMBytesPerSample(bitDepth)
+	frameSize := width * channels
+	scale := math.Ldexp(1, bitDepth-1) * float64(channels)
+	mono := make([]float64, len(pcm)/frameSize)
+
+	for frame := range mono {
+		sum := int64(0)
+		for channel := range channels {
+			sum += pcmValue(pcm[frame*frameSize+channel*width:], width)
+		}
+
+		mono[frame] = float64(sum) / scale
+	}
+
+	return mono
+}
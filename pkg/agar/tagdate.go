@@ -0,0 +1,186 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Date field ranges.
+const (
+	maxMonth  = 12
+	maxDay    = 31
+	maxHour   = 23
+	maxMinute = 59
+	maxSecond = 59
+)
+
+// Sentinel errors for date parsing.
+var (
+	ErrNoDate      = errors.New("no date tag")
+	ErrInvalidDate = errors.New("invalid date")
+)
+
+// tagDateRE matches ISO-like dates with optional month, day and time: "2000", "2000-01", "2000/01/01",
+// "2000-01-01T12", "2000-01-01 12:34", "2000-01-01T12:34:56".
+var tagDateRE = regexp.MustCompile(
+	`^(\d{4})(?:[-/](\d{1,2})(?:[-/](\d{1,2})(?:[T ](\d{2})(?::(\d{2})(?::(\d{2}))?)?)?)?)?$`,
+)
+
+// tagDateDottedRE matches day-first dotted dates: "01.01.2000".
+var tagDateDottedRE = regexp.MustCompile(`^(\d{1,2})\.(\d{1,2})\.(\d{4})$`)
+
+// DatePrecision is the most specific component present in a TagDate.
+type DatePrecision int
+
+// Date precisions, from least to most specific.
+const (
+	DatePrecisionNone DatePrecision = iota
+	DatePrecisionYear
+	DatePrecisionMonth
+	DatePrecisionDay
+	DatePrecisionHour
+	DatePrecisionMinute
+	DatePrecisionSecond
+)
+
+// TagDate is a normalised tag date. Components beyond Precision are zero.
+type TagDate struct {
+	Year      int
+	Month     int
+	Day       int
+	Hour      int
+	Minute    int
+	Second    int
+	Precision DatePrecision
+}
+
+// String formats the date as ISO 8601, truncated to its precision (the ID3v2.4 TDRC layout).
+func (d TagDate) String() string {
+	switch d.Precision {
+	case DatePrecisionNone:
+		return ""
+	case DatePrecisionYear:
+		return fmt.Sprintf("%04d", d.Year)
+	case DatePrecisionMonth:
+		return fmt.Sprintf("%04d-%02d", d.Year, d.Month)
+	case DatePrecisionDay:
+		return fmt.Sprintf("%04d-%02d-%02d", d.Year, d.Month, d.Day)
+	case DatePrecisionHour:
+		return fmt.Sprintf("%04d-%02d-%02dT%02d", d.Year, d.Month, d.Day, d.Hour)
+	case DatePrecisionMinute:
+		return fmt.Sprintf("%04d-%02d-%02dT%02d:%02d", d.Year, d.Month, d.Day, d.Hour, d.Minute)
+	default:
+		return fmt.Sprintf("%04d-%02d-%02dT%02d:%02d:%02d", d.Year, d.Month, d.Day, d.Hour, d.Minute, d.Second)
+	}
+}
+
+// ParseTagDate parses the date layouts found in the wild: "2000", "2000-01", "2000-01-01",
+// "2000/01", "2000/01/01", ISO 8601 with a time ("2000-01-01T12:34:56"), and day-first "01.01.2000".
+func ParseTagDate(value string) (TagDate, error) {
+	value = strings.TrimSpace(value)
+
+	if matches := tagDateDottedRE.FindStringSubmatch(value); matches != nil {
+		return newTagDate(value, matches[3], matches[2], matches[1])
+	}
+
+	matches := tagDateRE.FindStringSubmatch(value)
+	if matches == nil {
+		return TagDate{}, fmt.Errorf("%w: %q", ErrInvalidDate, value)
+	}
+
+	return newTagDate(value, matches[1:]...)
+}
+
+// ParseID3v23Date combines the ID3v2.3 TYER (YYYY), TDAT (DDMM) and TIME (HHMM) frames.
+// tdat and tim may be empty; tim is ignored without tdat.
+func ParseID3v23Date(tyer, tdat, tim string) (TagDate, error) {
+	const pairLen = 2
+
+	raw := tyer + "/" + tdat + "/" + tim
+
+	if len(tyer) != len("YYYY") {
+		return TagDate{}, fmt.Errorf("%w: TYER %q", ErrInvalidDate, tyer)
+	}
+
+	if tdat == "" {
+		return newTagDate(raw, tyer)
+	}
+
+	if len(tdat) != len("DDMM") {
+		return TagDate{}, fmt.Errorf("%w: TDAT %q", ErrInvalidDate, tdat)
+	}
+
+	if tim == "" {
+		return newTagDate(raw, tyer, tdat[pairLen:], tdat[:pairLen])
+	}
+
+	if len(tim) != len("HHMM") {
+		return TagDate{}, fmt.Errorf("%w: TIME %q", ErrInvalidDate, tim)
+	}
+
+	return newTagDate(raw, tyer, tdat[pairLen:], tdat[:pairLen], tim[:pairLen], tim[pairLen:])
+}
+
+// Date parses the first "date" value with ParseTagDate.
+func (p *ParsedTags) Date() (TagDate, error) {
+	values := p.Text["date"]
+	if len(values) == 0 {
+		return TagDate{}, ErrNoDate
+	}
+
+	return ParseTagDate(values[0])
+}
+
+// newTagDate builds a TagDate from components in year, month, day, hour, minute, second order.
+// Empty trailing components lower the precision; raw is only used in error messages.
+func newTagDate(raw string, components ...string) (TagDate, error) {
+	limits := []struct{ low, high int }{
+		{0, 9999}, {1, maxMonth}, {1, maxDay}, {0, maxHour}, {0, maxMinute}, {0, maxSecond},
+	}
+
+	values := make([]int, len(limits))
+	precision := DatePrecisionNone
+
+	for idx, component := range components {
+		if component == "" {
+			break
+		}
+
+		value, err := strconv.Atoi(component)
+		if err != nil || value < limits[idx].low || value > limits[idx].high {
+			return TagDate{}, fmt.Errorf("%w: %q", ErrInvalidDate, raw)
+		}
+
+		values[idx] = value
+		precision++
+	}
+
+	return TagDate{
+		Year:      values[0],
+		Month:     values[1],
+		Day:       values[2],
+		Hour:      values[3],
+		Minute:    values[4],
+		Second:    values[5],
+		Precision: precision,
+	}, nil
+}
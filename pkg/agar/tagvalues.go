@@ -0,0 +1,195 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"path/filepath"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+)
+
+// TagFormat selects the container and tag writer used for tag value fixtures. Its value appears in fixture file names.
+type TagFormat string
+
+// Tag formats.
+const (
+	// TagFormatFLAC writes Vorbis comments with metaflac.
+	TagFormatFLAC TagFormat = "flac"
+	// TagFormatOggVorbis writes Vorbis comments with vorbiscomment.
+	TagFormatOggVorbis TagFormat = "ogg-vorbis"
+	// TagFormatMP3ID3v23 writes a native ID3v2.3 tag (TRCK, TYER).
+	TagFormatMP3ID3v23 TagFormat = "mp3-id3v23"
+	// TagFormatMP3ID3v24 writes a native ID3v2.4 tag (TRCK, TDRC).
+	TagFormatMP3ID3v24 TagFormat = "mp3-id3v24"
+	// TagFormatMP4 writes iTunes atoms with AtomicParsley.
+	// trkn stores 16-bit integers, so only what AtomicParsley can parse out of the value reaches the file.
+	TagFormatMP4 TagFormat = "mp4"
+)
+
+// TrackValueVariant is a raw track number value with the number and total that
+// ParsedTags reports for it.
+type TrackValueVariant struct {
+	Name   string
+	Value  string
+	Number int
+	Total  int
+}
+
+// TrackValueVariants returns the catalogue of track number values seen in the wild.
+func TrackValueVariants() []TrackValueVariant {
+	return []TrackValueVariant{
+		{Name: "plain", Value: "3", Number: 3},
+		{Name: "zero-padded", Value: "03", Number: 3},
+		{Name: "slash-total", Value: "3/12", Number: 3, Total: 12},
+		{Name: "of-total", Value: "3 of 12", Number: 3, Total: 12},
+		{Name: "vinyl-side", Value: "A1"},
+		{Name: "zero-total", Value: "1/0", Number: 1},
+		{Name: "zero", Value: "0"},
+		{Name: "negative", Value: "-1", Number: -1},
+		{Name: "exceeds-16-bit", Value: "65536", Number: 65536},
+		{Name: "overflow", Value: "99999999999999999999"},
+	}
+}
+
+// DateValueVariant is a raw date value with its normalised form.
+type DateValueVariant struct {
+	Name  string
+	Value string
+	Want  TagDate
+}
+
+// DateValueVariants returns the catalogue of date values seen in the wild.
+func DateValueVariants() []DateValueVariant {
+	firstOfJanuary := TagDate{Year: testYear, Month: 1, Day: 1, Precision: DatePrecisionDay}
+
+	return []DateValueVariant{
+		{Name: "year", Value: "2000", Want: TagDate{Year: testYear, Precision: DatePrecisionYear}},
+		{Name: "iso-day", Value: "2000-01-01", Want: firstOfJanuary},
+		{Name: "slash-month", Value: "2000/01", Want: TagDate{Year: testYear, Month: 1, Precision: DatePrecisionMonth}},
+		{Name: "dotted-day", Value: "01.01.2000", Want: firstOfJanuary},
+		{
+			Name:  "iso-minute",
+			Value: "2000-01-01T12:34",
+			Want:  TagDate{Year: testYear, Month: 1, Day: 1, Hour: 12, Minute: 34, Precision: DatePrecisionMinute},
+		},
+	}
+}
+
+// TrackValueFixture returns path to a file of the given format whose only track tag is variant.Value.
+func TrackValueFixture(data test.Data, helpers test.Helpers, format TagFormat, variant TrackValueVariant) string {
+	helpers.T().Helper()
+
+	path := untaggedFormat(data, helpers, format, "track-"+variant.Name)
+
+	switch format {
+	case TagFormatFLAC:
+		SetTag(helpers, path, "TRACKNUMBER", variant.Value)
+	case TagFormatOggVorbis:
+		OggAddTag(helpers, path, "TRACKNUMBER", variant.Value)
+	case TagFormatMP3ID3v23:
+		MP3WriteID3v2Tag(helpers, path, EncodeID3v2(ID3v23, []ID3Frame{ID3TextFrame(ID3v23, "TRCK", variant.Value)}))
+	case TagFormatMP3ID3v24:
+		MP3WriteID3v2Tag(helpers, path, EncodeID3v2(ID3v24, []ID3Frame{ID3TextFrame(ID3v24, "TRCK", variant.Value)}))
+	case TagFormatMP4:
+		MP4SetTag(helpers, path, "tracknum", variant.Value)
+	}
+
+	return path
+}
+
+// DateValueFixture returns path to a file of the given format whose only date tag is variant.Value.
+// ID3v2.3 stores the raw value in TYER, ID3v2.4 in TDRC.
+func DateValueFixture(data test.Data, helpers test.Helpers, format TagFormat, variant DateValueVariant) string {
+	helpers.T().Helper()
+
+	path := untaggedFormat(data, helpers, format, "date-"+variant.Name)
+
+	switch format {
+	case TagFormatFLAC:
+		SetTag(helpers, path, "DATE", variant.Value)
+	case TagFormatOggVorbis:
+		OggAddTag(helpers, path, "DATE", variant.Value)
+	case TagFormatMP3ID3v23:
+		MP3WriteID3v2Tag(helpers, path, EncodeID3v2(ID3v23, []ID3Frame{ID3TextFrame(ID3v23, "TYER", variant.Value)}))
+	case TagFormatMP3ID3v24:
+		MP3WriteID3v2Tag(helpers, path, EncodeID3v2(ID3v24, []ID3Frame{ID3TextFrame(ID3v24, "TDRC", variant.Value)}))
+	case TagFormatMP4:
+		MP4SetTag(helpers, path, "year", variant.Value)
+	}
+
+	return path
+}
+
+// ID3v23SplitDateMP3 returns path to an MP3 whose ID3v2.3 date is split across
+// TYER "2000", TDAT "0101" (DDMM) and TIME "1234" (HHMM): 2000-01-01T12:34.
+func ID3v23SplitDateMP3(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	path := untaggedFormat(data, helpers, TagFormatMP3ID3v23, "date-split")
+	MP3WriteID3v2Tag(helpers, path, EncodeID3v2(ID3v23, []ID3Frame{
+		ID3TextFrame(ID3v23, "TYER", "2000"),
+		ID3TextFrame(ID3v23, "TDAT", "0101"),
+		ID3TextFrame(ID3v23, "TIME", "1234"),
+	}))
+
+	return path
+}
+
+// ID3v24TDRCMP3 returns path to an MP3 whose ID3v2.4 TDRC holds the same date as
+// ID3v23SplitDateMP3: 2000-01-01T12:34.
+func ID3v24TDRCMP3(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	path := untaggedFormat(data, helpers, TagFormatMP3ID3v24, "date-tdrc")
+	MP3WriteID3v2Tag(helpers, path, EncodeID3v2(ID3v24, []ID3Frame{
+		ID3TextFrame(ID3v24, "TDRC", "2000-01-01T12:34"),
+	}))
+
+	return path
+}
+
+// untaggedFormat generates a 3 second stereo file of the given format under a unique name,
+// with the encoder's own metadata stripped where a tool can do so.
+func untaggedFormat(data test.Data, helpers test.Helpers, format TagFormat, name string) string {
+	helpers.T().Helper()
+
+	base := filepath.Join(data.Temp().Dir(), "tagvalue-"+string(format)+"-"+name)
+	source := []string{
+		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + shortDuration,
+		"-f", "lavfi", "-i", "sine=frequency=554:duration=" + shortDuration,
+		"-filter_complex", "[0][1]amerge=inputs=2,volume=-6dB", "-ar", "44100",
+	}
+
+	switch format {
+	case TagFormatFLAC:
+		return generate(helpers, base+".flac", append(source, "-sample_fmt", "s16"))
+	case TagFormatOggVorbis:
+		return generate(helpers, base+".ogg", append(source, "-c:a", "libvorbis", "-q:a", "6"))
+	case TagFormatMP3ID3v23, TagFormatMP3ID3v24:
+		return generate(helpers, base+".mp3", append(source, "-c:a", "libmp3lame", "-b:a", "256k"))
+	case TagFormatMP4:
+		path := generate(helpers, base+".m4a", append(source, "-c:a", "aac", "-b:a", "256k"))
+		MP4RemoveAllTags(helpers, path)
+
+		return path
+	default:
+		helpers.T().Log("unknown tag format " + string(format))
+		helpers.T().FailNow()
+
+		return ""
+	}
+}
@@ -0,0 +1,281 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"encoding/json"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+	"github.com/containerd/nerdctl/mod/tigron/tig"
+)
+
+// Musical fixture synthesis parameters.
+const (
+	musicSampleRate    = 44100
+	musicPeakDBFS      = -3.0
+	secondsPerMinute   = 60
+	straightSwing      = 0.5
+	defaultBeatsPerBar = 4
+	clickDuration      = 0.03
+	clickHz            = 1000
+	clickAccentHz      = 1600
+	clickDecay         = 150
+	kickDuration       = 0.18
+	kickStartHz        = 120
+	kickEndHz          = 45
+	kickDecay          = 18
+	snareDuration      = 0.12
+	snareToneHz        = 190
+	snareDecay         = 30
+	hatDuration        = 0.04
+	hatDecay           = 120
+	hatGain            = 0.25
+)
+
+// RhythmPattern selects the sound of a tempo fixture, named as it appears in TempoTruth.
+type RhythmPattern string
+
+// Rhythm patterns.
+const (
+	// RhythmClick is a metronome click on every beat, higher on downbeats.
+	RhythmClick RhythmPattern = "click"
+	// RhythmDrums is kick on beats 1 and 3, snare on 2 and 4 and hi-hat on every eighth note.
+	RhythmDrums RhythmPattern = "drums"
+)
+
+// TempoSegment is a span of constant tempo.
+type TempoSegment struct {
+	// BPM is the tempo in beats per minute.
+	BPM float64 `json:"bpm"`
+	// Bars is the number of bars at this tempo.
+	Bars int `json:"bars"`
+}
+
+// TempoFixture describes a synthesized rhythm track.
+type TempoFixture struct {
+	// Name is used for the file name.
+	Name    string
+	Pattern RhythmPattern
+	// Segments are played in order; more than one makes a tempo change at a bar line.
+	Segments []TempoSegment
+	// Swing is the position of off-beat eighth notes as a share of the beat: 0.5 is straight,
+	// 2/3 is triplet swing. Zero means straight. It only moves the hi-hats of RhythmDrums.
+	Swing float64
+}
+
+// TempoTruth is the ground truth of a tempo fixture, written next to it as JSON.
+type TempoTruth struct {
+	Pattern  string         `json:"pattern"`
+	Segments []TempoSegment `json:"segments"`
+	Swing    float64        `json:"swing"`
+	// BeatsPerBar is the time signature numerator (over a quarter note).
+	BeatsPerBar int `json:"beatsPerBar"`
+	// Beats holds the onset of every beat in seconds; Downbeats the onset of every bar.
+	Beats     []float64 `json:"beats"`
+	Downbeats []float64 `json:"downbeats"`
+	// DurationSec is the length of the audio, including the decay after the last beat.
+	DurationSec float64 `json:"durationSec"`
+}
+
+// TempoFixtures returns rhythm tracks covering common, fractional and extreme tempos, a tempo change
+// and swing. Render one with TempoFixtureFile.
+func TempoFixtures() []TempoFixture {
+	return []TempoFixture{
+		{Name: "click-60", Pattern: RhythmClick, Segments: []TempoSegment{{BPM: 60, Bars: 4}}},
+		{Name: "click-120", Pattern: RhythmClick, Segments: []TempoSegment{{BPM: 120, Bars: 8}}},
+		{Name: "click-174", Pattern: RhythmClick, Segments: []TempoSegment{{BPM: 174, Bars: 12}}},
+		{Name: "drums-93.5", Pattern: RhythmDrums, Segments: []TempoSegment{{BPM: 93.5, Bars: 6}}},
+		{Name: "drums-128", Pattern: RhythmDrums, Segments: []TempoSegment{{BPM: 128, Bars: 8}}},
+		{
+			Name: "drums-100-to-140", Pattern: RhythmDrums,
+			Segments: []TempoSegment{{BPM: 100, Bars: 6}, {BPM: 140, Bars: 8}},
+		},
+		{Name: "drums-swing-110", Pattern: RhythmDrums, Segments: []TempoSegment{{BPM: 110, Bars: 8}}, Swing: 2.0 / 3},
+	}
+}
+
+// Truth computes the beat grid of the fixture.
+func (f TempoFixture) Truth() TempoTruth {
+	truth := TempoTruth{
+		Pattern:     string(f.Pattern),
+		Segments:    f.Segments,
+		Swing:       f.swing(),
+		BeatsPerBar: defaultBeatsPerBar,
+	}
+
+	position := 0.0
+
+	for _, segment := range f.Segments {
+		beat := secondsPerMinute / segment.BPM
+
+		for bar := range segment.Bars * defaultBeatsPerBar {
+			if bar%defaultBeatsPerBar == 0 {
+				truth.Downbeats = append(truth.Downbeats, position)
+			}
+
+			truth.Beats = append(truth.Beats, position)
+			position += beat
+		}
+	}
+
+	truth.DurationSec = position + kickDuration
+
+	return truth
+}
+
+// swing returns the off-beat position, defaulting to straight eighths.
+func (f TempoFixture) swing() float64 {
+	if f.Swing == 0 {
+		return straightSwing
+	}
+
+	return f.Swing
+}
+
+// TempoFixtureFile returns path to a 16-bit 44.1kHz mono WAV of the fixture. The ground truth is written
+// beside it, with a .json extension.
+func TempoFixtureFile(data test.Data, helpers test.Helpers, fixture TempoFixture) string {
+	helpers.T().Helper()
+
+	truth := fixture.Truth()
+	samples := make([]float64, int(math.Ceil(truth.DurationSec*musicSampleRate)))
+
+	for idx, onset := range truth.Beats {
+		beatInBar := idx % truth.BeatsPerBar
+
+		if fixture.Pattern == RhythmClick {
+			frequency := float64(clickHz)
+			if beatInBar == 0 {
+				frequency = clickAccentHz
+			}
+
+			mixTone(samples, onset, clickDuration, clickDecay, func(t float64) float64 {
+				return math.Sin(2 * math.Pi * frequency * t)
+			})
+
+			continue
+		}
+
+		beat := beatLength(truth, idx)
+
+		if beatInBar%2 == 0 {
+			mixTone(samples, onset, kickDuration, kickDecay, kickPhase)
+		} else {
+			mixNoise(samples, onset, snareDuration, snareDecay, 1, snareToneHz, uint64(idx))
+		}
+
+		mixNoise(samples, onset, hatDuration, hatDecay, hatGain, 0, uint64(idx)<<1)
+		mixNoise(samples, onset+beat*truth.Swing, hatDuration, hatDecay, hatGain, 0, uint64(idx)<<1|1)
+	}
+
+	return writeMusicFixture(helpers.T(), filepath.Join(data.Temp().Dir(), "tempo-"+fixture.Name+".wav"),
+		samples, truth)
+}
+
+// beatLength returns the length of beat idx, from the next onset or, for the last beat, the previous one.
+func beatLength(truth TempoTruth, idx int) float64 {
+	if idx+1 < len(truth.Beats) {
+		return truth.Beats[idx+1] - truth.Beats[idx]
+	}
+
+	return truth.Beats[idx] - truth.Beats[idx-1]
+}
+
+// kickPhase is the phase of an exponential pitch sweep from kickStartHz to kickEndHz over kickDuration.
+func kickPhase(t float64) float64 {
+	ratio := math.Log(kickEndHz/kickStartHz) / kickDuration
+
+	return math.Sin(2 * math.Pi * kickStartHz * (math.Exp(ratio*t) - 1) / ratio)
+}
+
+// mixTone adds wave(t) under an exponentially decaying envelope, from onset for duration seconds.
+func mixTone(samples []float64, onset, duration, decay float64, wave func(t float64) float64) {
+	start := int(math.Round(onset * musicSampleRate))
+
+	for idx := range int(duration * musicSampleRate) {
+		if start+idx >= len(samples) {
+			return
+		}
+
+		t := float64(idx) / musicSampleRate
+		samples[start+idx] += wave(t) * math.Exp(-decay*t)
+	}
+}
+
+// mixNoise adds a decaying burst of deterministic white noise, optionally mixed with a tone at toneHz.
+func mixNoise(samples []float64, onset, duration, decay, gain, toneHz float64, seed uint64) {
+	state := xorshiftSeed ^ (seed+1)*0x9e3779b97f4a7c15 //nolint:mnd // golden ratio seed spreading.
+
+	mixTone(samples, onset, duration, decay, func(t float64) float64 {
+		state ^= state << xorshiftShiftA
+		state ^= state >> xorshiftShiftB
+		state ^= state << xorshiftShiftC
+
+		noise := float64(state>>11)/(1<<53)*2 - 1 //nolint:mnd // 53-bit mantissa, scaled to [-1, 1).
+
+		return gain * (noise + math.Sin(2*math.Pi*toneHz*t)) / 2 //nolint:mnd // equal noise and tone mix.
+	})
+}
+
+// writeMusicFixture normalizes samples to musicPeakDBFS, writes them as a 16-bit mono WAV and writes truth
+// beside it as JSON.
+func writeMusicFixture(helper tig.T, path string, samples []float64, truth any) string {
+	helper.Helper()
+
+	peak := 0.0
+	for _, sample := range samples {
+		peak = max(peak, math.Abs(sample))
+	}
+
+	gain := 0.0
+	if peak > 0 {
+		gain = math.Pow(10, musicPeakDBFS/dbPerAmplitudeDecibel) * (math.Ldexp(1, BitDepth16-1) - 1) / peak
+	}
+
+	pcm := make([]byte, 0, len(samples)*PCMBytesPerSample(BitDepth16))
+	for _, sample := range samples {
+		value := int16(math.Round(sample * gain))
+		pcm = append(pcm, byte(value), byte(value>>bitsPerByte))
+	}
+
+	format := WAVFormat{SampleRate: musicSampleRate, BitDepth: BitDepth16, Channels: 1}
+	file, err := BuildWAV(format, pcm, WAVWellFormed, WAVChunksNone)
+	if err != nil {
+		helper.Log(err.Error())
+		helper.FailNow()
+	}
+
+	writeFixtureFile(helper, path, file.Bytes())
+
+	encoded, err := json.MarshalIndent(truth, "", "  ")
+	if err != nil {
+		helper.Log("encoding ground truth: " + err.Error())
+		helper.FailNow()
+	}
+
+	writeFixtureFile(helper, GroundTruthPath(path), append(encoded, '\n'))
+
+	return path
+}
+
+// GroundTruthPath returns the path of the JSON ground truth written beside a tempo or key fixture.
+func GroundTruthPath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".json"
+}
@@ -0,0 +1,235 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+)
+
+// InfoTags holds metadata for WAV files using RIFF LIST/INFO chunk identifiers.
+type InfoTags struct {
+	Title       string // INAM
+	Artist      string // IART
+	Album       string // IPRD
+	Date        string // ICRD
+	TrackNumber int    // ITRK
+	Genre       string // IGNR
+	Comment     string // ICMT
+}
+
+// DefaultInfoTags returns the standard test metadata used across gill tests.
+func DefaultInfoTags() InfoTags {
+	return InfoTags{
+		Title:       "Test Title",
+		Artist:      "Test Artist",
+		Album:       "Test Album",
+		Date:        strconv.Itoa(testYear),
+		TrackNumber: testTrack,
+		Genre:       "Jazz",
+		Comment:     "Test Comment",
+	}
+}
+
+// Chunk returns a LIST/INFO chunk holding the non-empty fields, as NUL-terminated strings.
+func (t InfoTags) Chunk() RIFFChunk {
+	var subChunks []RIFFChunk
+
+	add := func(id, value string) {
+		if value != "" {
+			subChunks = append(subChunks, RIFFChunk{ID: id, Data: append([]byte(value), 0)})
+		}
+	}
+
+	add("INAM", t.Title)
+	add("IART", t.Artist)
+	add("IPRD", t.Album)
+	add("ICRD", t.Date)
+
+	if t.TrackNumber > 0 {
+		add("ITRK", strconv.Itoa(t.TrackNumber))
+	}
+
+	add("IGNR", t.Genre)
+	add("ICMT", t.Comment)
+
+	return RIFFListChunk("INFO", subChunks)
+}
+
+// AIFFTextTags holds metadata for AIFF text chunks.
+type AIFFTextTags struct {
+	Name      string // NAME
+	Author    string // AUTH
+	Copyright string // (c)
+	// Annotations are written as one ANNO chunk each.
+	Annotations []string
+}
+
+// DefaultAIFFTextTags returns the standard test metadata used across gill tests.
+func DefaultAIFFTextTags() AIFFTextTags {
+	return AIFFTextTags{
+		Name:        "Test Title",
+		Author:      "Test Artist",
+		Copyright:   "Test Copyright",
+		Annotations: []string{"Test Comment"},
+	}
+}
+
+// Chunks returns the text chunks for the non-empty fields. Text is not NUL-terminated.
+func (t AIFFTextTags) Chunks() []RIFFChunk {
+	var chunks []RIFFChunk
+
+	add := func(id, value string) {
+		if value != "" {
+			chunks = append(chunks, RIFFChunk{ID: id, Data: []byte(value)})
+		}
+	}
+
+	add("NAME", t.Name)
+	add("AUTH", t.Author)
+	add("(c) ", t.Copyright)
+
+	for _, annotation := range t.Annotations {
+		add("ANNO", annotation)
+	}
+
+	return chunks
+}
+
+// ParseRIFFInfo reads the LIST/INFO chunk of a WAV file into ParsedTags.
+func ParseRIFFInfo(filePath string) (*ParsedTags, error) {
+	raw, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", filePath, err)
+	}
+
+	file, err := ReadRIFF(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+
+	tags := NewParsedTags()
+
+	subChunks, ok := file.ListChunk("INFO")
+	if !ok {
+		return tags, nil
+	}
+
+	for _, chunk := range subChunks {
+		value := decodeLegacyText(chunk.Data)
+		key := infoToSemanticName(chunk.ID)
+
+		if key == "tracknumber" {
+			tags.Track, tags.TrackTotal = parsePairValue(value)
+		}
+
+		tags.Text[key] = append(tags.Text[key], value)
+	}
+
+	return tags, nil
+}
+
+// ParseAIFFText reads the NAME, AUTH, ANNO and (c) chunks of an AIFF file into ParsedTags.
+func ParseAIFFText(filePath string) (*ParsedTags, error) {
+	raw, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", filePath, err)
+	}
+
+	file, err := ReadAIFF(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+
+	tags := NewParsedTags()
+
+	for _, chunk := range file.Chunks {
+		if key, ok := aiffTextToSemantic[chunk.ID]; ok {
+			tags.Text[key] = append(tags.Text[key], decodeLegacyText(bytes.TrimRight(chunk.Data, "\x00")))
+		}
+	}
+
+	return tags, nil
+}
+
+// conflictingID3Tag returns an ID3v2.4 tag whose title, artist and album differ from every
+// native default, so that tests can tell which source a reader honoured.
+func conflictingID3Tag() []byte {
+	return EncodeID3v2(ID3v24, []ID3Frame{
+		ID3TextFrame(ID3v24, "TIT2", "ID3 Title"),
+		ID3TextFrame(ID3v24, "TPE1", "ID3 Artist"),
+		ID3TextFrame(ID3v24, "TALB", "ID3 Album"),
+	})
+}
+
+// TaggedWAVInfo returns path to a WAV with the default LIST/INFO tags.
+func TaggedWAVInfo(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	return wavWithChunks(data, helpers, "tagged-info.wav", []RIFFChunk{DefaultInfoTags().Chunk()}, nil)
+}
+
+// WAVInfoID3Conflict returns path to a WAV carrying both the default LIST/INFO tags and an
+// "id3 " chunk with different title, artist and album ("ID3 Title", "ID3 Artist", "ID3 Album").
+// Use it to assert which source takes precedence.
+func WAVInfoID3Conflict(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	return wavWithChunks(data, helpers, "info-id3-conflict.wav",
+		[]RIFFChunk{DefaultInfoTags().Chunk()},
+		[]RIFFChunk{{ID: "id3 ", Data: conflictingID3Tag()}},
+	)
+}
+
+// TaggedAIFFText returns path to an AIFF with the default NAME, AUTH, ANNO and (c) chunks.
+func TaggedAIFFText(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	return AIFFFixture(data, helpers, "tagged-text.aiff", DefaultAIFFTextTags().Chunks())
+}
+
+// AIFFTextID3Conflict returns path to an AIFF carrying both the default text chunks and an
+// "ID3 " chunk with different title and artist. Use it to assert which source takes precedence.
+func AIFFTextID3Conflict(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	extra := append(DefaultAIFFTextTags().Chunks(), RIFFChunk{ID: "ID3 ", Data: conflictingID3Tag()})
+
+	return AIFFFixture(data, helpers, "text-id3-conflict.aiff", extra)
+}
+
+// wavWithChunks writes a 1 second 16-bit 44.1kHz stereo WAV with extra chunks before and after data.
+func wavWithChunks(data test.Data, helpers test.Helpers, name string, before, after []RIFFChunk) string {
+	helpers.T().Helper()
+
+	format := DefaultWAVFormat()
+	pcm := GenerateWhiteNoise(format.SampleRate, format.BitDepth, format.Channels, wavFixtureDurationSec)
+
+	chunks := append([]RIFFChunk{format.FmtChunk()}, before...)
+	chunks = append(chunks, RIFFChunk{ID: "data", Data: pcm})
+	chunks = append(chunks, after...)
+
+	path := filepath.Join(data.Temp().Dir(), name)
+	writeFixtureFile(helpers.T(), path, RIFFFile{FormType: "WAVE", Chunks: chunks}.Bytes())
+
+	return path
+}
@@ -0,0 +1,110 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+)
+
+// ToolError is returned by the reference tool wrappers (RunFFmpeg, RunSox, RunFlac, RunMetaflac)
+// when the tool cannot be found, cannot start, or exits unsuccessfully.
+type ToolError struct {
+	// Tool is the binary name.
+	Tool string
+	// Args are the arguments the tool was run with.
+	Args []string
+	// ExitCode is the exit status, or -1 when the tool did not exit on its own (not found, not started, killed).
+	ExitCode int
+	// Stderr holds the captured standard error. Empty when the caller supplied its own Stderr writer.
+	Stderr string
+	// Err is the underlying error. It wraps ErrBinaryNotFound when the tool is missing.
+	Err error
+}
+
+// Error formats the failure as "tool: error", followed by the captured standard error if any.
+func (e *ToolError) Error() string {
+	if e.Stderr == "" {
+		return fmt.Sprintf("%s: %v", e.Tool, e.Err)
+	}
+
+	return fmt.Sprintf("%s: %v\n%s", e.Tool, e.Err, e.Stderr)
+}
+
+// Unwrap returns the underlying error.
+func (e *ToolError) Unwrap() error {
+	return e.Err
+}
+
+// toolOptions is the shared shape of FFmpegOptions, SoxOptions, FlacOptions and MetaflacOptions,
+// which convert to it directly.
+type toolOptions struct {
+	Args   []string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// runTool resolves binary with LookFor and runs it until it exits or ctx is done. Standard output is
+// captured and returned when opts.Stdout is nil; standard error is captured into the ToolError when
+// opts.Stderr is nil.
+func runTool(ctx context.Context, binary string, opts toolOptions) ([]byte, error) {
+	toolErr := &ToolError{Tool: binary, Args: opts.Args, ExitCode: -1}
+
+	binaryPath, err := LookFor(binary)
+	if err != nil {
+		toolErr.Err = err
+
+		return nil, toolErr
+	}
+
+	//nolint:gosec // arguments are test-controlled
+	cmd := exec.CommandContext(ctx, binaryPath, opts.Args...)
+
+	if opts.Stdin != nil {
+		cmd.Stdin = opts.Stdin
+	}
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+
+	cmd.Stdout = &stdoutBuf
+	if opts.Stdout != nil {
+		cmd.Stdout = opts.Stdout
+	}
+
+	cmd.Stderr = &stderrBuf
+	if opts.Stderr != nil {
+		cmd.Stderr = opts.Stderr
+	}
+
+	if err := cmd.Run(); err != nil {
+		toolErr.Err = err
+		toolErr.Stderr = stderrBuf.String()
+
+		if exitErr := (*exec.ExitError)(nil); errors.As(err, &exitErr) {
+			toolErr.ExitCode = exitErr.ExitCode()
+		}
+
+		return nil, toolErr
+	}
+
+	return stdoutBuf.Bytes(), nil
+}
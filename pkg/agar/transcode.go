@@ -0,0 +1,283 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+)
+
+// opusSampleRate is the rate the lossy stage runs at for Opus, which libopus cannot encode at 44.1 kHz.
+const opusSampleRate = "48000"
+
+// Lossy-origin analysis parameters.
+const (
+	// transcodeHop is one MP3 granule, so that codec frames map onto analysis frames.
+	transcodeHop    = 576
+	transcodeWindow = 1024
+	// transcodeBands splits the upper half of the occupied band for hole detection.
+	transcodeBands = 16
+	// transcodeHoleDB is how far below its median level a band must fall in a frame to count as a hole.
+	transcodeHoleDB = 30.0
+	// transcodeEdgeShare is the half-width of the bands either side of the cutoff, as a share of the cutoff.
+	transcodeEdgeShare = 0.02
+	// transcodeEdgeDB is the level step across the cutoff of an encoder lowpass, as opposed to a natural rolloff.
+	transcodeEdgeDB = 25.0
+	// transcodeLowpassShare is the cutoff below which, as a share of Nyquist, a lowpass counts as lossy evidence.
+	transcodeLowpassShare = 0.95
+	// transcodeHoleRatio is the share of holes in the upper band at which holes count as full lossy evidence.
+	transcodeHoleRatio = 0.05
+	// transcodeSFB21DB is the level deficit of MP3 scalefactor band 21 counted as full lossy evidence.
+	transcodeSFB21DB = 20.0
+	// mp3SFB21Start is the first MDCT line (of 576) of MP3 long-block scalefactor band 21.
+	mp3SFB21Start = 418
+)
+
+// LossyOrigin selects the lossy encoding a LossyTranscode fixture went through, named after its codec and bitrate.
+type LossyOrigin string
+
+// Lossy encodings for transcode fixtures.
+const (
+	// LossyOriginMP3128k is LAME at a constant 128 kbit/s, as in LossyTranscodeMP3128k.
+	LossyOriginMP3128k LossyOrigin = "mp3-128k"
+	// LossyOriginMP3V0 is LAME VBR quality 0.
+	LossyOriginMP3V0 LossyOrigin = "mp3-v0"
+	// LossyOriginAAC96k is the ffmpeg AAC encoder at 96 kbit/s.
+	LossyOriginAAC96k LossyOrigin = "aac-96k"
+	// LossyOriginOpus64k is libopus at 64 kbit/s.
+	LossyOriginOpus64k LossyOrigin = "opus-64k"
+	// LossyOriginVorbisQ2 is libvorbis at quality 2.
+	LossyOriginVorbisQ2 LossyOrigin = "vorbis-q2"
+)
+
+// encodeArgs returns the ffmpeg codec options and pipe format of the encoding, or false for a value outside
+// the LossyOrigin constants.
+func (o LossyOrigin) encodeArgs() ([]string, bool) {
+	switch o {
+	case LossyOriginMP3128k:
+		return []string{"-c:a", "libmp3lame", "-b:a", "128k", "-f", "mp3"}, true
+	case LossyOriginMP3V0:
+		return []string{"-c:a", "libmp3lame", "-q:a", "0", "-f", "mp3"}, true
+	case LossyOriginAAC96k:
+		return []string{"-c:a", "aac", "-b:a", "96k", "-f", "adts"}, true
+	case LossyOriginOpus64k:
+		return []string{"-c:a", "libopus", "-b:a", "64k", "-f", "ogg"}, true
+	case LossyOriginVorbisQ2:
+		return []string{"-c:a", "libvorbis", "-q:a", "2", "-f", "ogg"}, true
+	default:
+		return nil, false
+	}
+}
+
+// LossyTranscode returns path to stereo pink noise encoded with the given lossy encoding, then decoded
+// and stored as FLAC at sampleRate. The lossy stage runs at sampleRate too, except for Opus, which
+// always encodes at 48 kHz and is resampled to sampleRate by the FLAC stage.
+func LossyTranscode(data test.Data, helpers test.Helpers, origin LossyOrigin, sampleRate int) string {
+	helpers.T().Helper()
+
+	encode, ok := origin.encodeArgs()
+	if !ok {
+		helpers.T().Log("unknown lossy origin " + string(origin))
+		helpers.T().FailNow()
+
+		return ""
+	}
+
+	rate := strconv.Itoa(sampleRate)
+	name := "lossy-transcode-" + string(origin) + "-" + rate + ".flac"
+
+	encodeRate := rate
+	if origin == LossyOriginOpus64k {
+		encodeRate = opusSampleRate
+	}
+
+	first := []string{
+		"-f", "lavfi", "-i", "anoisesrc=d=" + defaultDuration + ":c=pink:a=0.5",
+		"-af", "pan=stereo|c0=c0|c1=c0,volume=-6dB",
+		"-ar", encodeRate,
+	}
+
+	return generateWithPipe(helpers, filepath.Join(data.Temp().Dir(), name),
+		append(first, append(encode, "-")...),
+		[]string{"-ar", rate, "-c:a", "flac", "-sample_fmt", "s16"},
+	)
+}
+
+// LossyTranscodeAAC96k returns path to 44.1kHz FLAC decoded from AAC 96k.
+func LossyTranscodeAAC96k(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	return LossyTranscode(data, helpers, LossyOriginAAC96k, 44100)
+}
+
+// LossyTranscodeOpus64k returns path to 48kHz FLAC decoded from Opus 64k.
+func LossyTranscodeOpus64k(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	return LossyTranscode(data, helpers, LossyOriginOpus64k, 48000)
+}
+
+// LossyTranscodeVorbisQ2 returns path to 48kHz FLAC decoded from Vorbis quality 2.
+func LossyTranscodeVorbisQ2(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	return LossyTranscode(data, helpers, LossyOriginVorbisQ2, 48000)
+}
+
+// LossyTranscodeMP3V0 returns path to 44.1kHz FLAC decoded from MP3 V0.
+func LossyTranscodeMP3V0(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	return LossyTranscode(data, helpers, LossyOriginMP3V0, 44100)
+}
+
+// LossyOriginAnalysis holds the evidence of a lossy encoding stage found in decoded PCM.
+type LossyOriginAnalysis struct {
+	SampleRate int
+	// CutoffHz is the top of the occupied band; the Nyquist frequency when the band is full.
+	CutoffHz float64
+	// EdgeDB is the level step across the cutoff. Encoder lowpass filters are brick walls; natural
+	// rolloffs are gradual.
+	EdgeDB float64
+	// SFB21DeficitDB is how far MP3 scalefactor band 21 (above about 16 kHz at 44.1 kHz) sits below the band
+	// under it. LAME drops or starves it. Zero when the cutoff is below the band.
+	SFB21DeficitDB float64
+	// HoleRatio is the share of (frame, band) cells in the upper half of the occupied band that are empty
+	// while the band is otherwise occupied. Frames are one MP3 granule apart, so per-frame holes left
+	// by the codec's bit allocation show up here.
+	HoleRatio float64
+	// Lossy is the verdict.
+	Lossy bool
+	// Confidence is between 0 and 1, for the verdict given by Lossy.
+	Confidence float64
+}
+
+// String summarises the analysis on one line.
+func (a LossyOriginAnalysis) String() string {
+	verdict := "lossless origin"
+	if a.Lossy {
+		verdict = "lossy origin"
+	}
+
+	return fmt.Sprintf("%s (confidence %.2f): cutoff %.0f Hz, edge %.1f dB, sfb21 deficit %.1f dB, holes %.3f",
+		verdict, a.Confidence, a.CutoffHz, a.EdgeDB, a.SFB21DeficitDB, a.HoleRatio)
+}
+
+// AnalyzeLossyOrigin looks for the marks a lossy encoder leaves in interleaved little-endian signed PCM:
+// a brick-wall lowpass below Nyquist, missing energy in MP3 scalefactor band 21, and spectral holes in
+// individual codec frames. Each piece of evidence is scored between 0 and 1; the strongest one gives the
+// verdict and its confidence.
+func AnalyzeLossyOrigin(pcm []byte, bitDepth, channels, sampleRate int) LossyOriginAnalysis {
+	if !pcmLayoutValid(bitDepth, channels) {
+		return LossyOriginAnalysis{SampleRate: sampleRate}
+	}
+
+	mono := pcmMono(pcm, bitDepth, channels)
+	levels := smoothedDB(powerSpectrum(mono, spectrumSize), spectrumSmoothBins)
+	nyquist := float64(sampleRate) / 2
+	cutoff, _ := spectralCutoff(levels, sampleRate)
+
+	analysis := LossyOriginAnalysis{SampleRate: sampleRate, CutoffHz: cutoff}
+
+	edge := cutoff * transcodeEdgeShare
+	lowpass := 0.0
+
+	if cutoff < nyquist*transcodeLowpassShare {
+		analysis.EdgeDB = bandLevelDB(levels, sampleRate, cutoff-2*edge, cutoff-edge) -
+			bandLevelDB(levels, sampleRate, cutoff+edge, cutoff+2*edge)
+		lowpass = min(max(analysis.EdgeDB/transcodeEdgeDB, 0), 1)
+	}
+
+	sfb21 := nyquist * mp3SFB21Start / transcodeHop
+	if cutoff > sfb21 {
+		//nolint:mnd // compare sfb21 with the band of the same width just below it.
+		below := bandLevelDB(levels, sampleRate, 2*sfb21-cutoff, sfb21)
+		analysis.SFB21DeficitDB = max(below-bandLevelDB(levels, sampleRate, sfb21, cutoff), 0)
+	}
+
+	analysis.HoleRatio = spectralHoleRatio(mono, sampleRate, cutoff)
+
+	evidence := max(lowpass,
+		min(analysis.SFB21DeficitDB/transcodeSFB21DB, 1),
+		min(analysis.HoleRatio/transcodeHoleRatio, 1))
+
+	//nolint:mnd // evidence above one half gives a lossy verdict.
+	analysis.Lossy = evidence >= 0.5
+	analysis.Confidence = math.Abs(evidence-0.5) * 2 //nolint:mnd // rescale distance from the threshold to [0, 1].
+
+	return analysis
+}
+
+// spectralHoleRatio splits the upper half of the band below cutoff into transcodeBands bands and returns the share
+// of (frame, band) cells more than transcodeHoleDB below that band's median level. Frames that are quiet over the
+// whole band (silence, fades) are ignored.
+func spectralHoleRatio(mono []float64, sampleRate int, cutoff float64) float64 {
+	frames := spectrogram(mono, transcodeWindow, transcodeHop)
+	if len(frames) == 0 {
+		return 0
+	}
+
+	bandWidth := cutoff / 2 / transcodeBands
+	levels := make([][]float64, transcodeBands+1) // the last row is the whole band below cutoff, for quiet frames
+
+	for _, frame := range frames {
+		frameDB := make([]float64, len(frame))
+		for idx, power := range frame {
+			frameDB[idx] = decibels(power)
+		}
+
+		for band := range transcodeBands {
+			low := cutoff/2 + float64(band)*bandWidth
+			levels[band] = append(levels[band], bandLevelDB(frameDB, sampleRate, low, low+bandWidth))
+		}
+
+		levels[transcodeBands] = append(levels[transcodeBands], bandLevelDB(frameDB, sampleRate, 0, cutoff))
+	}
+
+	overall := median(levels[transcodeBands])
+	medians := make([]float64, transcodeBands)
+
+	for band := range medians {
+		medians[band] = median(levels[band])
+	}
+
+	holes, cells := 0, 0
+
+	for idx := range frames {
+		if levels[transcodeBands][idx] < overall-transcodeHoleDB {
+			continue
+		}
+
+		for band, level := range medians {
+			cells++
+
+			if levels[band][idx] < level-transcodeHoleDB {
+				holes++
+			}
+		}
+	}
+
+	if cells == 0 {
+		return 0
+	}
+
+	return float64(holes) / float64(cells)
+}
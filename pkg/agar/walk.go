@@ -0,0 +1,43 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Dump renders the result of a structural walker (WalkFLACFrames, WalkMPEGFrames, WalkMP4Boxes,
+// WalkRIFFChunks, WalkAIFFChunks, WalkOggPages), one item per line, for test logs.
+func Dump[T fmt.Stringer](items []T) string {
+	lines := make([]string, 0, len(items))
+	for _, item := range items {
+		lines = append(lines, item.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// leadingID3v2Size returns the total size of the ID3v2 tags at the start of data.
+func leadingID3v2Size(data []byte) int {
+	start := 0
+	for size := id3v2TagSize(data); size > 0; size = id3v2TagSize(data[start:]) {
+		start += size
+	}
+
+	return start
+}
@@ -0,0 +1,372 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+)
+
+// WAV fmt chunk constants.
+const (
+	wavFormatPCM          = 0x0001
+	wavFormatExtensible   = 0xfffe
+	wavFmtSizePCM         = 16
+	wavExtensibleCbSize   = 22
+	wavCbSizeLieExtra     = 24
+	wavStreamingSize      = 0xffffffff
+	wavRIFFSizeMismatch   = 4096
+	wavDefaultSampleRate  = 44100
+	wavDefaultChannels    = 2
+	wavFixtureDurationSec = 1
+)
+
+// ksDataFormatSubtypePCM is the KSDATAFORMAT_SUBTYPE_PCM GUID, in on-disk byte order.
+//
+//nolint:gochecknoglobals // constant byte sequence
+var ksDataFormatSubtypePCM = []byte{
+	0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10, 0x00,
+	0x80, 0x00, 0x00, 0xaa, 0x00, 0x38, 0x9b, 0x71,
+}
+
+// WAVFormat describes the PCM layout written to the fmt chunk.
+type WAVFormat struct {
+	SampleRate int
+	BitDepth   int
+	Channels   int
+	// Extensible writes WAVE_FORMAT_EXTENSIBLE instead of WAVE_FORMAT_PCM.
+	Extensible bool
+	// ChannelMask is the speaker mask written in the extensible extension.
+	ChannelMask uint32
+}
+
+// DefaultWAVFormat returns 44.1kHz 16-bit stereo PCM.
+func DefaultWAVFormat() WAVFormat {
+	return WAVFormat{
+		SampleRate: wavDefaultSampleRate,
+		BitDepth:   BitDepth16,
+		Channels:   wavDefaultChannels,
+	}
+}
+
+// BlockAlign returns the size in bytes of one sample frame.
+func (f WAVFormat) BlockAlign() int {
+	return PCMBytesPerSample(f.BitDepth) * f.Channels
+}
+
+// FmtChunk returns a spec-conformant fmt chunk for this format.
+func (f WAVFormat) FmtChunk() RIFFChunk {
+	return RIFFChunk{ID: "fmt ", Data: f.fmtData(wavExtensibleCbSize)}
+}
+
+// fmtData serializes the fmt payload. cbSize is only written for extensible formats,
+// so that callers can make it disagree with the actual extension size.
+//
+//nolint:gosec // G115: format fields are small test-controlled values.
+func (f WAVFormat) fmtData(cbSize uint16) []byte {
+	blockAlign := f.BlockAlign()
+
+	out := make([]byte, 0, wavFmtSizePCM+2+wavExtensibleCbSize)
+
+	if f.Extensible {
+		out = binary.LittleEndian.AppendUint16(out, wavFormatExtensible)
+	} else {
+		out = binary.LittleEndian.AppendUint16(out, wavFormatPCM)
+	}
+
+	out = binary.LittleEndian.AppendUint16(out, uint16(f.Channels))
+	out = binary.LittleEndian.AppendUint32(out, uint32(f.SampleRate))
+	out = binary.LittleEndian.AppendUint32(out, uint32(f.SampleRate*blockAlign))
+	out = binary.LittleEndian.AppendUint16(out, uint16(blockAlign))
+	out = binary.LittleEndian.AppendUint16(out, uint16(PCMBytesPerSample(f.BitDepth)*bitsPerByte))
+
+	if !f.Extensible {
+		return out
+	}
+
+	out = binary.LittleEndian.AppendUint16(out, cbSize)
+	out = binary.LittleEndian.AppendUint16(out, uint16(f.BitDepth))
+	out = binary.LittleEndian.AppendUint32(out, f.ChannelMask)
+
+	return append(out, ksDataFormatSubtypePCM...)
+}
+
+// ErrUnknownWAVMalformation is returned by BuildWAV for a value outside the WAVMalformation constants.
+var ErrUnknownWAVMalformation = errors.New("unknown WAV malformation")
+
+// WAVMalformation selects a structural defect to inject into a WAV fixture. Its value names the fixture file.
+type WAVMalformation string
+
+// WAV malformations seen in the wild.
+const (
+	// WAVWellFormed produces a spec-conformant file.
+	WAVWellFormed WAVMalformation = "well-formed"
+	// WAVDataSizeZero declares RIFF and data sizes of 0, as left by an interrupted streaming writer.
+	WAVDataSizeZero WAVMalformation = "data-size-zero"
+	// WAVDataSizeMax declares RIFF and data sizes of 0xFFFFFFFF, as used by streamed recordings.
+	WAVDataSizeMax WAVMalformation = "data-size-max"
+	// WAVRIFFSizeTooLarge declares a RIFF size larger than the file.
+	WAVRIFFSizeTooLarge WAVMalformation = "riff-size-too-large"
+	// WAVRIFFSizeTooSmall declares a RIFF size of half the file, ending inside the data chunk.
+	WAVRIFFSizeTooSmall WAVMalformation = "riff-size-too-small"
+	// WAVOddChunkNoPad inserts an odd-sized chunk before data without its pad byte.
+	WAVOddChunkNoPad WAVMalformation = "odd-chunk-no-pad"
+	// WAVFmtAfterData places the fmt chunk after the data chunk.
+	WAVFmtAfterData WAVMalformation = "fmt-after-data"
+	// WAVDuplicateData splits the audio across two consecutive data chunks.
+	WAVDuplicateData WAVMalformation = "duplicate-data"
+	// WAVUnknownChunkBeforeFmt places an unregistered chunk before fmt.
+	WAVUnknownChunkBeforeFmt WAVMalformation = "unknown-chunk-before-fmt"
+	// WAVExtensibleCbSizeLie writes WAVE_FORMAT_EXTENSIBLE with a cbSize larger than the fmt chunk.
+	WAVExtensibleCbSizeLie WAVMalformation = "extensible-cbsize-lie"
+)
+
+// WAVChunks is a set of optional metadata chunks, combined with bitwise OR.
+type WAVChunks uint
+
+// Optional WAV metadata chunks.
+const (
+	WAVChunkListInfo WAVChunks = 1 << iota
+	WAVChunkID3
+	WAVChunkBext
+	WAVChunkIXML
+	WAVChunkCue
+	WAVChunkSmpl
+	WAVChunkFact
+
+	// WAVChunksNone adds no metadata chunk.
+	WAVChunksNone WAVChunks = 0
+	// WAVChunksAll adds every metadata chunk.
+	WAVChunksAll = WAVChunkListInfo | WAVChunkID3 | WAVChunkBext | WAVChunkIXML |
+		WAVChunkCue | WAVChunkSmpl | WAVChunkFact
+)
+
+// String returns the chunk set as a dash-separated list, suitable for file names.
+func (c WAVChunks) String() string {
+	names := []struct {
+		flag WAVChunks
+		name string
+	}{
+		{WAVChunkListInfo, "info"},
+		{WAVChunkID3, "id3"},
+		{WAVChunkBext, "bext"},
+		{WAVChunkIXML, "ixml"},
+		{WAVChunkCue, "cue"},
+		{WAVChunkSmpl, "smpl"},
+		{WAVChunkFact, "fact"},
+	}
+
+	var parts []string
+
+	for _, entry := range names {
+		if c&entry.flag != 0 {
+			parts = append(parts, entry.name)
+		}
+	}
+
+	if len(parts) == 0 {
+		return "nochunks"
+	}
+
+	return strings.Join(parts, "-")
+}
+
+// BuildWAV assembles a WAV container around pcm with the requested malformation and metadata chunks.
+// Metadata chunks before data are ordered fact, bext, iXML, LIST; cue, smpl and id3 follow data.
+// The returned RIFFFile can be further altered before serialization.
+func BuildWAV(format WAVFormat, pcm []byte, malformation WAVMalformation, chunks WAVChunks) (RIFFFile, error) {
+	if malformation == WAVExtensibleCbSizeLie {
+		format.Extensible = true
+	}
+
+	fmtChunk := format.FmtChunk()
+	if malformation == WAVExtensibleCbSizeLie {
+		fmtChunk.Data = format.fmtData(wavExtensibleCbSize + wavCbSizeLieExtra)
+	}
+
+	frames := 0
+	if blockAlign := format.BlockAlign(); blockAlign > 0 {
+		frames = len(pcm) / blockAlign
+	}
+
+	var before, after []RIFFChunk
+
+	if chunks&WAVChunkFact != 0 {
+		before = append(before, wavFactChunk(frames))
+	}
+
+	if chunks&WAVChunkBext != 0 {
+		before = append(before, DefaultBext().Chunk())
+	}
+
+	if chunks&WAVChunkIXML != 0 {
+		before = append(before, DefaultIXML().Chunk())
+	}
+
+	if chunks&WAVChunkListInfo != 0 {
+		before = append(before, DefaultInfoTags().Chunk())
+	}
+
+	if chunks&WAVChunkCue != 0 {
+		after = append(after, wavCueChunk(frames))
+	}
+
+	if chunks&WAVChunkSmpl != 0 {
+		after = append(after, wavSmplChunk(format, frames))
+	}
+
+	if chunks&WAVChunkID3 != 0 {
+		after = append(after, RIFFChunk{
+			ID:   "id3 ",
+			Data: EncodeID3v2(ID3v24, []ID3Frame{ID3TextFrame(ID3v24, "TIT2", "Test Title")}),
+		})
+	}
+
+	dataChunks := []RIFFChunk{{ID: "data", Data: pcm}}
+
+	file := RIFFFile{FormType: "WAVE"}
+
+	switch malformation {
+	case WAVDataSizeZero:
+		dataChunks[0].OverrideSize, dataChunks[0].DeclaredSize = true, 0
+		file.OverrideSize, file.DeclaredSize = true, 0
+	case WAVDataSizeMax:
+		dataChunks[0].OverrideSize, dataChunks[0].DeclaredSize = true, wavStreamingSize
+		file.OverrideSize, file.DeclaredSize = true, wavStreamingSize
+	case WAVOddChunkNoPad:
+		before = append(before, RIFFChunk{ID: "agar", Data: []byte("odd"), OmitPad: true})
+	case WAVDuplicateData:
+		half := (frames / 2) * format.BlockAlign()
+		dataChunks = []RIFFChunk{{ID: "data", Data: pcm[:half]}, {ID: "data", Data: pcm[half:]}}
+	case WAVWellFormed, WAVRIFFSizeTooLarge, WAVRIFFSizeTooSmall, WAVFmtAfterData, WAVUnknownChunkBeforeFmt,
+		WAVExtensibleCbSizeLie:
+	default:
+		return RIFFFile{}, fmt.Errorf("%w: %q", ErrUnknownWAVMalformation, malformation)
+	}
+
+	switch malformation {
+	case WAVFmtAfterData:
+		file.Chunks = append(file.Chunks, before...)
+		file.Chunks = append(file.Chunks, dataChunks...)
+		file.Chunks = append(file.Chunks, fmtChunk)
+	case WAVUnknownChunkBeforeFmt:
+		file.Chunks = append(file.Chunks, RIFFChunk{ID: "agar", Data: []byte("unregistered chunk")}, fmtChunk)
+		file.Chunks = append(file.Chunks, before...)
+		file.Chunks = append(file.Chunks, dataChunks...)
+	default:
+		file.Chunks = append(file.Chunks, fmtChunk)
+		file.Chunks = append(file.Chunks, before...)
+		file.Chunks = append(file.Chunks, dataChunks...)
+	}
+
+	file.Chunks = append(file.Chunks, after...)
+
+	switch malformation {
+	case WAVRIFFSizeTooLarge:
+		file.OverrideSize = true
+		file.DeclaredSize = uint32(len(file.Bytes())) + wavRIFFSizeMismatch //nolint:gosec // G115: small fixture.
+	case WAVRIFFSizeTooSmall:
+		file.OverrideSize = true
+		file.DeclaredSize = uint32(len(file.Bytes())) / 2 //nolint:gosec // G115: small fixture.
+	default:
+	}
+
+	return file, nil
+}
+
+// WAVFixture returns path to a 1 second 16-bit 44.1kHz stereo WAV with the given malformation
+// and metadata chunks. Audio is deterministic white noise from GenerateWhiteNoise.
+func WAVFixture(data test.Data, helpers test.Helpers, malformation WAVMalformation, chunks WAVChunks) string {
+	helpers.T().Helper()
+
+	format := DefaultWAVFormat()
+	pcm := GenerateWhiteNoise(format.SampleRate, format.BitDepth, format.Channels, wavFixtureDurationSec)
+
+	file, err := BuildWAV(format, pcm, malformation, chunks)
+	if err != nil {
+		helpers.T().Log(err.Error())
+		helpers.T().FailNow()
+	}
+
+	path := filepath.Join(data.Temp().Dir(), "wav-"+string(malformation)+"-"+chunks.String()+".wav")
+	writeFixtureFile(helpers.T(), path, file.Bytes())
+
+	return path
+}
+
+// GenuineWAV16bit44k returns path to a well-formed WAV with no metadata chunks.
+func GenuineWAV16bit44k(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	return WAVFixture(data, helpers, WAVWellFormed, WAVChunksNone)
+}
+
+// wavFactChunk returns a fact chunk holding the sample frame count.
+func wavFactChunk(frames int) RIFFChunk {
+	//nolint:gosec // G115: small fixture.
+	return RIFFChunk{ID: "fact", Data: binary.LittleEndian.AppendUint32(nil, uint32(frames))}
+}
+
+// wavCueChunk returns a cue chunk with a single cue point at the middle of the audio.
+//
+//nolint:gosec // G115: small fixture.
+func wavCueChunk(frames int) RIFFChunk {
+	data := binary.LittleEndian.AppendUint32(nil, 1)                // number of cue points
+	data = binary.LittleEndian.AppendUint32(data, 1)                // dwName
+	data = binary.LittleEndian.AppendUint32(data, uint32(frames/2)) // dwPosition
+	data = append(data, "data"...)                                  // fccChunk
+	data = binary.LittleEndian.AppendUint32(data, 0)                // dwChunkStart
+	data = binary.LittleEndian.AppendUint32(data, 0)                // dwBlockStart
+	data = binary.LittleEndian.AppendUint32(data, uint32(frames/2)) // dwSampleOffset
+
+	return RIFFChunk{ID: "cue ", Data: data}
+}
+
+// wavSmplChunk returns a smpl chunk with a single forward loop over the second half of the audio.
+//
+//nolint:gosec // G115: small fixture.
+func wavSmplChunk(format WAVFormat, frames int) RIFFChunk {
+	const (
+		nanosecondsPerSecond = 1_000_000_000
+		midiMiddleC          = 60
+	)
+
+	samplePeriod := uint32(nanosecondsPerSecond / format.SampleRate)
+
+	data := binary.LittleEndian.AppendUint32(nil, 0)                // manufacturer
+	data = binary.LittleEndian.AppendUint32(data, 0)                // product
+	data = binary.LittleEndian.AppendUint32(data, samplePeriod)     // sample period
+	data = binary.LittleEndian.AppendUint32(data, midiMiddleC)      // MIDI unity note
+	data = binary.LittleEndian.AppendUint32(data, 0)                // pitch fraction
+	data = binary.LittleEndian.AppendUint32(data, 0)                // SMPTE format
+	data = binary.LittleEndian.AppendUint32(data, 0)                // SMPTE offset
+	data = binary.LittleEndian.AppendUint32(data, 1)                // loop count
+	data = binary.LittleEndian.AppendUint32(data, 0)                // sampler data
+	data = binary.LittleEndian.AppendUint32(data, 0)                // loop cue ID
+	data = binary.LittleEndian.AppendUint32(data, 0)                // loop type (forward)
+	data = binary.LittleEndian.AppendUint32(data, uint32(frames/2)) // loop start
+	data = binary.LittleEndian.AppendUint32(data, uint32(frames-1)) // loop end
+	data = binary.LittleEndian.AppendUint32(data, 0)                // fraction
+	data = binary.LittleEndian.AppendUint32(data, 0)                // play count (infinite)
+
+	return RIFFChunk{ID: "smpl", Data: data}
+}